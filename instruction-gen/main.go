@@ -235,6 +235,12 @@ func postprocessInstruction(opcode string, inst *instruction, isPrefixed bool) {
 		}
 	}
 
+	if inst.Mnemonic == "STOP" {
+		// STOP is encoded as 0x10 0x00, but the spec only declares the opcode.
+		// The second byte is skipped, such that execution continues after it.
+		inst.Bytes = 2
+	}
+
 	if inst.Mnemonic == "XOR" || inst.Mnemonic == "AND" || inst.Mnemonic == "OR" || inst.Mnemonic == "CP" || inst.Mnemonic == "SUB" {
 		// 8bit logical and arithmetic instructions take two arguments, A and X (X=reg8|reg16Ptr).
 		// The spec does not include the implicit A argument. Adding the argument to
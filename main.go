@@ -5,8 +5,13 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"image/png"
+	"io"
 	"log"
 	"math"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/alecthomas/kong"
@@ -16,19 +21,132 @@ import (
 	_ "github.com/skelterjohn/go.wde/cocoa"
 )
 
-var shadeToColor = []color.RGBA{
-	color.RGBA{R: 155, G: 188, B: 15, A: 255}, // "white"
-	color.RGBA{R: 139, G: 172, B: 15, A: 255},
-	color.RGBA{R: 48, G: 98, B: 48, A: 255},
-	color.RGBA{R: 15, G: 56, B: 15, A: 255}, // "black"
+// defaultKeyBindings maps keyboard keys (as reported by wde) to joypad buttons,
+// unless overridden with --bind
+var defaultKeyBindings = emulator.KeyMap{
+	wde.KeyUpArrow:    emulator.ButtonUp,
+	wde.KeyDownArrow:  emulator.ButtonDown,
+	wde.KeyLeftArrow:  emulator.ButtonLeft,
+	wde.KeyRightArrow: emulator.ButtonRight,
+	wde.KeyZ:          emulator.ButtonA,
+	wde.KeyX:          emulator.ButtonB,
+	wde.KeyReturn:     emulator.ButtonStart,
+	wde.KeyLeftShift:  emulator.ButtonSelect,
+	wde.KeyRightShift: emulator.ButtonSelect,
+}
+
+// fastForwardKey runs the emulator uncapped while held
+const fastForwardKey = wde.KeyTab
+
+// reservedKeys are handled by the front-end, and can not be bound to buttons
+var reservedKeys = map[string]string{
+	wde.KeyEscape:  "quit",
+	wde.KeyP:       "screenshot",
+	wde.KeyF12:     "screenshot",
+	fastForwardKey: "fast forward",
 }
 
 type runCmd struct {
-	BootROM string `help:"Use boot ROM" type:"path"`
+	BootROM   string `help:"Use boot ROM" type:"path"`
+	SerialIn  string `help:"Read incoming serial bytes from file or pipe" type:"path"`
+	SerialOut string `help:"Write outgoing serial bytes to file or pipe" type:"path"`
+	Palette   string `help:"Color palette (bgb, green, ice-cream, kirokaze, pocket)" default:"green"`
+
+	Bind map[string]string `help:"Bind a key to a button in addition to the default bindings, e.g. --bind a=A --bind s=B (keys as named by wde, e.g. z, return, left_shift; escape, p, f12 and tab are reserved)" placeholder:"KEY=BUTTON"`
+
+	DebugVideo bool `help:"Show the background map and the visible screen area in an additional window"`
+
+	Path string `arg name:"path" help:"Path to ROM" type:"path"`
+}
 
+type infoCmd struct {
 	Path string `arg name:"path" help:"Path to ROM" type:"path"`
 }
 
+func (i *infoCmd) Run() error {
+	header, err := emulator.ReadCartridgeHeader(i.Path)
+	if err != nil {
+		return err
+	}
+
+	writeInfo(os.Stdout, header)
+	return nil
+}
+
+// writeInfo writes a human readable description of a cartridge header to w
+func writeInfo(w io.Writer, header emulator.CartridgeHeader) {
+	supported := "supported"
+	if !header.MBCSupported() {
+		supported = "unsupported"
+	}
+
+	cgb := "no"
+	switch header.CGBFlag {
+	case 0x80:
+		cgb = "supported"
+	case 0xC0:
+		cgb = "required"
+	}
+
+	sgb := "no"
+	if header.SGBFlag == 0x03 {
+		sgb = "supported"
+	}
+
+	region := "Japan"
+	if header.Destination != 0x00 {
+		region = "Overseas"
+	}
+
+	fmt.Fprintf(w, "Title:           %s\n", header.Title)
+	fmt.Fprintf(w, "MBC type:        %s (%s)\n", header.MBCName(), supported)
+	fmt.Fprintf(w, "ROM size:        %s\n", romSize(header))
+	fmt.Fprintf(w, "RAM size:        %s\n", ramSize(header))
+	fmt.Fprintf(w, "CGB:             %s\n", cgb)
+	fmt.Fprintf(w, "SGB:             %s\n", sgb)
+	fmt.Fprintf(w, "Region:          %s\n", region)
+	fmt.Fprintf(w, "Header checksum: %s\n", checksumStatus(fmt.Sprintf("%#02x", header.HeaderChecksum), fmt.Sprintf("%#02x", header.ComputedHeaderChecksum), header.HeaderChecksumValid()))
+	fmt.Fprintf(w, "Global checksum: %s\n", checksumStatus(fmt.Sprintf("%#04x", header.GlobalChecksum), fmt.Sprintf("%#04x", header.ComputedGlobalChecksum), header.GlobalChecksumValid()))
+}
+
+// romSize describes the ROM size declared by the header
+func romSize(header emulator.CartridgeHeader) string {
+	if !header.ROMSizeKnown() {
+		return fmt.Sprintf("unknown (code %#02x)", header.ROMSizeCode)
+	}
+	return fmt.Sprintf("%d KB (%s)", header.ROMSize/1024, banks(header.ROMBanks))
+}
+
+// ramSize describes the external RAM size declared by the header
+func ramSize(header emulator.CartridgeHeader) string {
+	switch {
+	case !header.RAMSizeKnown():
+		return fmt.Sprintf("unknown (code %#02x)", header.RAMSizeCode)
+	case header.RAMSize == 0:
+		return "none"
+	case header.RAMSize < 8*1024:
+		return fmt.Sprintf("%d KB", header.RAMSize/1024) // less than a bank
+	}
+	return fmt.Sprintf("%d KB (%s)", header.RAMSize/1024, banks(header.RAMBanks))
+}
+
+// banks describes a number of memory banks
+func banks(n int) string {
+	if n == 1 {
+		return "1 bank"
+	}
+	return fmt.Sprintf("%d banks", n)
+}
+
+// checksumStatus describes a checksum declared by the header, and the computed
+// checksum if they differ
+func checksumStatus(declared string, computed string, valid bool) string {
+	if valid {
+		return fmt.Sprintf("%s (valid)", declared)
+	}
+	return fmt.Sprintf("%s (invalid, computed %s)", declared, computed)
+}
+
 type sprite struct {
 }
 
@@ -53,16 +171,193 @@ func (s sprite) At(xx, y int) color.Color {
 	return color.Black
 }
 
+// keyBindings returns the default key bindings with bindings (key to button
+// name) applied on top
+func keyBindings(bindings map[string]string) (emulator.KeyMap, error) {
+	keyMap := defaultKeyBindings.Clone()
+	for key, name := range bindings {
+		if action, ok := reservedKeys[key]; ok {
+			return nil, fmt.Errorf("key %q is reserved for %s and can not be bound to a button", key, action)
+		}
+
+		button, ok := emulator.LookupButton(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown button %q for key %q, expected one of: Up, Down, Left, Right, A, B, Start, Select", name, key)
+		}
+		keyMap[key] = button
+	}
+	return keyMap, nil
+}
+
+// serialReader returns a callback reading incoming serial bytes from r
+//
+// Reads block until a byte is available, which keeps two emulators connected
+// through named pipes in lockstep. Once r is exhausted the callback returns
+// 0xFF, as if no device is connected.
+func serialReader(r io.Reader) emulator.SerialReceiveCallback {
+	return func() uint8 {
+		buffer := make([]byte, 1)
+		if _, err := io.ReadFull(r, buffer); err != nil {
+			return 0xFF
+		}
+		return buffer[0]
+	}
+}
+
+// serialWriter returns a callback writing outgoing serial bytes to w
+func serialWriter(w io.Writer) emulator.SerialDataCallback {
+	return func(data uint8) {
+		if _, err := w.Write([]byte{data}); err != nil {
+			log.Printf("WARNING: unable to write serial data: %s", err)
+		}
+	}
+}
+
+// screenshotPath returns the path of a screenshot of the ROM at romPath taken
+// at time t, e.g. tetris-20200102-150405.png in the current directory
+func screenshotPath(romPath string, t time.Time) string {
+	name := strings.TrimSuffix(filepath.Base(romPath), filepath.Ext(romPath))
+	return fmt.Sprintf("%s-%s.png", name, t.Format("20060102-150405"))
+}
+
+// writeScreenshot encodes img as a PNG file at path
+func writeScreenshot(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// viewportColor is used to outline the visible screen area in the background
+// map shown by --debug-video
+var viewportColor = color.RGBA{R: 255, A: 255}
+
+// outlineViewport outlines the 160x144 screen area at scroll position x, y on
+// an image of the 256x256 background map, wrapping around its edges
+func outlineViewport(img *image.RGBA, x, y uint8, c color.RGBA) {
+	for dx := 0; dx < 160; dx++ {
+		img.SetRGBA((int(x)+dx)%256, int(y), c)
+		img.SetRGBA((int(x)+dx)%256, (int(y)+143)%256, c)
+	}
+	for dy := 0; dy < 144; dy++ {
+		img.SetRGBA(int(x), (int(y)+dy)%256, c)
+		img.SetRGBA((int(x)+159)%256, (int(y)+dy)%256, c)
+	}
+}
+
+// backgroundImage renders a background map sent by the emulator, with the
+// visible screen area outlined
+func backgroundImage(background *emulator.BackgroundMap, palette emulator.Palette) *image.RGBA {
+	img := background.Frame.ToImage(palette)
+	outlineViewport(img, background.ScrollX, background.ScrollY, viewportColor)
+	return img
+}
+
+// drawScaled draws img centered on the window, scaled to fill it
+func drawScaled(w wde.Window, img *image.RGBA) {
+	drawScaledRows(w, img, 0, img.Bounds().Dy())
+}
+
+// drawScaledRows is like drawScaled, but only draws rows minRow (inclusive) to
+// maxRow (exclusive) of img, leaving the rest of the window as is
+func drawScaledRows(w wde.Window, img *image.RGBA, minRow, maxRow int) {
+	width := img.Bounds().Dx()
+	height := img.Bounds().Dy()
+	scale := int(math.Min(float64(w.Screen().Bounds().Max.X/width), float64(w.Screen().Bounds().Max.Y/height)))
+
+	screenWidth := width * scale
+	screenHeight := height * scale
+
+	centerX := w.Screen().Bounds().Max.X / 2
+	centerY := w.Screen().Bounds().Max.Y / 2
+
+	minX := centerX - screenWidth/2
+	minY := centerY - screenHeight/2
+	maxX := centerX + screenWidth/2
+	screenSize := image.Rect(minX, minY+minRow*scale, maxX, minY+maxRow*scale)
+
+	buffer := image.NewRGBA(screenSize)
+
+	for y := minRow; y < maxRow; y++ {
+		for x := 0; x < width; x++ {
+			c := img.RGBAAt(x, y)
+			for ys := minY + y*scale; ys < minY+y*scale+scale; ys++ {
+				for xs := minX + x*scale; xs < minX+x*scale+scale; xs++ {
+					buffer.SetRGBA(xs, ys, c)
+				}
+			}
+		}
+	}
+
+	w.Screen().CopyRGBA(buffer, screenSize)
+	w.FlushImage(screenSize)
+}
+
 func (r *runCmd) Run() error {
-	ctx := context.Background()
-	e := emulator.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	palette, ok := emulator.LookupPalette(r.Palette)
+	if !ok {
+		return fmt.Errorf("unknown palette %q, expected one of: %s", r.Palette, strings.Join(emulator.PaletteNames(), ", "))
+	}
 
+	keyMap, err := keyBindings(r.Bind)
+	if err != nil {
+		return err
+	}
+
+	opts := []emulator.OptionFunc{
+		// Frame updates report the changed rows, such that only those are redrawn
+		emulator.WithFrameUpdates(),
+		// Keep running games that access unused I/O registers, rather than crash
+		emulator.WithStrictMemory(false),
+	}
+	if r.DebugVideo {
+		opts = append(opts, emulator.WithBackgroundMap())
+	}
+	if r.SerialIn != "" {
+		f, err := os.Open(r.SerialIn)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		opts = append(opts, emulator.WithSerialReceiveCallback(serialReader(f)))
+	}
+	if r.SerialOut != "" {
+		f, err := os.OpenFile(r.SerialOut, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		opts = append(opts, emulator.WithSerialDataCallback(serialWriter(f)))
+	}
+
+	e := emulator.New(opts...)
+
+	// done is closed once the emulator stopped, after writing the save file
+	done := make(chan struct{})
 	go func() {
 		if err := e.Run(ctx, r.Path, r.BootROM); err != nil {
 			log.Panicln(err)
 		}
+		close(done)
 	}()
 
+	// stop stops the emulator and waits for it to return before closing the
+	// windows, such that battery-backed RAM is saved
+	stop := func() {
+		cancel()
+		<-done
+		wde.Stop()
+	}
+
 	go func() {
 		frames := 0
 		ticker := time.Tick(time.Second)
@@ -79,6 +374,29 @@ func (r *runCmd) Run() error {
 
 		events := w.EventChan()
 
+		// debugWindow shows the background map if --debug-video is set, until
+		// it is closed
+		var debugWindow wde.Window
+		var debugEvents <-chan interface{}
+		if r.DebugVideo {
+			debugWindow, err = wde.NewWindow(512, 512)
+			if err != nil {
+				log.Panicln(err)
+			}
+			debugWindow.SetTitle("gbemu | background")
+			debugWindow.LockSize(true)
+			debugWindow.Show()
+			debugEvents = debugWindow.EventChan()
+		}
+
+		// screen is the most recent frame at native resolution, used for
+		// screenshots
+		var screen *image.RGBA
+
+		// drawn is true once the first frame was drawn in full, after which
+		// only the rows that changed are redrawn
+		drawn := false
+
 		for {
 			select {
 
@@ -89,44 +407,58 @@ func (r *runCmd) Run() error {
 			case event := <-events:
 				switch v := event.(type) {
 				case wde.CloseEvent:
-					log.Panicln("stop") // TODO implement proper stop
+					stop()
+					return
 				case wde.KeyTypedEvent:
 					switch v.Key {
 					case wde.KeyEscape:
-						log.Panicln("stop") // TODO implement proper stop
+						stop()
+						return
+					case wde.KeyF12, wde.KeyP:
+						if screen == nil {
+							break
+						}
+						path := screenshotPath(r.Path, time.Now())
+						if err := writeScreenshot(path, screen); err != nil {
+							log.Printf("WARNING: unable to write screenshot: %s", err)
+						} else {
+							log.Printf("wrote screenshot to %s", path)
+						}
+					}
+				case wde.KeyDownEvent:
+					if v.Key == fastForwardKey {
+						e.SetSpeed(0)
+					} else if button, ok := keyMap.Button(v.Key); ok {
+						e.PressButton(button)
+					}
+				case wde.KeyUpEvent:
+					if v.Key == fastForwardKey {
+						e.SetSpeed(1)
+					} else if button, ok := keyMap.Button(v.Key); ok {
+						e.ReleaseButton(button)
 					}
 				}
 
-			case frame := <-e.FrameChan:
-				// scale original buffer to fill window
-				scale := int(math.Min(float64(w.Screen().Bounds().Max.X/160), float64(w.Screen().Bounds().Max.Y/144)))
-
-				screenWidth := 160 * scale
-				screenHeight := 144 * scale
-
-				centerX := w.Screen().Bounds().Max.X / 2
-				centerY := w.Screen().Bounds().Max.Y / 2
-
-				minX := centerX - screenWidth/2
-				minY := centerY - screenHeight/2
-				maxX := centerX + screenWidth/2
-				maxY := centerY + screenHeight/2
-				screenSize := image.Rect(minX, minY, maxX, maxY)
-
-				buffer := image.NewRGBA(screenSize)
+			case event := <-debugEvents:
+				if _, ok := event.(wde.CloseEvent); ok {
+					// Only close the debug window, the emulator keeps running
+					debugWindow.Close()
+					debugWindow = nil
+					debugEvents = nil
+				}
 
-				for y, row := range frame {
-					for x, shade := range row {
-						for ys := minY + y*scale; ys < minY+y*scale+scale; ys++ {
-							for xs := minX + x*scale; xs < minX+x*scale+scale; xs++ {
-								buffer.Set(xs, ys, shadeToColor[shade])
-							}
-						}
-					}
+			case update := <-e.FrameUpdateChan:
+				screen = update.Frame.ToImage(palette)
+				if !drawn {
+					drawScaled(w, screen)
+					drawn = true
+				} else if rows := update.DirtyRows; len(rows) > 0 {
+					drawScaledRows(w, screen, rows[0], rows[len(rows)-1]+1)
 				}
 
-				w.Screen().CopyRGBA(buffer, screenSize)
-				w.FlushImage(screenSize)
+				if debugWindow != nil && update.Background != nil {
+					drawScaled(debugWindow, backgroundImage(update.Background, palette))
+				}
 
 				frames++
 			}
@@ -140,7 +472,8 @@ func (r *runCmd) Run() error {
 }
 
 var root struct {
-	Run runCmd `cmd help:"run ROM"`
+	Run  runCmd  `cmd help:"run ROM"`
+	Info infoCmd `cmd help:"print the cartridge header of ROM"`
 }
 
 func main() {
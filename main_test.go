@@ -1,9 +1,194 @@
 package main
 
 import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/sema/gbemu/pkg/emulator"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMain(t *testing.T) {
 
 }
+
+func TestSerialPipeExchange(t *testing.T) {
+	pipeReader, pipeWriter := io.Pipe()
+
+	// Emulator A sends a byte that emulator B receives over the pipe
+	send := serialWriter(pipeWriter)
+	receive := serialReader(pipeReader)
+
+	go func() {
+		send(0x42)
+		pipeWriter.Close()
+	}()
+
+	require.Equal(t, uint8(0x42), receive())
+	require.Equal(t, uint8(0xFF), receive(), "expected 0xFF once the pipe is closed")
+}
+
+func TestSerialWriterWritesOutgoingBytes(t *testing.T) {
+	var out bytes.Buffer
+	send := serialWriter(&out)
+
+	send(0x01)
+	send(0x02)
+
+	require.Equal(t, []byte{0x01, 0x02}, out.Bytes())
+}
+
+func TestScreenshotPath(t *testing.T) {
+	at := time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+	require.Equal(t, "tetris-20200102-150405.png", screenshotPath("roms/tetris.gb", at))
+}
+
+func TestWriteScreenshotAtNativeResolution(t *testing.T) {
+	frame := make(emulator.Frame, 144)
+	for y := range frame {
+		frame[y] = make([]emulator.Shade, 160)
+	}
+	frame[0][0] = emulator.Shade(3)
+
+	dir, err := ioutil.TempDir("", "gbemu-screenshot")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "screenshot.png")
+	require.NoError(t, writeScreenshot(path, frame.ToImage(emulator.PalettePocket)))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 160, 144), img.Bounds())
+	require.Equal(t, emulator.PalettePocket[3], img.At(0, 0))
+}
+
+func TestWriteScreenshotReturnsError(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "gbemu-missing", "screenshot.png")
+	require.Error(t, writeScreenshot(path, image.NewRGBA(image.Rect(0, 0, 160, 144))))
+}
+
+func TestOutlineViewportWrapsAroundBackground(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	outlineViewport(img, 200, 220, viewportColor)
+
+	// Corners of the screen area, wrapping around the right and lower edges
+	require.Equal(t, viewportColor, img.RGBAAt(200, 220))
+	require.Equal(t, viewportColor, img.RGBAAt((200+159)%256, 220))
+	require.Equal(t, viewportColor, img.RGBAAt(200, (220+143)%256))
+	require.Equal(t, viewportColor, img.RGBAAt((200+159)%256, (220+143)%256))
+
+	// Inside the screen area is left as is
+	require.Equal(t, color.RGBA{}, img.RGBAAt(201, 221))
+}
+
+func TestKeyBindingsRouteCustomBindings(t *testing.T) {
+	keyMap, err := keyBindings(map[string]string{"a": "a", "s": "Start"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		key  string
+		want emulator.Button
+	}{
+		{key: "a", want: emulator.ButtonA},
+		{key: "s", want: emulator.ButtonStart},
+		{key: "z", want: emulator.ButtonA}, // default binding
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			b, ok := keyMap.Button(tt.key)
+			require.True(t, ok)
+			require.Equal(t, tt.want, b, "got %s", b)
+		})
+	}
+
+	_, ok := defaultKeyBindings.Button("a")
+	require.False(t, ok, "expected default bindings to be unchanged")
+}
+
+func TestKeyBindingsRejectsUnknownButton(t *testing.T) {
+	_, err := keyBindings(map[string]string{"a": "turbo"})
+	require.Error(t, err)
+}
+
+func TestKeyBindingsRejectsReservedKeys(t *testing.T) {
+	for _, key := range []string{"escape", "p", "f12", "tab"} {
+		_, err := keyBindings(map[string]string{key: "A"})
+		require.Error(t, err, "expected binding %q to be rejected", key)
+	}
+}
+
+func TestWriteInfoDescribesHeader(t *testing.T) {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x0134:], "TETRIS")
+	rom[0x0146] = 0x03 // SGB
+	rom[0x0147] = 0x03 // MBC1+RAM+BATTERY
+	rom[0x0149] = 0x02 // 8KB RAM
+	rom[0x014A] = 0x01 // overseas
+	rom[0x014D] = 0x42 // invalid header checksum
+
+	dir, err := ioutil.TempDir("", "gbemu-info")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "tetris.gb")
+	require.NoError(t, ioutil.WriteFile(path, rom, 0644))
+
+	header, err := emulator.ReadCartridgeHeader(path)
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	writeInfo(&out, header)
+
+	require.Equal(t, ""+
+		"Title:           TETRIS\n"+
+		"MBC type:        MBC1+RAM+BATTERY (supported)\n"+
+		"ROM size:        32 KB (2 banks)\n"+
+		"RAM size:        8 KB (1 bank)\n"+
+		"CGB:             no\n"+
+		"SGB:             supported\n"+
+		"Region:          Overseas\n"+
+		"Header checksum: 0x42 (invalid, computed 0x03)\n"+
+		"Global checksum: 0x0000 (invalid, computed 0x0226)\n",
+		out.String())
+}
+
+func TestWriteInfoDescribesSizes(t *testing.T) {
+	tests := []struct {
+		name        string
+		romSizeCode byte
+		ramSizeCode byte
+		wantROM     string
+		wantRAM     string
+	}{
+		{name: "no RAM", romSizeCode: 0x00, ramSizeCode: 0x00, wantROM: "32 KB (2 banks)", wantRAM: "none"},
+		{name: "2KB RAM", romSizeCode: 0x05, ramSizeCode: 0x01, wantROM: "1024 KB (64 banks)", wantRAM: "2 KB"},
+		{name: "32KB RAM", romSizeCode: 0x01, ramSizeCode: 0x03, wantROM: "64 KB (4 banks)", wantRAM: "32 KB (4 banks)"},
+		{name: "unknown codes", romSizeCode: 0x52, ramSizeCode: 0x07, wantROM: "unknown (code 0x52)", wantRAM: "unknown (code 0x07)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rom := make([]byte, 0x8000)
+			rom[0x0148] = tt.romSizeCode
+			rom[0x0149] = tt.ramSizeCode
+
+			header, err := emulator.ParseCartridgeHeader(rom)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantROM, romSize(header))
+			require.Equal(t, tt.wantRAM, ramSize(header))
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package emulator
+
+import "time"
+
+// Clock is the source of wall time used by the emulator, to cap the speed of
+// the emulation (see WithSpeed) and to tick the real time clock of cartridges
+// with an RTC
+//
+// The time package is used by default. Tests may inject a fake clock using
+// WithClock to advance time deterministically.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// NewTicker returns a Ticker delivering a tick on every interval d
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks of a Clock, see time.Ticker
+type Ticker interface {
+	// Chan returns the channel on which ticks are delivered
+	Chan() <-chan time.Time
+
+	// Stop turns off the ticker, after which no more ticks are delivered
+	Stop()
+}
+
+// realClock implements Clock using the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) Chan() <-chan time.Time {
+	return t.C
+}
@@ -0,0 +1,123 @@
+package emulator
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a Clock where every ticker only ticks when a tick is sent on
+// ticks. The interval of every ticker created is recorded in intervals.
+type fakeClock struct {
+	now   time.Time
+	ticks chan time.Time
+
+	mutex     sync.Mutex
+	intervals []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:   time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC),
+		ticks: make(chan time.Time),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.intervals = append(c.intervals, d)
+	return fakeTicker{c.ticks}
+}
+
+// tickerIntervals returns the intervals of all tickers created so far
+func (c *fakeClock) tickerIntervals() []time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]time.Duration(nil), c.intervals...)
+}
+
+// runFrames runs e.RunFrames while ticking c until it returns, and returns the
+// number of ticks it took
+func (c *fakeClock) runFrames(t *testing.T, e *Emulator, frames int) int {
+	done := make(chan error)
+	go func() {
+		_, err := e.RunFrames(context.Background(), frames)
+		done <- err
+	}()
+
+	for ticks := 0; ; ticks++ {
+		select {
+		case c.ticks <- c.now:
+		case err := <-done:
+			require.NoError(t, err)
+			return ticks
+		}
+	}
+}
+
+type fakeTicker struct {
+	ticks chan time.Time
+}
+
+func (t fakeTicker) Chan() <-chan time.Time {
+	return t.ticks
+}
+
+func (t fakeTicker) Stop() {}
+
+func TestContinueEmitsOneFramePerClockTick(t *testing.T) {
+	clock := newFakeClock()
+	e := New(WithClock(clock))
+	e.Memory.Write8(0xC000, 0x18) // JR -2
+	e.Memory.Write8(0xC001, 0xFE)
+	e.CPU.ProgramCounter = 0xC000
+	e.Memory.Write8(0xFF40, 0x80) // enable LCD
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- e.Continue(ctx)
+	}()
+
+	const frames = 5
+	for i := 0; i < frames; i++ {
+		clock.ticks <- clock.now
+		<-e.FrameChan
+	}
+
+	// No further frames are emitted until the clock ticks again
+	select {
+	case <-e.FrameChan:
+		t.Fatal("expected no frame without a clock tick")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestWithClockTicksRealTimeClock(t *testing.T) {
+	path := writeBankedROM(t, 4, 0x10, 0x03) // MBC3+TIMER+RAM+BATTERY
+
+	clock := newFakeClock()
+	e := New(WithClock(clock))
+	require.NoError(t, e.Load(path, ""))
+
+	clock.now = clock.now.Add(61 * time.Second)
+	e.Memory.Write8(0x0000, 0x0A) // enable RAM and RTC
+	e.Memory.Write8(0x6000, 0x00) // latch
+	e.Memory.Write8(0x6000, 0x01)
+
+	e.Memory.Write8(0x4000, 0x08)
+	require.Equal(t, uint8(1), e.Memory.Read8(0xA000), "expected seconds")
+	e.Memory.Write8(0x4000, 0x09)
+	require.Equal(t, uint8(1), e.Memory.Read8(0xA000), "expected minutes")
+}
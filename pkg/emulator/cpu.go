@@ -28,6 +28,27 @@ var interruptAddresses = []uint16{
 // executed
 type instructionCalledCallback func(mnemonic string, pc uint16)
 
+// addInstructionCallback registers f to be called on every new instruction,
+// after any callback registered before it
+func (c *cpu) addInstructionCallback(f instructionCalledCallback) {
+	previous := c.instructionCallback
+	if previous == nil {
+		c.instructionCallback = f
+		return
+	}
+
+	c.instructionCallback = func(mnemonic string, pc uint16) {
+		previous(mnemonic, pc)
+		f(mnemonic, pc)
+	}
+}
+
+// opcodeHistogram counts the number of times each opcode has been executed
+type opcodeHistogram struct {
+	unprefixed [256]uint64
+	cbPrefixed [256]uint64
+}
+
 type cpu struct {
 	Memory         *memory
 	Registers      *registers
@@ -35,10 +56,48 @@ type cpu struct {
 	PowerOn        bool
 	lowPowerMode   bool
 
+	// stopped is true after STOP, until a joypad button is pressed (see
+	// shouldWakeFromStop)
+	stopped bool
+
+	// instructionAddress is the address of the instruction currently (or last)
+	// executed
+	instructionAddress uint16
+
+	// immediate is the immediate operand (d8, d16, a8, a16 or r8) of the
+	// instruction currently (or last) executed, read when it was decoded
+	immediate uint16
+
+	// cycles counts the machine cycles run by the CPU
+	cycles uint64
+
+	// haltBug is true if HALT was executed while interrupts were disabled and
+	// an interrupt was pending, in which case the program counter fails to
+	// increment after reading the next opcode (see shouldWakeFromLowPowerMode)
+	haltBug bool
+
 	Interrupts imeState
 
 	instructionCallback instructionCalledCallback
 
+	// histogram counts executed opcodes, if set
+	histogram *opcodeHistogram
+
+	// trace records recently executed instructions, if set
+	trace *instructionTrace
+
+	// tick progresses all other components by a single machine cycle. If set,
+	// data reads and writes are made on the machine cycle they happen on
+	// hardware (see syncDataAccess), with the remaining machine cycles of the
+	// instruction left to the caller of Cycle.
+	tick func()
+	// ticks is the number of machine cycles progressed using tick during the
+	// current instruction
+	ticks int
+	// accessCycle is the machine cycle (starting from 0) of the next data
+	// read or write of the current instruction
+	accessCycle int
+
 	options options
 }
 
@@ -52,7 +111,27 @@ func newCPU(memory *memory, registers *registers, options options) *cpu {
 	}
 }
 
+// Cycle runs the next instruction (or interrupt), and returns the number of
+// machine cycles it takes
+//
+// Of those, the first ticks machine cycles have already been progressed for
+// the other components (see tick).
 func (c *cpu) Cycle() int {
+	c.ticks = 0
+	cycles := c.cycle()
+	c.cycles += uint64(cycles)
+	return cycles
+}
+
+func (c *cpu) cycle() int {
+	if c.stopped {
+		if c.shouldWakeFromStop() {
+			c.stopped = false
+		} else {
+			return 1 // wait for a joypad button to be pressed
+		}
+	}
+
 	if c.lowPowerMode {
 		if c.shouldWakeFromLowPowerMode() {
 			c.lowPowerMode = false
@@ -61,23 +140,50 @@ func (c *cpu) Cycle() int {
 		}
 	}
 
-	address, ok := c.readAndClearInterrupt()
-	if ok {
-		c.Interrupts = interruptsDisabled
-		c.stackPush(c.ProgramCounter)
-		c.ProgramCounter = address
-		return 5
+	if c.Interrupts == interruptsEnabled && c.pendingInterrupts() != 0 {
+		return c.dispatchInterrupt()
 	}
 
+	haltBug := c.haltBug
+	c.haltBug = false
+
+	c.instructionAddress = c.ProgramCounter
+
 	opcode := c.Memory.Read8(c.ProgramCounter)
 	inst := instructions[opcode]
+	// The byte after the opcode is the prefixed opcode or the (first byte of
+	// the) immediate operand, if any
+	address := c.ProgramCounter + 1
+	if haltBug {
+		address-- // the opcode is read twice
+	}
+
 	if opcode == 0xCB {
 		// 0xCB is a prefix for a 2-byte opcode. Lookup the 2nd byte.
-		opcode = c.Memory.Read8(c.ProgramCounter + 1)
+		opcode = c.Memory.Read8(address)
 		inst = cbInstructions[opcode]
+		if c.histogram != nil {
+			c.histogram.cbPrefixed[opcode]++
+		}
+	} else {
+		c.immediate = c.readImmediate(inst, address)
+		if c.histogram != nil {
+			c.histogram.unprefixed[opcode]++
+		}
 	}
 
+	if c.trace != nil {
+		c.trace.record(c.instructionAddress, inst)
+	}
+
+	c.accessCycle = inst.Cycles[0] - dataAccesses(inst)
+
 	c.ProgramCounter += inst.Size
+	if haltBug {
+		// The program counter failed to increment after reading the opcode,
+		// causing the byte after HALT to be read twice.
+		c.ProgramCounter--
+	}
 
 	cycles := c.execute(inst)
 
@@ -196,6 +302,8 @@ func (c *cpu) execute(inst instruction) int {
 			cadd = 1
 		}
 
+		// At most one of the two additions can (half) carry, so combining them
+		// matches the carry of adding A, $V and C in one go (e.g. 0x0F+0x00+1)
 		v, carry1, halfcarry1 := add(c.read8(inst.Operands[0]), c.read8(inst.Operands[1]))
 		v, carry2, halfcarry2 := add(v, cadd)
 
@@ -224,6 +332,7 @@ func (c *cpu) execute(inst instruction) int {
 			csub = 1
 		}
 
+		// At most one of the two subtractions can (half) borrow, see ADC
 		v, carry1, halfcarry1 := subtract(c.read8(inst.Operands[0]), c.read8(inst.Operands[1]))
 		v, carry2, halfcarry2 := subtract(v, csub)
 
@@ -491,15 +600,21 @@ func (c *cpu) execute(inst instruction) int {
 		c.Registers.Write1(flagH, false)
 		c.Registers.Write1(flagC, !c.Registers.Read1(flagC))
 	case "DI":
+		// Also cancels enabling interrupts if DI immediately follows EI
 		c.Interrupts = interruptsDisabled
 	case "EI":
 		c.Interrupts = interruptsEnabledAfterNextCycle
 	case "HALT":
-		c.lowPowerMode = true
+		if c.Interrupts == interruptsDisabled && c.shouldWakeFromLowPowerMode() {
+			// HALT exits immediately, triggering the HALT bug
+			c.haltBug = true
+		} else {
+			c.lowPowerMode = true
+		}
 	case "STOP":
-		// STOP; stop running
-		log.Println("POWER OFF")
-		c.PowerOn = false
+		// STOP; enter a very low power state until a button is pressed. Unlike
+		// HALT, pending interrupts do not wake the CPU.
+		c.stopped = true
 	default:
 		notImplemented(fmt.Sprintf("instruction [%s] %s not implemented yet", inst.Opcode, inst.Mnemonic))
 	}
@@ -526,17 +641,33 @@ func (c *cpu) execute(inst instruction) int {
 
 }
 
+// readImmediate reads the immediate operand of inst at address, being the
+// byte(s) following the opcode, or returns 0 if inst has no immediate operand
+//
+// 16bit values (d16/a16) are stored little-endian (lower byte first), and
+// reading them wraps around the end of the address space like the program
+// counter does.
+func (c *cpu) readImmediate(inst instruction, address uint16) uint16 {
+	for _, op := range inst.Operands {
+		switch op.Type {
+		case operandD8, operandA8, operandA8Ptr, operandR8:
+			return uint16(c.Memory.Read8(address))
+		case operandD16, operandA16, operandA16Ptr:
+			return c.Memory.Read16(address)
+		}
+	}
+	return 0
+}
+
+// read16 reads the 16bit value of an operand
 func (c *cpu) read16(op operand) uint16 {
 	switch op.Type {
-	case operandD16:
-		return c.Memory.Read16(c.ProgramCounter - 2)
-	case operandA16:
-		return c.Memory.Read16(c.ProgramCounter - 2)
+	case operandD16, operandA16:
+		return c.immediate
 	case operandReg16:
 		return c.Registers.Read16(op.RefRegister16)
 	case operandA8:
-		offset := c.Memory.Read8(c.ProgramCounter - 1)
-		return 0xFF00 + uint16(offset)
+		return 0xFF00 + c.immediate
 	default:
 		log.Panicf("unexpected operand (%s) encountered while reading 16bit value", op.Type.String())
 		return 0
@@ -548,9 +679,8 @@ func (c *cpu) write16(op operand, v uint16) {
 	case operandReg16:
 		c.Registers.Write16(op.RefRegister16, v)
 	case operandA16Ptr:
-		address := c.Memory.Read16(c.ProgramCounter - 2)
-		c.Memory.Write8(address, uint8(v))      // lower 8 bits
-		c.Memory.Write8(address+1, uint8(v>>8)) // upper 8 bits
+		c.writeData8(c.immediate, uint8(v))      // lower 8 bits
+		c.writeData8(c.immediate+1, uint8(v>>8)) // upper 8 bits
 	default:
 		log.Panicf("unexpected operand (%s) encountered while writing 16bit value", op.Type.String())
 	}
@@ -559,21 +689,19 @@ func (c *cpu) write16(op operand, v uint16) {
 func (c *cpu) read8(op operand) byte {
 	switch op.Type {
 	case operandD8:
-		return c.Memory.Read8(c.ProgramCounter - 1)
+		return uint8(c.immediate)
 	case operandReg8:
 		return c.Registers.Data[op.RefRegister8]
 	case operandReg16Ptr:
 		address := c.Registers.Read16(op.RefRegister16)
-		return c.Memory.Read8(address)
+		return c.readData8(address)
 	case operandReg8Ptr:
 		offset := c.Registers.Data[op.RefRegister8]
-		return c.Memory.Read8(0xFF00 + uint16(offset))
+		return c.readData8(0xFF00 + uint16(offset))
 	case operandA8Ptr:
-		offset := c.Memory.Read8(c.ProgramCounter - 1)
-		return c.Memory.Read8(0xFF00 + uint16(offset))
+		return c.readData8(0xFF00 + c.immediate)
 	case operandA16Ptr:
-		address := c.Memory.Read16(c.ProgramCounter - 2)
-		return c.Memory.Read8(address)
+		return c.readData8(c.immediate)
 	default:
 		log.Panicf("unexpected operand (%s) encountered while reading 8bit value", op.Type.String())
 		return 0
@@ -583,7 +711,7 @@ func (c *cpu) read8(op operand) byte {
 func (c *cpu) read8signed(op operand) int8 {
 	switch op.Type {
 	case operandR8:
-		return int8(c.Memory.Read8(c.ProgramCounter - 1))
+		return int8(c.immediate)
 	default:
 		log.Panicf("unexpected operand (%s) encountered while reading signed 8bit value", op.Type.String())
 		return 0
@@ -597,22 +725,83 @@ func (c *cpu) write8(op operand, v byte) {
 	case operandReg16Ptr:
 		data := c.Registers.Data[op.RefRegister16 : op.RefRegister16+2]
 		address := toAddress(data)
-		c.Memory.Write8(address, v)
+		c.writeData8(address, v)
 	case operandReg8Ptr:
 		offset := c.Registers.Data[op.RefRegister8]
-		c.Memory.Write8(0xFF00+uint16(offset), v)
+		c.writeData8(0xFF00+uint16(offset), v)
 	case operandA8Ptr:
-		offset := c.Memory.Read8(c.ProgramCounter - 1)
-		c.Memory.Write8(0xFF00+uint16(offset), v)
+		c.writeData8(0xFF00+c.immediate, v)
 	case operandA16Ptr:
-		address := c.Memory.Read16(c.ProgramCounter - 2)
-		c.Memory.Write8(address, v)
+		c.writeData8(c.immediate, v)
 	default:
 		log.Panicf("unexpected operand (%s) encountered while writing 8bit value", op.Type.String())
 	}
 }
 
+// readModifyWriteMnemonics contains the instructions that both read and write
+// their operand, i.e. access memory twice for pointer operands
+var readModifyWriteMnemonics = map[string]bool{
+	"INC8": true, "DEC8": true,
+	"RLC": true, "RRC": true, "RL": true, "RR": true,
+	"SLA": true, "SRA": true, "SRL": true, "SWAP": true,
+	"RES": true, "SET": true,
+}
+
+// dataAccesses returns the number of data reads and writes made by an
+// instruction, i.e. memory accesses through pointer operands
+//
+// Fetching the instruction (and its immediate operands) and accessing the
+// stack is not included.
+func dataAccesses(inst instruction) int {
+	for _, op := range inst.Operands {
+		switch op.Type {
+		case operandReg8Ptr, operandReg16Ptr, operandA8Ptr, operandA16Ptr:
+			if readModifyWriteMnemonics[inst.Mnemonic] {
+				return 2
+			}
+			if inst.Mnemonic == "LD16" {
+				return 2 // LD (a16) SP writes both bytes of SP
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// syncDataAccess progresses the other components up to the machine cycle of
+// the next data read or write of the current instruction
+//
+// On hardware the data accesses of an instruction happen in its last machine
+// cycles, e.g. INC (HL) reads in its 2nd and writes in its 3rd (last) machine
+// cycle.
+func (c *cpu) syncDataAccess() {
+	if c.tick == nil {
+		return
+	}
+
+	for c.ticks < c.accessCycle {
+		c.tick()
+		c.ticks++
+	}
+	c.accessCycle++
+}
+
+func (c *cpu) readData8(address uint16) byte {
+	c.syncDataAccess()
+	return c.Memory.Read8(address)
+}
+
+func (c *cpu) writeData8(address uint16, v byte) {
+	c.syncDataAccess()
+	c.Memory.Write8(address, v)
+}
+
 func (c *cpu) reprOperandValues(inst instruction) string {
+	// Reading operands for debugging should not progress other components
+	tick := c.tick
+	c.tick = nil
+	defer func() { c.tick = tick }()
+
 	var operands []operand
 	for _, op := range inst.Operands {
 		switch op.Type {
@@ -673,9 +862,9 @@ func (c *cpu) reprOperandValue(op operand) (v string) {
 // shouldWakeFromLowPowerMode returns true if an interrupt is pending,
 // regardless of interrupts being globally enabled or not
 //
-// TODO: According to [1] calling Halt with interrupts globally disabled AND
-// interrupts already pending causes a hardware bug where the next instruction
-// is run twice. We do not currently emulate this bug.
+// According to [1] calling Halt with interrupts globally disabled AND
+// interrupts already pending causes a hardware bug where the byte after HALT
+// is read twice. This is emulated using haltBug.
 //
 // [1] https://rednex.github.io/rgbds/gbz80.7.html#HALT
 func (c *cpu) shouldWakeFromLowPowerMode() bool {
@@ -685,27 +874,53 @@ func (c *cpu) shouldWakeFromLowPowerMode() bool {
 	return (interruptEnabled & interruptPending) > 0
 }
 
-func (c *cpu) readAndClearInterrupt() (address uint16, ok bool) {
-	if c.Interrupts != interruptsEnabled {
-		return 0, false
-	}
+// shouldWakeFromStop returns true if any joypad button of the selected
+// group(s) is pressed, pulling an input line of 0xFF00 low
+//
+// The LCD and timer keep running while stopped, although they are stopped on
+// hardware.
+func (c *cpu) shouldWakeFromStop() bool {
+	return c.Memory.Read8(0xFF00)&0x0F != 0x0F
+}
 
+// pendingInterrupts returns the interrupts that are both enabled (IE) and
+// requested (IF), one bit per interrupt
+func (c *cpu) pendingInterrupts() byte {
 	interruptEnabled := c.Memory.Read8(0xFFFF)
 	interruptPending := c.Memory.Read8(0xFF0F)
 
-	enabledAndPending := interruptEnabled & interruptPending
-	if enabledAndPending == 0 {
-		return 0, false
-	}
+	return interruptEnabled & interruptPending & 0x1F
+}
+
+// dispatchInterrupt services the pending interrupt with the highest priority,
+// returning the number of machine cycles taken
+//
+// Dispatch takes 5 machine cycles: 2 internal delay cycles, pushing the upper
+// and lower byte of PC onto the stack, and jumping to the interrupt vector.
+//
+// The interrupt serviced is decided after pushing the upper byte of PC, which
+// overwrites IE if SP was 0x0000. This may redirect the dispatch to another
+// interrupt, or cancel it entirely in which case PC is set to 0x0000 and IF is
+// left unchanged.
+func (c *cpu) dispatchInterrupt() int {
+	c.Interrupts = interruptsDisabled
+
+	sp := c.Registers.Read16(registerSP)
+	c.Memory.Write8(sp-1, uint8(c.ProgramCounter>>8))
+	pending := c.pendingInterrupts()
+	c.Memory.Write8(sp-2, uint8(c.ProgramCounter))
+	c.Registers.Write16(registerSP, sp-2)
 
+	c.ProgramCounter = 0x0000
 	for i := uint8(0); i <= 4; i++ {
-		if readBitN(enabledAndPending, i) {
-			c.Memory.Write8(0xFF0F, writeBitN(interruptPending, i, false))
-			return interruptAddresses[i], true
+		if readBitN(pending, i) {
+			c.Memory.Write8(0xFF0F, writeBitN(c.Memory.Read8(0xFF0F), i, false))
+			c.ProgramCounter = interruptAddresses[i]
+			break
 		}
 	}
 
-	return 0, false
+	return 5
 }
 
 func (c *cpu) isFlagSet(op operand) bool {
@@ -1,6 +1,7 @@
 package emulator
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/sema/gbemu/pkg/ptr"
@@ -109,6 +110,7 @@ func TestInstructions(t *testing.T) {
 		instructions []iao
 		regSP        uint16
 		wantRegHL    *uint16
+		wantMemory   map[uint16]uint8
 	}{
 		{
 			name: "0xF8 LD HL SP+r8 with r8=1 increments SP and stores it to HL",
@@ -126,6 +128,17 @@ func TestInstructions(t *testing.T) {
 			regSP:     0xFFFE,
 			wantRegHL: ptr.UInt16(0xFFFD),
 		},
+		{
+			name: "0x08 LD (a16) SP stores SP at a16 with the lower byte first",
+			instructions: []iao{
+				run(0x08, 0x00, 0xC1),
+			},
+			regSP: 0x1234,
+			wantMemory: map[uint16]uint8{
+				0xC100: 0x34,
+				0xC101: 0x12,
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -140,6 +153,7 @@ func TestInstructions(t *testing.T) {
 					cpu.ProgramCounter++
 				}
 
+				cpu.immediate = cpu.readImmediate(inst.inst, 0xCF01)
 				cpu.execute(inst.inst)
 			}
 
@@ -147,10 +161,215 @@ func TestInstructions(t *testing.T) {
 				require.Equal(t, *tt.wantRegHL, cpu.Registers.Read16(registerHL))
 			}
 
+			for address, want := range tt.wantMemory {
+				require.Equal(t, want, cpu.Memory.Read8(address), "unexpected value at %#04x", address)
+			}
+
+		})
+	}
+}
+
+func TestRead16ReadsLittleEndianImmediates(t *testing.T) {
+	tests := []struct {
+		name    string
+		address uint16 // address of the opcode
+		opType  operandType
+	}{
+		{name: "d16", address: 0xC000, opType: operandD16},
+		{name: "a16", address: 0xC000, opType: operandA16},
+		{name: "d16 wrapping the address space", address: 0xFFFE, opType: operandD16},
+		{name: "a16 wrapping the address space", address: 0xFFFE, opType: operandA16},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu := testCPU()
+			cpu.Memory.rom.data[0x0000] = 0x12 // reached when wrapping around 0xFFFF
+			cpu.Memory.Write8(tt.address+1, 0x34)
+			if tt.address+2 != 0x0000 {
+				cpu.Memory.Write8(tt.address+2, 0x12)
+			}
+
+			op := operand{Type: tt.opType}
+			cpu.immediate = cpu.readImmediate(instruction{Operands: []operand{op}}, tt.address+1)
+			require.Equal(t, uint16(0x1234), cpu.read16(op))
+		})
+	}
+}
+
+func TestInterruptDispatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		sp     uint16
+		pc     uint16
+		ie     byte
+		ifReg  byte
+		wantPC uint16
+		wantIF byte
+	}{
+		{name: "services highest priority interrupt", sp: 0xD000, pc: 0x1234, ie: 0x1F, ifReg: 0x06, wantPC: 0x0048, wantIF: 0x04},
+		{name: "push overwriting IE cancels dispatch", sp: 0x0000, pc: 0x1234, ie: 0x01, ifReg: 0x01, wantPC: 0x0000, wantIF: 0x01},
+		{name: "push overwriting IE redirects dispatch", sp: 0x0000, pc: 0x0234, ie: 0x01, ifReg: 0x03, wantPC: 0x0048, wantIF: 0x01},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu := testCPU()
+			cpu.Interrupts = interruptsEnabled
+			cpu.Registers.Write16(registerSP, tt.sp)
+			cpu.ProgramCounter = tt.pc
+			cpu.Memory.Write8(0xFFFF, tt.ie)
+			cpu.Memory.Write8(0xFF0F, tt.ifReg)
+
+			require.Equal(t, 5, cpu.Cycle())
+			require.Equal(t, tt.wantPC, cpu.ProgramCounter)
+			require.Equal(t, tt.wantIF, cpu.Memory.Read8(0xFF0F))
+			require.Equal(t, interruptsDisabled, cpu.Interrupts)
+			require.Equal(t, tt.sp-2, cpu.Registers.Read16(registerSP))
+			require.Equal(t, tt.pc, cpu.Memory.Read16(tt.sp-2), "expected PC to be pushed onto the stack")
+		})
+	}
+}
+
+func TestInterruptEnableTiming(t *testing.T) {
+	const (
+		opcodeNOP  = 0x00
+		opcodeDI   = 0xF3
+		opcodeEI   = 0xFB
+		opcodeRETI = 0xD9
+	)
+
+	tests := []struct {
+		name    string
+		program []byte
+		wantPC  []uint16 // PC after each cycle
+	}{
+		{
+			name:    "EI enables interrupts after the following instruction",
+			program: []byte{opcodeEI, opcodeNOP, opcodeNOP},
+			wantPC:  []uint16{0xC001, 0xC002, 0x0040},
+		},
+		{
+			name:    "DI immediately after EI cancels enabling interrupts",
+			program: []byte{opcodeEI, opcodeDI, opcodeNOP},
+			wantPC:  []uint16{0xC001, 0xC002, 0xC003},
+		},
+		{
+			name:    "interrupt is serviced before DI following EI and an instruction",
+			program: []byte{opcodeEI, opcodeNOP, opcodeDI},
+			wantPC:  []uint16{0xC001, 0xC002, 0x0040},
+		},
+		{
+			name:    "RETI enables interrupts immediately",
+			program: []byte{opcodeRETI},
+			wantPC:  []uint16{0xC100, 0x0040},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu := testCPU()
+			cpu.Interrupts = interruptsDisabled
+			cpu.Registers.Write16(registerSP, 0xD000)
+			cpu.stackPush(0xC100) // return address for RETI
+
+			cpu.ProgramCounter = 0xC000
+			for i, b := range tt.program {
+				cpu.Memory.Write8(0xC000+uint16(i), b)
+			}
+			cpu.Memory.Write8(0xFFFF, 0x01) // VBlank enabled
+			cpu.Memory.Write8(0xFF0F, 0x01) // VBlank requested
+
+			for i, want := range tt.wantPC {
+				cpu.Cycle()
+				require.Equal(t, want, cpu.ProgramCounter, "unexpected PC after cycle %d", i+1)
+			}
+		})
+	}
+}
+
+func TestDataAccessesHappenInLastMachineCycles(t *testing.T) {
+	tests := []struct {
+		name       string
+		opcode     byte
+		wantReads  []int // machine cycle (starting from 0) of reads of 0xFF80
+		wantWrites []int // machine cycle (starting from 0) of writes to 0xFF80-0xFF81
+	}{
+		{name: "LD A (HL)", opcode: 0x7E, wantReads: []int{1}, wantWrites: []int{}},
+		{name: "LD (HL) A", opcode: 0x77, wantReads: []int{}, wantWrites: []int{1}},
+		{name: "INC (HL)", opcode: 0x34, wantReads: []int{1}, wantWrites: []int{2}},
+		{name: "LDH A (a8)", opcode: 0xF0, wantReads: []int{2}, wantWrites: []int{}},
+		{name: "LD A (a16)", opcode: 0xFA, wantReads: []int{3}, wantWrites: []int{}},
+		{name: "LD (a16) SP", opcode: 0x08, wantReads: []int{}, wantWrites: []int{3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu := testCPU()
+			cpu.Registers.Write16(registerHL, 0xFF80)
+			cpu.Registers.Write16(registerSP, 0x1234)
+			cpu.Registers.Data[registerA] = 0x56
+
+			// Instruction at 0xC000, with immediate operands pointing at 0xFF80
+			cpu.ProgramCounter = 0xC000
+			cpu.Memory.Write8(0xC000, tt.opcode)
+			if instructions[tt.opcode].Size == 2 {
+				cpu.Memory.Write8(0xC001, 0x80)
+			} else {
+				cpu.Memory.Write16(0xC001, 0xFF80)
+			}
+
+			reads := []int{}
+			cpu.Memory.SetIORegisterOverride(0xFF80, func() byte {
+				reads = append(reads, cpu.ticks)
+				return cpu.Memory.Peek8(0xFF80)
+			})
+
+			// Detect writes by comparing memory before every machine cycle
+			writes := []int{}
+			cycle := 0
+			previous := [2]byte{}
+			cpu.tick = func() {
+				current := [2]byte{cpu.Memory.Peek8(0xFF80), cpu.Memory.Peek8(0xFF81)}
+				for i := range current {
+					if current[i] != previous[i] {
+						writes = append(writes, cycle)
+					}
+				}
+				previous = current
+				cycle++
+			}
+
+			cycles := cpu.Cycle()
+			for i := cpu.ticks; i < cycles; i++ {
+				cpu.tick()
+			}
+
+			require.Equal(t, tt.wantReads, reads)
+			require.Equal(t, tt.wantWrites, writes)
 		})
 	}
 }
 
+func TestDataAccessesMatchInstructionCycles(t *testing.T) {
+	for _, table := range [][]instruction{instructions, cbInstructions} {
+		for _, inst := range table {
+			accesses := dataAccesses(inst)
+			if accesses == 0 {
+				continue
+			}
+
+			cpu := testCPU()
+			cpu.Registers.Write16(registerBC, 0xFF80)
+			cpu.Registers.Write16(registerDE, 0xFF80)
+			cpu.Registers.Write16(registerHL, 0xFF80)
+			cpu.ProgramCounter = 0xC003
+			cpu.Memory.Write16(0xC001, 0xFF80) // a16/a8 immediate
+			cpu.tick = func() {}
+			cpu.accessCycle = inst.Cycles[0] - accesses
+
+			cpu.execute(inst)
+			require.Equal(t, inst.Cycles[0], cpu.accessCycle, "expected %d data accesses by %s", accesses, inst)
+		}
+	}
+}
+
 func testCPU() *cpu {
 	video := newVideoController()
 	timer := newTimerController()
@@ -161,3 +380,359 @@ func testCPU() *cpu {
 	memory := newMemory(video, timer, interrupt, serial, joypad)
 	return newCPU(memory, registers, options{})
 }
+
+func TestDAAAdjustsResultOfArithmeticToBCD(t *testing.T) {
+	const (
+		opcodeADDAB = 0x80
+		opcodeSUBB  = 0x90
+		opcodeDAA   = 0x27
+	)
+
+	tests := []struct {
+		name      string
+		a         uint8
+		b         uint8
+		opcode    uint16
+		wantA     uint8
+		wantCarry bool
+	}{
+		{name: "0x45 + 0x38 = 0x83", a: 0x45, b: 0x38, opcode: opcodeADDAB, wantA: 0x83, wantCarry: false},
+		{name: "0x45 - 0x38 = 0x07", a: 0x45, b: 0x38, opcode: opcodeSUBB, wantA: 0x07, wantCarry: false},
+		{name: "0x99 + 0x01 = 0x00 with carry", a: 0x99, b: 0x01, opcode: opcodeADDAB, wantA: 0x00, wantCarry: true},
+		{name: "0x99 + 0x11 = 0x10 with carry", a: 0x99, b: 0x11, opcode: opcodeADDAB, wantA: 0x10, wantCarry: true},
+		{name: "0x89 + 0x01 = 0x90", a: 0x89, b: 0x01, opcode: opcodeADDAB, wantA: 0x90, wantCarry: false},
+		{name: "0x55 + 0x04 = 0x59", a: 0x55, b: 0x04, opcode: opcodeADDAB, wantA: 0x59, wantCarry: false},
+		{name: "0x55 + 0x10 = 0x65", a: 0x55, b: 0x10, opcode: opcodeADDAB, wantA: 0x65, wantCarry: false},
+		{name: "0x88 + 0x88 = 0x76 with carry", a: 0x88, b: 0x88, opcode: opcodeADDAB, wantA: 0x76, wantCarry: true},
+		{name: "0x00 - 0x01 = 0x99 with carry", a: 0x00, b: 0x01, opcode: opcodeSUBB, wantA: 0x99, wantCarry: true},
+		{name: "0x90 - 0x01 = 0x89", a: 0x90, b: 0x01, opcode: opcodeSUBB, wantA: 0x89, wantCarry: false},
+		{name: "0x55 - 0x04 = 0x51", a: 0x55, b: 0x04, opcode: opcodeSUBB, wantA: 0x51, wantCarry: false},
+		{name: "0x55 - 0x10 = 0x45", a: 0x55, b: 0x10, opcode: opcodeSUBB, wantA: 0x45, wantCarry: false},
+		{name: "0x10 - 0x20 = 0x90 with carry", a: 0x10, b: 0x20, opcode: opcodeSUBB, wantA: 0x90, wantCarry: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu := testCPU()
+			cpu.Registers.Data[registerA] = tt.a
+			cpu.Registers.Data[registerB] = tt.b
+
+			cpu.execute(instructions[tt.opcode])
+			cpu.execute(instructions[opcodeDAA])
+
+			require.Equal(t, tt.wantA, cpu.Registers.Data[registerA], "got %#02x", cpu.Registers.Data[registerA])
+			require.Equal(t, tt.wantCarry, cpu.Registers.Read1(flagC))
+			require.Equal(t, tt.wantA == 0, cpu.Registers.Read1(flagZ))
+			require.False(t, cpu.Registers.Read1(flagH))
+		})
+	}
+}
+
+func TestCPSetsSameFlagsAsSUB(t *testing.T) {
+	const (
+		opcodeSUBB = 0x90
+		opcodeCPB  = 0xB8
+	)
+
+	tests := []struct {
+		name  string
+		a     uint8
+		b     uint8
+		wantZ bool
+		wantH bool
+		wantC bool
+	}{
+		{name: "equal operands", a: 0x42, b: 0x42, wantZ: true},
+		{name: "no borrow", a: 0x3E, b: 0x01},
+		{name: "half borrow", a: 0x10, b: 0x01, wantH: true},
+		{name: "full borrow", a: 0x00, b: 0x01, wantH: true, wantC: true},
+		{name: "full borrow without half borrow", a: 0x0F, b: 0x10, wantC: true},
+		{name: "zero operand", a: 0x80, b: 0x00},
+		{name: "max operands", a: 0xFF, b: 0xFF, wantZ: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := testCPU()
+			sub.Registers.Data[registerA] = tt.a
+			sub.Registers.Data[registerB] = tt.b
+			sub.execute(instructions[opcodeSUBB])
+
+			cp := testCPU()
+			cp.Registers.Data[registerA] = tt.a
+			cp.Registers.Data[registerB] = tt.b
+			cp.execute(instructions[opcodeCPB])
+
+			for _, flag := range []flag{flagZ, flagN, flagH, flagC} {
+				require.Equal(t, sub.Registers.Read1(flag), cp.Registers.Read1(flag), "unexpected value of flag %v", flag)
+			}
+			require.Equal(t, tt.wantZ, cp.Registers.Read1(flagZ))
+			require.True(t, cp.Registers.Read1(flagN))
+			require.Equal(t, tt.wantH, cp.Registers.Read1(flagH))
+			require.Equal(t, tt.wantC, cp.Registers.Read1(flagC))
+
+			require.Equal(t, tt.a-tt.b, sub.Registers.Data[registerA], "expected SUB to store the result in A")
+			require.Equal(t, tt.a, cp.Registers.Data[registerA], "expected CP to leave A unchanged")
+		})
+	}
+}
+
+func TestHALTBugReadsNextByteTwice(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []byte
+		cycles  int
+		wantPC  uint16
+		wantA   uint8
+		wantD   uint8
+	}{
+		{
+			name: "single byte instruction is executed twice",
+			program: []byte{
+				0x76, // HALT
+				0x3C, // INC A
+				0x00, // NOP
+			},
+			cycles: 3,
+			wantPC: 0xC002,
+			wantA:  2,
+		},
+		{
+			name: "opcode is read again as operand",
+			program: []byte{
+				0x76,       // HALT
+				0x3E, 0x14, // LD A,d8 (executed as LD A,0x3E followed by INC D)
+			},
+			cycles: 3,
+			wantPC: 0xC003,
+			wantA:  0x3E,
+			wantD:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu := testCPU()
+			for i, b := range tt.program {
+				cpu.Memory.Write8(0xC000+uint16(i), b)
+			}
+			cpu.ProgramCounter = 0xC000
+			cpu.Interrupts = interruptsDisabled
+			cpu.Memory.Write8(0xFFFF, 0x01) // IE: VBLANK
+			cpu.Memory.Write8(0xFF0F, 0x01) // IF: VBLANK
+
+			cpu.Cycle() // HALT
+			require.False(t, cpu.lowPowerMode, "expected HALT to exit immediately")
+			require.Equal(t, uint16(0xC001), cpu.ProgramCounter)
+
+			for i := 1; i < tt.cycles; i++ {
+				cpu.Cycle()
+			}
+
+			require.Equal(t, tt.wantPC, cpu.ProgramCounter)
+			require.Equal(t, tt.wantA, cpu.Registers.Data[registerA])
+			require.Equal(t, tt.wantD, cpu.Registers.Data[registerD])
+		})
+	}
+}
+
+func TestHALTWithoutPendingInterruptEntersLowPowerMode(t *testing.T) {
+	cpu := testCPU()
+	cpu.Memory.Write8(0xC000, 0x76) // HALT
+	cpu.Memory.Write8(0xC001, 0x3C) // INC A
+	cpu.ProgramCounter = 0xC000
+	cpu.Interrupts = interruptsDisabled
+	cpu.Memory.Write8(0xFFFF, 0x01) // IE: VBLANK
+
+	cpu.Cycle()
+	require.True(t, cpu.lowPowerMode)
+
+	cpu.Memory.Write8(0xFF0F, 0x01) // IF: VBLANK
+	cpu.Cycle()                     // wakes and runs INC A
+	require.False(t, cpu.lowPowerMode)
+	require.Equal(t, uint16(0xC002), cpu.ProgramCounter, "expected INC A to be executed once")
+	require.Equal(t, uint8(1), cpu.Registers.Data[registerA])
+}
+
+func TestSTOPSkipsItsSecondByte(t *testing.T) {
+	cpu := testCPU()
+	cpu.Memory.Write8(0xC000, 0x10) // STOP
+	cpu.Memory.Write8(0xC001, 0x00)
+	cpu.Memory.Write8(0xC002, 0x04) // INC B
+	cpu.ProgramCounter = 0xC000
+
+	type executed struct {
+		mnemonic string
+		address  uint16
+	}
+	var instructions []executed
+	cpu.instructionCallback = func(mnemonic string, pc uint16) {
+		instructions = append(instructions, executed{mnemonic, cpu.instructionAddress})
+	}
+
+	cpu.Cycle()
+	require.True(t, cpu.stopped)
+	require.Equal(t, uint16(0xC002), cpu.ProgramCounter)
+
+	cpu.stopped = false // woken by a button press
+	cpu.Cycle()
+
+	require.Equal(t, []executed{{"STOP", 0xC000}, {"INC8", 0xC002}}, instructions)
+	require.Equal(t, uint8(1), cpu.Registers.Data[registerB])
+}
+
+func TestINC16AndDEC16LeaveFlagsUnchanged(t *testing.T) {
+	const (
+		opcodeINCBC = 0x03
+		opcodeDECHL = 0x2B
+	)
+
+	tests := []struct {
+		name      string
+		opcode    uint16
+		register  register16
+		value     uint16
+		wantValue uint16
+	}{
+		{name: "INC BC", opcode: opcodeINCBC, register: registerBC, value: 0x1234, wantValue: 0x1235},
+		{name: "INC BC wraps from 0xFFFF to 0x0000", opcode: opcodeINCBC, register: registerBC, value: 0xFFFF, wantValue: 0x0000},
+		{name: "DEC HL", opcode: opcodeDECHL, register: registerHL, value: 0x1234, wantValue: 0x1233},
+		{name: "DEC HL wraps from 0x0000 to 0xFFFF", opcode: opcodeDECHL, register: registerHL, value: 0x0000, wantValue: 0xFFFF},
+	}
+	for _, tt := range tests {
+		for _, flagsSet := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s with flags set=%t", tt.name, flagsSet), func(t *testing.T) {
+				cpu := testCPU()
+				cpu.Registers.Write16(tt.register, tt.value)
+				for _, flag := range []flag{flagZ, flagN, flagH, flagC} {
+					cpu.Registers.Write1(flag, flagsSet)
+				}
+
+				cpu.execute(instructions[tt.opcode])
+
+				require.Equal(t, tt.wantValue, cpu.Registers.Read16(tt.register))
+				for _, flag := range []flag{flagZ, flagN, flagH, flagC} {
+					require.Equal(t, flagsSet, cpu.Registers.Read1(flag), "expected flag %v to be unchanged", flag)
+				}
+			})
+		}
+	}
+}
+
+func TestINC8AndDEC8OnHLPointerSetFlags(t *testing.T) {
+	const (
+		opcodeINCHLPtr = 0x34
+		opcodeDECHLPtr = 0x35
+	)
+
+	tests := []struct {
+		name      string
+		opcode    uint16
+		value     byte
+		wantValue byte
+		wantZ     bool
+		wantN     bool
+		wantH     bool
+	}{
+		{name: "INC (HL)", opcode: opcodeINCHLPtr, value: 0x41, wantValue: 0x42},
+		{name: "INC (HL) half-carry from 0x0F to 0x10", opcode: opcodeINCHLPtr, value: 0x0F, wantValue: 0x10, wantH: true},
+		{name: "INC (HL) wraps from 0xFF to 0x00", opcode: opcodeINCHLPtr, value: 0xFF, wantValue: 0x00, wantZ: true, wantH: true},
+		{name: "DEC (HL)", opcode: opcodeDECHLPtr, value: 0x42, wantValue: 0x41, wantN: true},
+		{name: "DEC (HL) half-borrow from 0x10 to 0x0F", opcode: opcodeDECHLPtr, value: 0x10, wantValue: 0x0F, wantN: true, wantH: true},
+		{name: "DEC (HL) to zero", opcode: opcodeDECHLPtr, value: 0x01, wantValue: 0x00, wantZ: true, wantN: true},
+	}
+	for _, tt := range tests {
+		for _, carry := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s with carry=%t", tt.name, carry), func(t *testing.T) {
+				cpu := testCPU()
+				cpu.Registers.Write16(registerHL, 0xC000)
+				cpu.Memory.Write8(0xC000, tt.value)
+				cpu.Registers.Write1(flagC, carry)
+
+				cpu.execute(instructions[tt.opcode])
+
+				require.Equal(t, tt.wantValue, cpu.Memory.Read8(0xC000))
+				require.Equal(t, uint16(0xC000), cpu.Registers.Read16(registerHL), "expected HL to be unchanged")
+				require.Equal(t, tt.wantZ, cpu.Registers.Read1(flagZ), "Z")
+				require.Equal(t, tt.wantN, cpu.Registers.Read1(flagN), "N")
+				require.Equal(t, tt.wantH, cpu.Registers.Read1(flagH), "H")
+				require.Equal(t, carry, cpu.Registers.Read1(flagC), "expected C to be unchanged")
+			})
+		}
+	}
+}
+
+func TestADCAndSBCIncludeCarryInHalfCarry(t *testing.T) {
+	const (
+		opcodeADCAB = 0x88
+		opcodeSBCAB = 0x98
+	)
+
+	tests := []struct {
+		name   string
+		opcode uint16
+		a, b   uint8
+		carry  bool
+		want   uint8
+		wantZ  bool
+		wantH  bool
+		wantC  bool
+	}{
+		{name: "ADC 0x0F+0x00+C", opcode: opcodeADCAB, a: 0x0F, b: 0x00, carry: true, want: 0x10, wantH: true},
+		{name: "ADC 0x0F+0x00", opcode: opcodeADCAB, a: 0x0F, b: 0x00, carry: false, want: 0x0F},
+		{name: "ADC 0xFF+0x00+C", opcode: opcodeADCAB, a: 0xFF, b: 0x00, carry: true, want: 0x00, wantZ: true, wantH: true, wantC: true},
+		{name: "ADC 0x0F+0x0F+C", opcode: opcodeADCAB, a: 0x0F, b: 0x0F, carry: true, want: 0x1F, wantH: true},
+		{name: "ADC 0x80+0x7F+C", opcode: opcodeADCAB, a: 0x80, b: 0x7F, carry: true, want: 0x00, wantZ: true, wantH: true, wantC: true},
+		{name: "ADC 0xF0+0x10", opcode: opcodeADCAB, a: 0xF0, b: 0x10, carry: false, want: 0x00, wantZ: true, wantC: true},
+		{name: "SBC 0x10-0x00-C", opcode: opcodeSBCAB, a: 0x10, b: 0x00, carry: true, want: 0x0F, wantH: true},
+		{name: "SBC 0x10-0x00", opcode: opcodeSBCAB, a: 0x10, b: 0x00, carry: false, want: 0x10},
+		{name: "SBC 0x00-0x00-C", opcode: opcodeSBCAB, a: 0x00, b: 0x00, carry: true, want: 0xFF, wantH: true, wantC: true},
+		{name: "SBC 0x10-0x0F-C", opcode: opcodeSBCAB, a: 0x10, b: 0x0F, carry: true, want: 0x00, wantZ: true, wantH: true},
+		{name: "SBC 0x00-0xFF-C", opcode: opcodeSBCAB, a: 0x00, b: 0xFF, carry: true, want: 0x00, wantZ: true, wantH: true, wantC: true},
+		{name: "SBC 0x0F-0x0F-C", opcode: opcodeSBCAB, a: 0x0F, b: 0x0F, carry: true, want: 0xFF, wantH: true, wantC: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu := testCPU()
+			cpu.Registers.Data[registerA] = tt.a
+			cpu.Registers.Data[registerB] = tt.b
+			cpu.Registers.Write1(flagC, tt.carry)
+
+			cpu.execute(instructions[tt.opcode])
+
+			require.Equal(t, tt.want, cpu.Registers.Data[registerA])
+			require.Equal(t, tt.wantZ, cpu.Registers.Read1(flagZ), "Z")
+			require.Equal(t, tt.opcode == opcodeSBCAB, cpu.Registers.Read1(flagN), "N")
+			require.Equal(t, tt.wantH, cpu.Registers.Read1(flagH), "H")
+			require.Equal(t, tt.wantC, cpu.Registers.Read1(flagC), "C")
+		})
+	}
+}
+
+func TestADCAndSBCFlagsMatchSingleOperation(t *testing.T) {
+	const (
+		opcodeADCAB = 0x88
+		opcodeSBCAB = 0x98
+	)
+
+	cpu := testCPU()
+	for a := 0; a <= 0xFF; a++ {
+		for b := 0; b <= 0xFF; b++ {
+			for c := 0; c <= 1; c++ {
+				cpu.Registers.Data[registerA] = uint8(a)
+				cpu.Registers.Data[registerB] = uint8(b)
+				cpu.Registers.Write1(flagC, c == 1)
+				cpu.execute(instructions[opcodeADCAB])
+
+				if cpu.Registers.Read1(flagH) != ((a&0x0F)+(b&0x0F)+c > 0x0F) || cpu.Registers.Read1(flagC) != (a+b+c > 0xFF) {
+					t.Fatalf("unexpected flags for ADC %#02x+%#02x+%d", a, b, c)
+				}
+
+				cpu.Registers.Data[registerA] = uint8(a)
+				cpu.Registers.Write1(flagC, c == 1)
+				cpu.execute(instructions[opcodeSBCAB])
+
+				if cpu.Registers.Read1(flagH) != ((a&0x0F) < (b&0x0F)+c) || cpu.Registers.Read1(flagC) != (a < b+c) {
+					t.Fatalf("unexpected flags for SBC %#02x-%#02x-%d", a, b, c)
+				}
+			}
+		}
+	}
+}
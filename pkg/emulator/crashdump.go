@@ -0,0 +1,133 @@
+package emulator
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// traceSize is the number of recent instructions kept by instructionTrace
+const traceSize = 64
+
+// tracedInstruction is an instruction executed by the CPU
+type tracedInstruction struct {
+	address uint16
+	inst    instruction
+}
+
+// instructionTrace is a ring buffer containing the most recently executed
+// instructions
+type instructionTrace struct {
+	entries []tracedInstruction
+	next    int
+}
+
+func newInstructionTrace(size int) *instructionTrace {
+	return &instructionTrace{
+		entries: make([]tracedInstruction, 0, size),
+	}
+}
+
+func (t *instructionTrace) record(address uint16, inst instruction) {
+	entry := tracedInstruction{address: address, inst: inst}
+	if len(t.entries) < cap(t.entries) {
+		t.entries = append(t.entries, entry)
+	} else {
+		t.entries[t.next] = entry
+	}
+	t.next = (t.next + 1) % cap(t.entries)
+}
+
+// Recent returns the traced instructions, oldest first
+func (t *instructionTrace) Recent() []tracedInstruction {
+	if len(t.entries) < cap(t.entries) {
+		return append([]tracedInstruction{}, t.entries...)
+	}
+	return append(append([]tracedInstruction{}, t.entries[t.next:]...), t.entries[:t.next]...)
+}
+
+// CrashError is returned when emulation fails unexpectedly, e.g. when running
+// an illegal instruction
+type CrashError struct {
+	// PC is the address of the instruction being executed
+	PC uint16
+	// Opcode is the (first byte of the) instruction being executed
+	Opcode byte
+	// Cause is the value the emulator panicked with
+	Cause interface{}
+}
+
+func (e *CrashError) Error() string {
+	return fmt.Sprintf("emulation crashed at %#04x (opcode %#02x): %v", e.PC, e.Opcode, e.Cause)
+}
+
+// Unwrap returns the cause of the crash, if it is an error
+func (e *CrashError) Unwrap() error {
+	if err, ok := e.Cause.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// newCrashError returns a CrashError for the instruction currently executed
+func (e *Emulator) newCrashError(cause interface{}) *CrashError {
+	return &CrashError{
+		PC:     e.CPU.instructionAddress,
+		Opcode: e.Memory.Peek8(e.CPU.instructionAddress),
+		Cause:  cause,
+	}
+}
+
+// writeCrashDump writes the cause of a crash, the CPU state, the recent
+// instruction trace, and the entire address space to a timestamped file in the
+// crash dump directory, and returns the path of the file
+func (e *Emulator) writeCrashDump(cause interface{}) (string, error) {
+	path := filepath.Join(e.options.CrashDumpDir, fmt.Sprintf("gbemu-crash-%s.txt", time.Now().Format("20060102-150405.000000")))
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := e.dumpCrash(f, cause); err != nil {
+		return "", err
+	}
+	return path, f.Close()
+}
+
+func (e *Emulator) dumpCrash(w io.Writer, cause interface{}) error {
+	sb := strings.Builder{}
+
+	sb.WriteString("== ERROR ==\n")
+	sb.WriteString(fmt.Sprintf("%v\n\n", cause))
+
+	r := e.RegisterState()
+	sb.WriteString("== CPU ==\n")
+	sb.WriteString(fmt.Sprintf("PC=%#04x SP=%#04x\n", r.PC, r.SP))
+	sb.WriteString(fmt.Sprintf("A=%#02x F=%#02x B=%#02x C=%#02x D=%#02x E=%#02x H=%#02x L=%#02x\n", r.A, r.F, r.B, r.C, r.D, r.E, r.H, r.L))
+	sb.WriteString(fmt.Sprintf("Z=%t N=%t H=%t C=%t IME=%d HALT=%t STOP=%t\n\n", r.FlagZ, r.FlagN, r.FlagH, r.FlagC, e.CPU.Interrupts, e.CPU.lowPowerMode, e.CPU.stopped))
+
+	sb.WriteString("== TRACE ==\n")
+	if e.CPU.trace != nil {
+		for _, entry := range e.CPU.trace.Recent() {
+			sb.WriteString(fmt.Sprintf("%#04x %s\n", entry.address, strings.TrimRight(entry.inst.String(), " ")))
+		}
+	}
+	sb.WriteString("\n")
+
+	sb.WriteString("== MEMORY ==\n")
+	for address := 0; address <= 0xFFFF; address += 16 {
+		sb.WriteString(fmt.Sprintf("%04X:", address))
+		for _, b := range e.PeekRange(uint16(address), 16) {
+			sb.WriteString(fmt.Sprintf(" %02X", b))
+		}
+		sb.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,60 @@
+package emulator
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCrashDumpWrittenOnIllegalInstruction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gbemu-crash")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	e := New(WithCrashDump(dir))
+
+	program := []byte{
+		0x3C, // INC A
+		0x00, // NOP
+		0xD3, // ILLEGAL
+	}
+	for i, b := range program {
+		e.Memory.Write8(0xC000+uint16(i), b)
+	}
+	e.CPU.ProgramCounter = 0xC000
+
+	err = e.Continue(context.Background())
+	require.IsType(t, &CrashError{}, err)
+
+	files, err := filepath.Glob(filepath.Join(dir, "gbemu-crash-*.txt"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	data, err := ioutil.ReadFile(files[0])
+	require.NoError(t, err)
+	dump := string(data)
+
+	require.Contains(t, dump, "== ERROR ==\nIllegal instruction")
+	require.Contains(t, dump, "== CPU ==\nPC=0xc003")
+	require.Contains(t, dump, "== TRACE ==\n0xc000 [ 0x3C] INC8   A\n0xc001 [ 0x00] NOP\n0xc002 [ 0xD3] ILLEGAL\n")
+	require.Contains(t, dump, "== MEMORY ==\n0000:")
+	require.Contains(t, dump, "\nC000: 3C 00 D3 00")
+	require.Contains(t, dump, "\nFFF0:")
+}
+
+func TestInstructionTraceKeepsMostRecentInstructions(t *testing.T) {
+	trace := newInstructionTrace(3)
+	for address := uint16(0); address < 5; address++ {
+		trace.record(address, instructions[0x00])
+	}
+
+	var addresses []uint16
+	for _, entry := range trace.Recent() {
+		addresses = append(addresses, entry.address)
+	}
+	require.Equal(t, []uint16{2, 3, 4}, addresses)
+}
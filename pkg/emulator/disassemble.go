@@ -0,0 +1,132 @@
+package emulator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DisassembledInstruction is a decoded instruction at an address
+type DisassembledInstruction struct {
+	Address uint16
+
+	// Bytes contains the opcode (including any 0xCB prefix) and the
+	// immediate operands of the instruction
+	Bytes []byte
+
+	// Assembly is the human-readable instruction, e.g. "LD A, $3C"
+	Assembly string
+}
+
+func (d DisassembledInstruction) String() string {
+	return fmt.Sprintf("0x%04x: %s", d.Address, d.Assembly)
+}
+
+// disassemblyMnemonics maps the mnemonics used internally by the CPU to their
+// conventional names, where the operand size is not part of the mnemonic
+var disassemblyMnemonics = map[string]string{
+	"LD8":   "LD",
+	"LD16":  "LD",
+	"LDSP":  "LD",
+	"ADD8":  "ADD",
+	"ADD16": "ADD",
+	"ADDSP": "ADD",
+	"INC8":  "INC",
+	"INC16": "INC",
+	"DEC8":  "DEC",
+	"DEC16": "DEC",
+}
+
+// Disassemble decodes count instructions starting at address start
+//
+// Memory is read without side effects (see PeekMemory), so disassembling is
+// safe while the emulator is paused, e.g. at a breakpoint.
+func (e *Emulator) Disassemble(start uint16, count int) []DisassembledInstruction {
+	return disassemble(e.Memory.Peek8, start, count)
+}
+
+// disassemble decodes count instructions starting at address start, reading
+// memory using read8
+func disassemble(read8 func(address uint16) byte, start uint16, count int) []DisassembledInstruction {
+	var result []DisassembledInstruction
+
+	address := start
+	for i := 0; i < count; i++ {
+		opcode := read8(address)
+		inst := instructions[opcode]
+		if opcode == 0xCB {
+			inst = cbInstructions[read8(address+1)]
+		}
+
+		data := make([]byte, inst.Size)
+		for j := range data {
+			data[j] = read8(address + uint16(j))
+		}
+
+		result = append(result, DisassembledInstruction{
+			Address:  address,
+			Bytes:    data,
+			Assembly: formatInstruction(address, inst, data),
+		})
+		address += inst.Size
+	}
+
+	return result
+}
+
+// formatInstruction formats inst, located at address and encoded as data, with
+// the immediate operands resolved to their values
+func formatInstruction(address uint16, inst instruction, data []byte) string {
+	mnemonic, ok := disassemblyMnemonics[inst.Mnemonic]
+	if !ok {
+		mnemonic = inst.Mnemonic
+	}
+
+	var operands []string
+	for _, op := range inst.Operands {
+		if op.Type == operandFlag {
+			// Conditions are listed first, e.g. JR NZ, r8
+			operands = append([]string{op.Name}, operands...)
+			continue
+		}
+		operands = append(operands, formatOperand(address, inst, op, data))
+	}
+
+	if inst.Mnemonic == "LDSP" {
+		// LD HL, SP+r8
+		operands = []string{operands[0], operands[1] + operands[2]}
+	}
+
+	if len(operands) == 0 {
+		return mnemonic
+	}
+	return fmt.Sprintf("%s %s", mnemonic, strings.Join(operands, ", "))
+}
+
+func formatOperand(address uint16, inst instruction, op operand, data []byte) string {
+	// Immediate operands always follow the (single byte) opcode
+	d8 := func() byte { return data[1] }
+	d16 := func() uint16 { return uint16(data[2])<<8 | uint16(data[1]) }
+
+	switch op.Type {
+	case operandD8:
+		return fmt.Sprintf("$%02X", d8())
+	case operandD16, operandA16:
+		return fmt.Sprintf("$%04X", d16())
+	case operandA16Ptr:
+		return fmt.Sprintf("($%04X)", d16())
+	case operandA8, operandA8Ptr:
+		return fmt.Sprintf("($%04X)", 0xFF00+uint16(d8()))
+	case operandR8:
+		offset := int8(d8())
+		if inst.Mnemonic == "JR" {
+			// Show the target address rather than the offset
+			return fmt.Sprintf("$%04X", address+inst.Size+uint16(offset))
+		}
+		if offset < 0 {
+			return fmt.Sprintf("-$%02X", -int(offset))
+		}
+		return fmt.Sprintf("+$%02X", offset)
+	}
+
+	return op.Name
+}
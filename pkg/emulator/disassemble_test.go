@@ -0,0 +1,58 @@
+package emulator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisassembleProducesListing(t *testing.T) {
+	e := New()
+	program := []byte{
+		0x3E, 0x3C, // LD A,$3C
+		0x21, 0x34, 0x12, // LD HL,$1234
+		0xEA, 0x00, 0xC0, // LD ($C000),A
+		0xE0, 0x44, // LDH ($44),A
+		0x22,       // LD (HL+),A
+		0xCB, 0x7C, // BIT 7,H
+		0x20, 0xF1, // JR NZ,-15
+		0xF8, 0xFE, // LD HL,SP-2
+		0xC3, 0x50, 0x01, // JP $0150
+		0x00, // NOP
+	}
+	for i, b := range program {
+		e.Memory.Write8(0xC150+uint16(i), b)
+	}
+
+	var lines []string
+	for _, inst := range e.Disassemble(0xC150, 10) {
+		lines = append(lines, inst.String())
+	}
+
+	require.Equal(t, []string{
+		"0xc150: LD A, $3C",
+		"0xc152: LD HL, $1234",
+		"0xc155: LD ($C000), A",
+		"0xc158: LD ($FF44), A",
+		"0xc15a: LD (HL+), A",
+		"0xc15b: BIT 7, H",
+		"0xc15d: JR NZ, $C150",
+		"0xc15f: LD HL, SP-$02",
+		"0xc161: JP $0150",
+		"0xc164: NOP",
+	}, lines)
+}
+
+func TestDisassembleIncludesInstructionBytes(t *testing.T) {
+	e := New()
+	e.Memory.Write8(0xC000, 0xCB) // SWAP A
+	e.Memory.Write8(0xC001, 0x37)
+	e.Memory.Write8(0xC002, 0x01) // LD BC,$BEEF
+	e.Memory.Write8(0xC003, 0xEF)
+	e.Memory.Write8(0xC004, 0xBE)
+
+	require.Equal(t, []DisassembledInstruction{
+		{Address: 0xC000, Bytes: []byte{0xCB, 0x37}, Assembly: "SWAP A"},
+		{Address: 0xC002, Bytes: []byte{0x01, 0xEF, 0xBE}, Assembly: "LD BC, $BEEF"},
+	}, e.Disassemble(0xC000, 2))
+}
@@ -0,0 +1,81 @@
+package emulator
+
+const (
+	// OAM DMA Transfer (write only)
+	//
+	// Writing XX to the register starts a transfer of 160 bytes from
+	// XX00-XX9F to the Sprite Attribute Table at FE00-FE9F. The transfer
+	// copies a single byte every machine cycle, and so takes 160 cycles.
+	registerFF46 uint16 = 0xFF46
+
+	// dmaTransferSize is the number of bytes copied by an OAM DMA transfer
+	dmaTransferSize = 0xA0
+)
+
+// dmaController handles OAM DMA transfers
+type dmaController struct {
+	// register contains the last value written to 0xFF46
+	register byte
+
+	// active is true while a transfer is in progress
+	active bool
+
+	// source is the address of the next byte to copy
+	source uint16
+
+	// progress is the number of bytes copied so far in the current transfer
+	progress uint16
+
+	// memory is the address space the transfer reads from
+	memory *memory
+
+	video *videoController
+}
+
+func newDMAController(video *videoController) *dmaController {
+	return &dmaController{
+		video: video,
+	}
+}
+
+// Read8 is exposed in the address space, and may be read by the program
+func (d *dmaController) Read8(address uint16) byte {
+	return d.register
+}
+
+// Write8 is exposed in the address space, and may be written to by the program
+func (d *dmaController) Write8(address uint16, v byte) {
+	d.register = v
+
+	source := uint16(v) << 8
+	if source >= 0xE000 {
+		// Sources beyond WRAM read from the WRAM (ECHO RAM) on DMG
+		source -= 0x2000
+	}
+
+	// Writing during an active transfer restarts the transfer
+	d.active = true
+	d.source = source
+	d.progress = 0
+	d.video.dmaActive = true
+}
+
+// Cycle copies the next byte of an active transfer, and must be called once
+// per machine cycle
+func (d *dmaController) Cycle() {
+	if !d.active {
+		return
+	}
+
+	d.video.oam[d.progress] = d.memory.Read8(d.source + d.progress)
+	d.progress++
+
+	if d.progress == dmaTransferSize {
+		d.active = false
+		d.video.dmaActive = false
+	}
+}
+
+func (d *dmaController) String() string {
+	return "DMA"
+}
@@ -0,0 +1,48 @@
+package emulator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDMATransferCopiesWRAMToOAM(t *testing.T) {
+	video := newVideoController()
+	memory := newMemory(video, newTimerController(), newInterruptController(), newSerialController(), newJoypadController())
+
+	for i := uint16(0); i < dmaTransferSize; i++ {
+		memory.Write8(0xC000+i, byte(i+1))
+	}
+
+	memory.Write8(registerFF46, 0xC0)
+	require.Equal(t, uint8(0xC0), memory.Read8(registerFF46))
+
+	for i := 0; i < dmaTransferSize; i++ {
+		require.Equal(t, uint8(0xFF), memory.Read8(0xFE00), "expected OAM to be inaccessible during transfer")
+		memory.dma.Cycle()
+	}
+
+	for i := uint16(0); i < dmaTransferSize; i++ {
+		require.Equal(t, byte(i+1), memory.Read8(0xFE00+i), "unexpected OAM value at %#04x", 0xFE00+i)
+	}
+}
+
+func TestDMATransferBlocksOAMWrites(t *testing.T) {
+	video := newVideoController()
+	memory := newMemory(video, newTimerController(), newInterruptController(), newSerialController(), newJoypadController())
+
+	memory.Write8(0xC000, 0x42)
+	memory.Write8(registerFF46, 0xC0)
+	memory.dma.Cycle()
+
+	memory.Write8(0xFE01, 0x99) // ignored
+	for i := 1; i < dmaTransferSize; i++ {
+		memory.dma.Cycle()
+	}
+
+	require.Equal(t, uint8(0x42), memory.Read8(0xFE00))
+	require.Equal(t, uint8(0x00), memory.Read8(0xFE01))
+
+	memory.Write8(0xFE01, 0x99) // accessible again after transfer
+	require.Equal(t, uint8(0x99), memory.Read8(0xFE01))
+}
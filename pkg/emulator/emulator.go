@@ -2,8 +2,14 @@ package emulator
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"io"
 	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -12,55 +18,330 @@ type Emulator struct {
 	Video     *videoController
 	Timer     *timerController
 	Serial    *serialController
+	Joypad    *joypadController
 	Interrupt *interruptController
 	Memory    *memory
 	CPU       *cpu
 	FrameChan chan Frame
 	options   options
+
+	// speedMutex guards options.Speed, which may be changed by SetSpeed while
+	// the emulator is running
+	speedMutex sync.Mutex
+
+	// clock caps the speed of the emulation, see WithClock
+	clock Clock
+
+	// FrameUpdateChan receives every completed frame together with what changed
+	// since the previous frame, instead of FrameChan, if enabled by
+	// WithFrameUpdates
+	FrameUpdateChan chan FrameUpdate
+
+	// SampleChan receives buffers of audio samples (-1 to 1) at the sample
+	// rate set by WithSampleRate. Buffers are dropped if SampleChan is full.
+	SampleChan chan []float32
+
+	// samplePhase accumulates the sample rate on every machine cycle, and a
+	// sample is taken each time it exceeds machineCyclesPerSecond
+	samplePhase int
+	samples     []float32
+
+	// frameReady is true if a frame was completed since it was last sent on
+	// FrameChan
+	frameReady bool
+
+	// romPath is the path of the loaded ROM, or empty if loaded from memory
+	// (see LoadROMBytes), used to locate the save file
+	romPath string
+
+	// framesSinceSave counts the frames completed since cartridge RAM was last
+	// written to the save file while running
+	framesSinceSave int
+
+	// breakpoints contains addresses at which to stop emulation
+	breakpoints map[uint16]bool
 }
 
 type options struct {
 	DebugLogging bool
-	// Speed determines the speed of the emulation
-	//
-	// Currently only allows for switching between uncapped (as fast as possible)) and
-	// realtime (as if using a real device). Can support speedup/slowmotion in the future.
+	// Speed determines the speed of the emulation, as a multiplier of realtime
+	// (as if using a real device)
 	//
-	// 0 = uncapped
-	// 1 = realtime
+	// 0   = uncapped (as fast as possible)
+	// 0.5 = slow motion
+	// 1   = realtime
+	// 2   = fast forward
 	Speed float64
+
+	// SaveFile is the path used to persist battery-backed cartridge RAM. If
+	// empty, a .sav file next to the ROM is used.
+	SaveFile string
+
+	// CrashDumpDir is the directory crash dumps are written to, if set
+	CrashDumpDir string
+
+	// BootROM is run on Load if no boot ROM path is given, if set
+	BootROM []byte
+
+	// SampleRate is the number of audio samples per second sent on SampleChan
+	SampleRate int
+
+	// FrameUpdates causes frames to be sent on FrameUpdateChan rather than
+	// FrameChan, see WithFrameUpdates
+	FrameUpdates bool
+
+	// BackgroundMap causes the background map to be sent with every frame on
+	// FrameUpdateChan, see WithBackgroundMap
+	BackgroundMap bool
 }
 
-type optionFunc func(e *Emulator)
+// OptionFunc configures an Emulator when passed to New
+type OptionFunc func(e *Emulator)
 
 // WithDebugLogging enables debug-level logging in the emulator
 //
 // Doing so greatly slows down emulation.
-func WithDebugLogging() optionFunc {
+func WithDebugLogging() OptionFunc {
 	return func(e *Emulator) {
 		e.options.DebugLogging = true
 	}
 }
 
 // WithSpeedUncapped causes the emulator to run as fast as it can
-func WithSpeedUncapped() optionFunc {
+func WithSpeedUncapped() OptionFunc {
 	return func(e *Emulator) {
 		e.options.Speed = 0
 	}
 }
 
+// WithSpeed runs the emulator at multiplier times realtime, e.g. 0.5 for slow
+// motion or 2 for fast forward. A multiplier of 0 is uncapped (see
+// WithSpeedUncapped).
+func WithSpeed(multiplier float64) OptionFunc {
+	return func(e *Emulator) {
+		e.options.Speed = multiplier
+	}
+}
+
+// SetSpeed changes the speed of the emulation to multiplier times realtime (see
+// WithSpeed), e.g. to fast forward while a key is held. Takes effect from the
+// next frame.
+//
+// Safe to call from a different goroutine than the one running the emulator.
+func (e *Emulator) SetSpeed(multiplier float64) {
+	e.speedMutex.Lock()
+	defer e.speedMutex.Unlock()
+	e.options.Speed = multiplier
+}
+
+// speed returns the current speed multiplier, see SetSpeed
+func (e *Emulator) speed() float64 {
+	e.speedMutex.Lock()
+	defer e.speedMutex.Unlock()
+	return e.options.Speed
+}
+
+// WithClock replaces the wall clock used to cap the speed of the emulation and
+// to tick the cartridge real time clock, e.g. with a fake clock in tests
+func WithClock(c Clock) OptionFunc {
+	return func(e *Emulator) {
+		e.clock = c
+		e.Memory.rom.now = c.Now
+	}
+}
+
+// WithSaveFile sets the path of the file used to persist battery-backed
+// cartridge RAM between runs (defaults to a .sav file next to the ROM)
+func WithSaveFile(path string) OptionFunc {
+	return func(e *Emulator) {
+		e.options.SaveFile = path
+	}
+}
+
+// WithEmbeddedBootROM runs the given boot ROM (256 bytes) when loading a ROM,
+// unless a boot ROM path is passed to Load or Run. This allows front-ends to
+// bundle a boot ROM rather than requiring a file.
+//
+// No boot ROM is bundled with the emulator itself, as the original boot ROM
+// can't be redistributed.
+func WithEmbeddedBootROM(data []byte) OptionFunc {
+	return func(e *Emulator) {
+		e.options.BootROM = data
+	}
+}
+
+// WithSampleRate sets the number of audio samples per second sent on
+// SampleChan (defaults to 44100)
+func WithSampleRate(hz int) OptionFunc {
+	return func(e *Emulator) {
+		e.options.SampleRate = hz
+	}
+}
+
+// WithCrashDump writes a dump of the emulator state (CPU, recent
+// instructions, and the entire address space) to a timestamped file in dir
+// if the emulator crashes
+func WithCrashDump(dir string) OptionFunc {
+	return func(e *Emulator) {
+		e.options.CrashDumpDir = dir
+		e.CPU.trace = newInstructionTrace(traceSize)
+	}
+}
+
+// WithDoctorTrace writes the CPU state to w before every executed instruction,
+// one line per instruction, in the format used by Gameboy Doctor
+// (https://github.com/robert/gameboy-doctor), e.g.
+//
+//	A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,13,02
+func WithDoctorTrace(w io.Writer) OptionFunc {
+	return func(e *Emulator) {
+		e.CPU.addInstructionCallback(func(mnemonic string, pc uint16) {
+			io.WriteString(w, e.doctorTraceLine())
+		})
+	}
+}
+
+// stuckThreshold is the number of machine cycles the CPU must spend executing
+// the same instruction before it is considered stuck (one second)
+const stuckThreshold = machineCyclesPerSecond
+
+// WithStuckDetection calls f with the address of the instruction when the CPU
+// keeps executing the same instruction (e.g. JR -2) for a second of emulated
+// time, without an interrupt breaking the loop. f is called once each time the
+// CPU gets stuck.
+//
+// Test ROMs commonly loop like this once done, while it usually means a hung
+// game otherwise.
+func WithStuckDetection(f func(pc uint16)) OptionFunc {
+	return func(e *Emulator) {
+		var address uint16
+		var since uint64
+		reported := false
+
+		e.CPU.addInstructionCallback(func(mnemonic string, pc uint16) {
+			if e.CPU.instructionAddress != address {
+				address = e.CPU.instructionAddress
+				since = e.CPU.cycles
+				reported = false
+				return
+			}
+
+			if !reported && e.CPU.cycles-since >= stuckThreshold {
+				reported = true
+				f(address)
+			}
+		})
+	}
+}
+
+// WithStrictMemory determines how accesses to unmapped addresses are handled
+//
+// In strict mode (the default) such reads and writes panic with a
+// *MemoryError. Otherwise reads return 0xFF and writes are ignored, and the
+// error is recorded and available through Memory.Err, allowing a front-end to
+// report the error and continue.
+func WithStrictMemory(strict bool) OptionFunc {
+	return func(e *Emulator) {
+		e.Memory.strict = strict
+	}
+}
+
+// WithPerDotRendering causes the video controller to read the scroll registers
+// and search for sprites on every rendered dot rather than once per scanline
+//
+// Doing so is slower, but required to faithfully render demos that scroll in
+// the middle of a scanline (raster effects). Changes to the window position and
+// platters always take effect mid-line.
+func WithPerDotRendering() OptionFunc {
+	return func(e *Emulator) {
+		e.Video.perDotRendering = true
+	}
+}
+
+// WithFrameUpdates causes Continue to send every completed frame on
+// FrameUpdateChan (see FrameUpdate) rather than on FrameChan, e.g. for a
+// front-end to only redraw the rows that changed
+func WithFrameUpdates() OptionFunc {
+	return func(e *Emulator) {
+		e.options.FrameUpdates = true
+	}
+}
+
+// WithBackgroundMap causes the background map selected in LCDC to be rendered
+// and sent with every frame on FrameUpdateChan, e.g. to show it in a debug
+// window. Implies WithFrameUpdates.
+func WithBackgroundMap() OptionFunc {
+	return func(e *Emulator) {
+		e.options.FrameUpdates = true
+		e.options.BackgroundMap = true
+	}
+}
+
+// WithOpcodeHistogram counts the number of times each opcode is executed
+//
+// See OpcodeHistogram and CBOpcodeHistogram.
+func WithOpcodeHistogram() OptionFunc {
+	return func(e *Emulator) {
+		e.CPU.histogram = &opcodeHistogram{}
+	}
+}
+
+// WithBankSwitchLog records every ROM and RAM bank switch made by the
+// cartridge
+//
+// See BankSwitchLog.
+func WithBankSwitchLog() OptionFunc {
+	return func(e *Emulator) {
+		e.Memory.rom.bankSwitches = &bankSwitchRecorder{
+			clock: func() (uint16, uint64) {
+				return e.CPU.instructionAddress, e.CPU.cycles
+			},
+		}
+	}
+}
+
+// WithChecksumValidation causes loading a ROM to fail with a ChecksumError if
+// the header or global checksum does not match the ROM. Otherwise, only a
+// header checksum mismatch is logged.
+func WithChecksumValidation() OptionFunc {
+	return func(e *Emulator) {
+		e.Memory.rom.validateChecksums = true
+	}
+}
+
 // WithSerialDataCallback provides a func f that will be called on
 // every byte transferred out on the serial port
-func WithSerialDataCallback(f SerialDataCallback) optionFunc {
+func WithSerialDataCallback(f SerialDataCallback) OptionFunc {
 	return func(e *Emulator) {
 		e.Serial.Callback = f
 	}
 }
 
+// WithSerialReceiveCallback provides a func f that will be called to read the
+// incoming byte on every transfer over the serial port
+func WithSerialReceiveCallback(f SerialReceiveCallback) OptionFunc {
+	return func(e *Emulator) {
+		e.Serial.ReceiveCallback = f
+	}
+}
+
+// WithSerialLink connects the serial port to an external device, e.g. another
+// emulator through a link returned by NewSerialLinkPipe
+func WithSerialLink(link SerialLink) OptionFunc {
+	return func(e *Emulator) {
+		e.Serial.Link = link
+		if pipe, ok := link.(*serialPipe); ok {
+			pipe.serial = e.Serial
+			e.Serial.mutex = pipe.mutex
+		}
+	}
+}
+
 // New returns an instance of Emulator
-func New(opts ...optionFunc) *Emulator {
+func New(opts ...OptionFunc) *Emulator {
 	options := options{
-		Speed: 1,
+		Speed:      1,
+		SampleRate: 44100,
 	}
 
 	timer := newTimerController()
@@ -79,16 +360,24 @@ func New(opts ...optionFunc) *Emulator {
 	interrupt.registerSource(4, joypad.Interrupt)
 
 	e := &Emulator{
-		CPU:       cpu,
-		Memory:    memory,
-		Video:     video,
-		Timer:     timer,
-		Serial:    serial,
-		Interrupt: interrupt,
-		FrameChan: make(chan Frame),
-		options:   options,
+		CPU:             cpu,
+		Memory:          memory,
+		Video:           video,
+		Timer:           timer,
+		Serial:          serial,
+		Joypad:          joypad,
+		Interrupt:       interrupt,
+		FrameChan:       make(chan Frame),
+		FrameUpdateChan: make(chan FrameUpdate),
+		SampleChan:      make(chan []float32, sampleChanSize),
+		samples:         make([]float32, 0, sampleBufferSize),
+		options:         options,
+		breakpoints:     map[uint16]bool{},
+		clock:           realClock{},
 	}
 
+	cpu.tick = e.tick
+
 	for _, opt := range opts {
 		opt(e)
 	}
@@ -96,59 +385,170 @@ func New(opts ...optionFunc) *Emulator {
 	return e
 }
 
+// SetButton marks button b as held down (pressed=true) or released
+//
+// Safe to call from a different goroutine than the one running the emulator.
+func (e *Emulator) SetButton(b Button, pressed bool) {
+	e.Joypad.SetButton(b, pressed)
+}
+
+// SetTurbo makes button b auto-repeat at hz presses per second while it is
+// held down. Setting hz to 0 disables turbo for the button.
+func (e *Emulator) SetTurbo(b Button, hz float64) {
+	e.Joypad.SetTurbo(b, hz)
+}
+
+// ErrBreakpoint is returned by Step and Continue when the program counter
+// reaches a breakpoint
+var ErrBreakpoint = errors.New("breakpoint reached")
+
 // Run runs the ROM in the emulator, and returns when the emulator halts
+//
+// Battery-backed cartridge RAM is written to the save file when Run returns,
+// as well as while running once the program disables cartridge RAM after
+// writing to it, or periodically otherwise.
+//
+// Returns ErrBreakpoint if a breakpoint is reached, after which emulation can
+// be resumed using Step or Continue.
 func (e *Emulator) Run(ctx context.Context, path string, bootPath string) error {
+	if err := e.Load(path, bootPath); err != nil {
+		return err
+	}
+	defer func() {
+		if err := e.writeSaveFile(path); err != nil {
+			log.Printf("WARNING: failed to write save file: %v", err)
+		}
+	}()
+
+	return e.Continue(ctx)
+}
+
+// Load loads the ROM (and optionally the boot ROM, from bootPath or as set by
+// WithEmbeddedBootROM) and prepares the emulator to run it, without running any
+// instructions
+func (e *Emulator) Load(path string, bootPath string) error {
 	if err := e.Memory.LoadROM(path); err != nil {
 		return err
 	}
+	e.romPath = path
+
+	if err := e.loadSaveFile(path); err != nil {
+		return err
+	}
+
+	return e.loadBootROM(bootPath)
+}
+
+// LoadROMBytes is like Load, but loads the ROM from data rather than a file,
+// e.g. to run a ROM embedded in the program or read from an archive
+//
+// Battery-backed cartridge RAM is only loaded if a save file is set using
+// WithSaveFile.
+func (e *Emulator) LoadROMBytes(data []byte, bootPath string) error {
+	if err := e.Memory.LoadROMBytes(data); err != nil {
+		return err
+	}
+	e.romPath = ""
 
+	if e.options.SaveFile != "" {
+		if err := e.loadSaveFile(""); err != nil {
+			return err
+		}
+	}
+
+	return e.loadBootROM(bootPath)
+}
+
+// loadBootROM loads the boot ROM from bootPath, or as set by
+// WithEmbeddedBootROM, and otherwise skips straight to the post-boot state
+func (e *Emulator) loadBootROM(bootPath string) error {
 	if bootPath != "" {
 		// Load and run the boot ROM (optional) - this will display the
 		// iconic loading screen when starting the emulator.
-		e.Memory.LoadBootROM(bootPath)
+		if err := e.Memory.LoadBootROM(bootPath); err != nil {
+			return err
+		}
 		e.CPU.ProgramCounter = 0 // execute the boot rom
+	} else if e.options.BootROM != nil {
+		if err := e.Memory.LoadBootROMBytes(e.options.BootROM); err != nil {
+			return err
+		}
+		e.CPU.ProgramCounter = 0 // execute the embedded boot rom
 	} else {
-		e.CPU.ProgramCounter = 0x0100 // skip past boot rom and run ROM directly
-		e.CPU.Registers.Write16(registerAF, 0x01B0)
-		e.CPU.Registers.Write16(registerBC, 0x0013)
-		e.CPU.Registers.Write16(registerDE, 0x00D8)
-		e.CPU.Registers.Write16(registerHL, 0x014D)
-		e.CPU.Registers.Write16(registerSP, 0xFFFE)
-
-		e.Memory.Write8(0xFF05, 0)
-		e.Memory.Write8(0xFF06, 0)
-		e.Memory.Write8(0xFF07, 0)
-		e.Memory.Write8(0xFF10, 0x80)
-		e.Memory.Write8(0xFF11, 0xBF)
-		e.Memory.Write8(0xFF12, 0xF3)
-		e.Memory.Write8(0xFF14, 0xBF)
-		e.Memory.Write8(0xFF16, 0x3F)
-		e.Memory.Write8(0xFF17, 0)
-		e.Memory.Write8(0xFF19, 0xBF)
-		e.Memory.Write8(0xFF1A, 0x7F)
-		e.Memory.Write8(0xFF1B, 0xFF)
-		e.Memory.Write8(0xFF1C, 0x9F)
-		e.Memory.Write8(0xFF1E, 0xBF)
-		e.Memory.Write8(0xFF20, 0xFF)
-		e.Memory.Write8(0xFF21, 0)
-		e.Memory.Write8(0xFF22, 0)
-		e.Memory.Write8(0xFF23, 0xBF)
-		e.Memory.Write8(0xFF24, 0x77)
-		e.Memory.Write8(0xFF25, 0xF3)
-		e.Memory.Write8(0xFF26, 0xF1)
-		e.Memory.Write8(0xFF40, 0x91)
-		e.Memory.Write8(0xFF42, 0)
-		e.Memory.Write8(0xFF45, 0)
-		e.Memory.Write8(0xFF47, 0xFC)
-		e.Memory.Write8(0xFF48, 0xFF)
-		e.Memory.Write8(0xFF49, 0xFF)
-		e.Memory.Write8(0xFF4A, 0)
-		e.Memory.Write8(0xFF4B, 0)
-		e.Memory.Write8(0xFFFF, 0)
-	}
-
-	frameSync := time.NewTicker(time.Second / 60)
-	cpuIdleCycles := 0
+		e.initPostBootState() // skip past boot rom and run ROM directly
+	}
+
+	return nil
+}
+
+// initPostBootState initializes the CPU registers and IO registers to the
+// values left by the boot ROM, such that the ROM can be run directly
+func (e *Emulator) initPostBootState() {
+	e.CPU.ProgramCounter = 0x0100
+	e.CPU.Registers.Write16(registerAF, 0x01B0)
+	e.CPU.Registers.Write16(registerBC, 0x0013)
+	e.CPU.Registers.Write16(registerDE, 0x00D8)
+	e.CPU.Registers.Write16(registerHL, 0x014D)
+	e.CPU.Registers.Write16(registerSP, 0xFFFE)
+
+	e.Memory.Write8(0xFF05, 0)
+	e.Memory.Write8(0xFF06, 0)
+	e.Memory.Write8(0xFF07, 0)
+	e.Memory.Write8(0xFF26, 0xF1) // power on sound before writing sound registers
+	e.Memory.Write8(0xFF10, 0x80)
+	e.Memory.Write8(0xFF11, 0xBF)
+	e.Memory.Write8(0xFF12, 0xF3)
+	e.Memory.Write8(0xFF14, 0xBF)
+	e.Memory.Write8(0xFF16, 0x3F)
+	e.Memory.Write8(0xFF17, 0)
+	e.Memory.Write8(0xFF19, 0xBF)
+	e.Memory.Write8(0xFF1A, 0x7F)
+	e.Memory.Write8(0xFF1B, 0xFF)
+	e.Memory.Write8(0xFF1C, 0x9F)
+	e.Memory.Write8(0xFF1E, 0xBF)
+	e.Memory.Write8(0xFF20, 0xFF)
+	e.Memory.Write8(0xFF21, 0)
+	e.Memory.Write8(0xFF22, 0)
+	e.Memory.Write8(0xFF23, 0xBF)
+	e.Memory.Write8(0xFF24, 0x77)
+	e.Memory.Write8(0xFF25, 0xF3)
+	e.Memory.Write8(0xFF40, 0x91)
+	e.Memory.Write8(0xFF42, 0)
+	e.Memory.Write8(0xFF45, 0)
+	e.Memory.Write8(0xFF47, 0xFC)
+	e.Memory.Write8(0xFF48, 0xFF)
+	e.Memory.Write8(0xFF49, 0xFF)
+	e.Memory.Write8(0xFF4A, 0)
+	e.Memory.Write8(0xFF4B, 0)
+	e.Memory.Write8(0xFFFF, 0)
+}
+
+// Reset returns the emulator to its power-on state, keeping the loaded ROM
+//
+// All memory (except for cartridge RAM) and components are reset, after which
+// the CPU and IO registers are initialized to the values left by the boot ROM,
+// as if the ROM was loaded without a boot ROM. Breakpoints, options, and held
+// buttons are retained.
+func (e *Emulator) Reset() {
+	state := New().saveState()
+	state.Cartridge.RAM = e.Memory.rom.RAM() // RAM (and the RTC) survive power cycles
+	e.loadState(state)
+
+	e.frameReady = false
+	e.samplePhase = 0
+	e.samples = e.samples[:0]
+
+	e.initPostBootState()
+}
+
+// Continue runs the loaded ROM until the emulator halts, ctx is cancelled, a
+// breakpoint is reached (returning ErrBreakpoint), or emulation crashes
+// (returning a *CrashError)
+//
+// A crash dump is written on crashes if WithCrashDump is set.
+func (e *Emulator) Continue(ctx context.Context) error {
+	limiter := e.newFrameLimiter()
+	defer limiter.stop()
 
 	for e.CPU.PowerOn {
 		select {
@@ -157,44 +557,478 @@ func (e *Emulator) Run(ctx context.Context, path string, bootPath string) error
 		default:
 		}
 
-		if cpuIdleCycles > 0 {
-			cpuIdleCycles--
-		} else {
-			cpuIdleCycles = e.CPU.Cycle() - 1
+		_, err := e.Step()
+		e.flushSaveFile()
+
+		var crash *CrashError
+		if errors.As(err, &crash) {
+			if e.options.CrashDumpDir != "" {
+				if path, err := e.writeCrashDump(crash.Cause); err != nil {
+					log.Printf("WARNING: failed to write crash dump: %v", err)
+				} else {
+					log.Printf("wrote crash dump to %s", path)
+				}
+			}
+			return err
 		}
 
-		e.Video.Cycle()
-		e.Timer.Cycle()
-		e.Serial.Cycle()
+		if e.frameReady {
+			e.frameReady = false
+			e.Joypad.NextFrame()
 
-		e.Interrupt.CheckSourcesForInterrupts()
+			e.framesSinceSave++
+			if e.framesSinceSave >= saveFileInterval {
+				e.Memory.rom.saveRequested = true
+			}
 
-		if e.Video.FrameReady {
-			if e.options.Speed > 0 {
-				// Cap rendering to 60 fps
-				select {
-				case <-frameSync.C:
-				case <-ctx.Done():
-					return nil
-				}
+			// Cap rendering to 60 fps (at realtime speed)
+			if !limiter.wait(ctx) {
+				return nil
 			}
 
-			select {
-			case e.FrameChan <- e.Video.Frame:
-			case <-ctx.Done():
+			if !e.sendFrame(ctx) {
 				return nil
 			}
 		}
+
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (e *Emulator) snapshot(path string) error {
-	data, err := json.Marshal(e)
+// RenderNextFrame runs the loaded ROM until the next frame is ready, and
+// returns a copy of it
+//
+// Unlike Continue, the frame is returned directly rather than sent on
+// FrameChan, and the speed setting is ignored. Breakpoints are ignored. If the
+// CPU powers off before a frame is ready, the last rendered frame is returned.
+// Panics with a *CrashError if emulation crashes.
+func (e *Emulator) RenderNextFrame() Frame {
+	for e.CPU.PowerOn {
+		var crash *CrashError
+		if _, err := e.Step(); errors.As(err, &crash) {
+			panic(crash)
+		}
+
+		if e.frameReady {
+			e.frameReady = false
+			e.Joypad.NextFrame()
+			break
+		}
+	}
+
+	return cloneFrame(e.Video.Frame)
+}
+
+// RunFrames runs the loaded ROM until the given number of frames have been
+// completed, and returns a copy of the last frame
+//
+// Frames are not sent on FrameChan. Rendering is capped to 60 fps (scaled by
+// the speed, see WithSpeed) unless WithSpeedUncapped is set. Returns ErrBreakpoint (along with the current
+// frame) if a breakpoint is reached first.
+func (e *Emulator) RunFrames(ctx context.Context, frames int) (Frame, error) {
+	limiter := e.newFrameLimiter()
+	defer limiter.stop()
+
+	for completed := 0; completed < frames && e.CPU.PowerOn; {
+		select {
+		case <-ctx.Done():
+			return cloneFrame(e.Video.Frame), ctx.Err()
+		default:
+		}
+
+		_, err := e.Step()
+
+		if e.frameReady {
+			e.frameReady = false
+			e.Joypad.NextFrame()
+			completed++
+
+			if !limiter.wait(ctx) {
+				return cloneFrame(e.Video.Frame), ctx.Err()
+			}
+		}
+
+		if err != nil {
+			return cloneFrame(e.Video.Frame), err
+		}
+	}
+
+	return cloneFrame(e.Video.Frame), nil
+}
+
+// frameInterval returns the time between frames at the configured speed, or 0
+// if the speed is uncapped
+func (e *Emulator) frameInterval() time.Duration {
+	speed := e.speed()
+	if speed <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / (framesPerSecond * speed))
+}
+
+// frameLimiter caps the rate at which frames are completed to frameInterval,
+// following changes to the speed while running. stop must be called to release
+// resources.
+type frameLimiter struct {
+	e        *Emulator
+	interval time.Duration
+	ticker   Ticker
+}
+
+func (e *Emulator) newFrameLimiter() *frameLimiter {
+	return &frameLimiter{e: e}
+}
+
+// wait blocks until the next frame is due, and returns false if ctx was done
+// first. Returns immediately if the speed is uncapped.
+func (l *frameLimiter) wait(ctx context.Context) bool {
+	if interval := l.e.frameInterval(); interval != l.interval {
+		l.stop()
+		l.interval = interval
+		if interval > 0 {
+			l.ticker = l.e.clock.NewTicker(interval)
+		}
+	}
+
+	if l.ticker == nil {
+		return true
+	}
+
+	select {
+	case <-l.ticker.Chan():
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l *frameLimiter) stop() {
+	if l.ticker != nil {
+		l.ticker.Stop()
+		l.ticker = nil
+	}
+}
+
+// sendFrame sends a copy of the completed frame on FrameChan, or on
+// FrameUpdateChan if enabled by WithFrameUpdates. Returns false if ctx was done
+// first.
+//
+// A copy is sent as the video controller renders the next frame into the same
+// buffer while the receiver is drawing it.
+func (e *Emulator) sendFrame(ctx context.Context) bool {
+	if !e.options.FrameUpdates {
+		select {
+		case e.FrameChan <- cloneFrame(e.Video.Frame):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	update := FrameUpdate{
+		Frame:     cloneFrame(e.Video.Frame),
+		DirtyRows: append([]int(nil), e.Video.DirtyRows()...),
+	}
+	if e.options.BackgroundMap {
+		update.Background = e.backgroundMap()
+	}
+
+	select {
+	case e.FrameUpdateChan <- update:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// FrameUpdate is sent on FrameUpdateChan for every completed frame, see
+// WithFrameUpdates
+type FrameUpdate struct {
+	// Frame is the completed frame
+	Frame Frame
+
+	// DirtyRows are the rows (in ascending order) of Frame that changed
+	// compared to the frame sent before it, see videoController.DirtyRows
+	DirtyRows []int
+
+	// Background is the background map when the frame was completed, or nil
+	// unless enabled by WithBackgroundMap
+	Background *BackgroundMap
+}
+
+// BackgroundMap is the full 256x256 background of a tile map (see
+// RenderTileMap), and the position of the visible screen area on it
+type BackgroundMap struct {
+	Frame   Frame
+	ScrollX uint8
+	ScrollY uint8
+}
+
+// backgroundMap renders the background map currently selected in LCDC
+//
+// Must be called on the goroutine running the emulator, as rendering shares
+// decoded tiles with the video controller.
+func (e *Emulator) backgroundMap() *BackgroundMap {
+	return &BackgroundMap{
+		Frame:   e.Video.RenderTileMap(e.Video.readFlag(flagBGTileMapSelect)),
+		ScrollX: e.Video.readRegister(registerFF43),
+		ScrollY: e.Video.readRegister(registerFF42),
+	}
+}
+
+// cloneFrame returns a deep copy of frame, as the video controller renders
+// every frame into the same buffer
+func cloneFrame(frame Frame) Frame {
+	clone := make(Frame, len(frame))
+	for row := range frame {
+		clone[row] = append([]Shade(nil), frame[row]...)
+	}
+	return clone
+}
+
+// Step executes exactly one CPU instruction (or interrupt dispatch), and
+// progresses all other components by the number of machine cycles it took
+//
+// Returns ErrBreakpoint if the program counter reached a breakpoint, in which
+// case the instruction at the breakpoint is run by the next call to Step.
+// Returns a *CrashError if emulation crashed, e.g. on an illegal instruction.
+func (e *Emulator) Step() (cycles int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = e.newCrashError(r)
+		}
+	}()
+
+	cycles = e.CPU.Cycle()
+	for i := e.CPU.ticks; i < cycles; i++ {
+		e.tick()
+	}
+
+	if e.breakpoints[e.CPU.ProgramCounter] {
+		return cycles, ErrBreakpoint
+	}
+	return cycles, nil
+}
+
+// dotsPerMachineCycle is the number of dots (clock cycles) the PPU renders per
+// machine cycle
+const dotsPerMachineCycle = 4
+
+// tick progresses all components, except for the CPU, by a single machine cycle
+func (e *Emulator) tick() {
+	e.Memory.dma.Cycle()
+	e.Memory.sound.Cycle()
+	for i := 0; i < dotsPerMachineCycle; i++ {
+		e.Video.Cycle()
+		if e.Video.FrameReady {
+			e.frameReady = true
+		}
+	}
+	e.Timer.Cycle()
+	e.Serial.Cycle()
+	e.Joypad.Cycle()
+
+	e.Interrupt.CheckSourcesForInterrupts()
+
+	e.sampleAudio()
+}
+
+const (
+	// machineCyclesPerSecond is the clock speed of the CPU in machine cycles
+	machineCyclesPerSecond = 1 << 20
+
+	// sampleBufferSize is the number of audio samples sent per buffer on
+	// SampleChan
+	sampleBufferSize = 512
+
+	// sampleChanSize is the number of buffers SampleChan holds before buffers
+	// are dropped
+	sampleChanSize = 8
+)
+
+// sampleAudio downsamples the output of the sound controller to the sample
+// rate, and sends full buffers on SampleChan without blocking
+func (e *Emulator) sampleAudio() {
+	e.samplePhase += e.options.SampleRate
+	if e.samplePhase < machineCyclesPerSecond {
+		return
+	}
+	e.samplePhase -= machineCyclesPerSecond
+
+	e.samples = append(e.samples, e.Memory.sound.Sample())
+	if len(e.samples) < sampleBufferSize {
+		return
+	}
+
+	select {
+	case e.SampleChan <- e.samples:
+	default: // no consumer is keeping up, drop the buffer
+	}
+	e.samples = make([]float32, 0, sampleBufferSize)
+}
+
+// SetBreakpoint stops emulation (see Step and Continue) when the program
+// counter reaches pc
+func (e *Emulator) SetBreakpoint(pc uint16) {
+	e.breakpoints[pc] = true
+}
+
+// ClearBreakpoint removes the breakpoint at pc, if any
+func (e *Emulator) ClearBreakpoint(pc uint16) {
+	delete(e.breakpoints, pc)
+}
+
+// Cartridge returns the header of the loaded cartridge
+func (e *Emulator) Cartridge() CartridgeHeader {
+	return e.Memory.rom.Header()
+}
+
+// MBCState returns the current banking state of the cartridge
+func (e *Emulator) MBCState() MBCState {
+	return e.Memory.rom.State()
+}
+
+// BankSwitchLog returns all bank switches made by the cartridge so far
+//
+// Requires the emulator to be created using WithBankSwitchLog.
+func (e *Emulator) BankSwitchLog() []BankSwitchEvent {
+	if e.Memory.rom.bankSwitches == nil {
+		return nil
+	}
+
+	events := make([]BankSwitchEvent, len(e.Memory.rom.bankSwitches.events))
+	copy(events, e.Memory.rom.bankSwitches.events)
+	return events
+}
+
+// OpcodeHistogram returns the number of times each (unprefixed) opcode has
+// been executed
+//
+// Requires the emulator to be created using WithOpcodeHistogram. Opcodes that
+// have not been executed are omitted.
+func (e *Emulator) OpcodeHistogram() map[byte]uint64 {
+	if e.CPU.histogram == nil {
+		return map[byte]uint64{}
+	}
+	return histogramToMap(e.CPU.histogram.unprefixed)
+}
+
+// CBOpcodeHistogram returns the number of times each 0xCB-prefixed opcode has
+// been executed, keyed by the second byte of the opcode
+//
+// Requires the emulator to be created using WithOpcodeHistogram.
+func (e *Emulator) CBOpcodeHistogram() map[byte]uint64 {
+	if e.CPU.histogram == nil {
+		return map[byte]uint64{}
+	}
+	return histogramToMap(e.CPU.histogram.cbPrefixed)
+}
+
+func histogramToMap(counts [256]uint64) map[byte]uint64 {
+	result := map[byte]uint64{}
+	for opcode, count := range counts {
+		if count > 0 {
+			result[byte(opcode)] = count
+		}
+	}
+	return result
+}
+
+// saveFilePath returns the path of the save file for the ROM at romPath
+func (e *Emulator) saveFilePath(romPath string) string {
+	if e.options.SaveFile != "" {
+		return e.options.SaveFile
+	}
+
+	return strings.TrimSuffix(romPath, filepath.Ext(romPath)) + ".sav"
+}
+
+// loadSaveFile restores battery-backed cartridge RAM from the save file, if
+// the cartridge has a battery and the file exists
+func (e *Emulator) loadSaveFile(romPath string) error {
+	if !e.Memory.rom.battery {
+		return nil
+	}
+
+	path := e.saveFilePath(romPath)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	log.Printf("loading cartridge RAM from %s", path)
+	return e.Memory.rom.LoadRAM(data)
+}
+
+// saveFileInterval is the number of frames after which cartridge RAM written
+// to since the last save is persisted while running (about 10 seconds), in
+// case the program does not disable RAM after saving
+const saveFileInterval = 10 * framesPerSecond
+
+// flushSaveFile writes the save file while running if requested by the
+// cartridge (see rom.saveRequested) and cartridge RAM was written to since the
+// last save
+//
+// The save file is only written for ROMs loaded from a file, or if set using
+// WithSaveFile.
+func (e *Emulator) flushSaveFile() {
+	rom := e.Memory.rom
+	if !rom.saveRequested {
+		return
+	}
+	rom.saveRequested = false
+	e.framesSinceSave = 0
+
+	if !rom.ramDirty || (e.romPath == "" && e.options.SaveFile == "") {
+		return
+	}
+
+	// RAM remains dirty if writing fails, such that it is retried
+	if err := e.writeSaveFile(e.romPath); err != nil {
+		log.Printf("WARNING: failed to write save file: %v", err)
+		return
+	}
+	rom.ramDirty = false
+}
+
+// writeSaveFile persists battery-backed cartridge RAM to the save file
+func (e *Emulator) writeSaveFile(romPath string) error {
+	if !e.Memory.rom.battery {
+		return nil
+	}
+
+	path := e.saveFilePath(romPath)
+	log.Printf("writing cartridge RAM to %s", path)
+	return writeFileAtomic(path, e.Memory.rom.RAM(), 0644)
+}
+
+// writeFileAtomic writes data to a temporary file next to path, and renames it
+// to path once written, such that the existing file at path is never left
+// partially written (e.g. if the emulator is killed while writing)
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(f.Name()) // no-op once renamed
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
 
-	return ioutil.WriteFile(path, data, 0644)
+	return os.Rename(f.Name(), path)
 }
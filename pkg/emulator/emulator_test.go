@@ -2,9 +2,16 @@ package emulator
 
 import (
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -18,21 +25,18 @@ func TestEmulatorBlarggSuite(t *testing.T) {
 		},
 		// TODO: sound tests
 		// TODO: interrupt timing tests
-		/*
-			TODO: The timing emulation is simplified, making all changes in a single
-			cycle and then waiting for the remainder of the cycles. In reality, the
-			read/write operation(s) should happen on the last cycle (if only one), or
-			last two cycles (if both).
-			{
-			  testROM: "mem_timing/individual/01-read_timing.gb",
-			},
-			{
-			  testROM: "mem_timing/individual/02-write_timing.gb",
-			},
-			{
-			  testROM: "mem_timing/individual/03-modify_timing.gb",
-			},
-		*/
+		{
+			testROM: "mem_timing/individual/01-read_timing.gb",
+		},
+		{
+			testROM: "mem_timing/individual/02-write_timing.gb",
+		},
+		{
+			testROM: "mem_timing/individual/03-modify_timing.gb",
+		},
+		{
+			testROM: "cpu_instrs/cpu_instrs.gb",
+		},
 		{
 			testROM: "cpu_instrs/individual/01-special.gb",
 		},
@@ -80,21 +84,16 @@ func TestEmulatorBlarggSuite(t *testing.T) {
 			ctx, cancel := context.WithCancel(ctx)
 			defer cancel()
 
-			e := New(
-				WithSpeedUncapped(),
-				WithSerialDataCallback(serialDataCallback))
-
 			// Detect if the Blargg test has completed
 			//
 			// The test will enter an infinite loop when done (failed or succeeded)
 			// by calling JR -2.
-			lastObservedPC := uint16(0)
-			e.CPU.instructionCallback = func(mnemonic string, pc uint16) {
-				if pc == lastObservedPC {
+			e := New(
+				WithSpeedUncapped(),
+				WithSerialDataCallback(serialDataCallback),
+				WithStuckDetection(func(pc uint16) {
 					cancel() // Loop detected, indicates the Blargg test is done
-				}
-				lastObservedPC = pc
-			}
+				}))
 
 			go func() {
 				for {
@@ -113,3 +112,884 @@ func TestEmulatorBlarggSuite(t *testing.T) {
 		})
 	}
 }
+
+func TestOpcodeHistogramCountsExecutedOpcodes(t *testing.T) {
+	e := New(WithOpcodeHistogram())
+
+	program := []byte{
+		0x00,       // NOP
+		0x00,       // NOP
+		0x00,       // NOP
+		0x3C,       // INC A
+		0xCB, 0x37, // SWAP A
+		0x00, // NOP
+	}
+	for i, b := range program {
+		e.Memory.Write8(0xC000+uint16(i), b)
+	}
+	e.CPU.ProgramCounter = 0xC000
+
+	for i := 0; i < 6; i++ {
+		e.CPU.Cycle()
+	}
+
+	require.Equal(t, map[byte]uint64{0x00: 4, 0x3C: 1}, e.OpcodeHistogram())
+	require.Equal(t, map[byte]uint64{0x37: 1}, e.CBOpcodeHistogram())
+}
+
+func TestMBCStateReportsSelectedBanks(t *testing.T) {
+	tests := []struct {
+		name        string
+		mbcProtocol byte
+		writes      map[uint16]byte
+		wantState   MBCState
+	}{
+		{
+			name:        "MBC1 ROM bank",
+			mbcProtocol: 0x01,
+			writes:      map[uint16]byte{0x2000: 0x05},
+			wantState:   MBCState{ROMBank: 5},
+		},
+		{
+			name:        "MBC1 RAM banking mode",
+			mbcProtocol: 0x03,
+			writes:      map[uint16]byte{0x0000: 0x0A, 0x2000: 0x05, 0x4000: 0x02, 0x6000: 0x01},
+			wantState:   MBCState{ROMBank: 5, RAMBank: 2, RAMEnabled: true, RAMBankingMode: true},
+		},
+		{
+			name:        "MBC3 ROM and RAM bank",
+			mbcProtocol: 0x13,
+			writes:      map[uint16]byte{0x0000: 0x0A, 0x2000: 0x25, 0x4000: 0x03},
+			wantState:   MBCState{ROMBank: 0x25, RAMBank: 3, RAMEnabled: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := New()
+			require.NoError(t, e.Memory.LoadROM(writeBankedROM(t, 64, tt.mbcProtocol, 0x03)))
+
+			for address, v := range tt.writes {
+				e.Memory.Write8(address, v)
+			}
+
+			require.Equal(t, tt.wantState, e.MBCState())
+		})
+	}
+}
+
+func TestBankSwitchLogRecordsTriggeringInstruction(t *testing.T) {
+	e := New(WithBankSwitchLog())
+	require.NoError(t, e.Memory.LoadROM(writeBankedROM(t, 8, 0x01, 0x00)))
+
+	program := []byte{
+		0x3E, 0x05, // LD A,0x05
+		0xEA, 0x00, 0x20, // LD (0x2000),A - select ROM bank 5
+		0xEA, 0x00, 0x20, // LD (0x2000),A - bank remains unchanged
+		0x3E, 0x03, // LD A,0x03
+		0xEA, 0x00, 0x20, // LD (0x2000),A - select ROM bank 3
+	}
+	for i, b := range program {
+		e.Memory.Write8(0xC000+uint16(i), b)
+	}
+	e.CPU.ProgramCounter = 0xC000
+
+	for i := 0; i < 5; i++ {
+		e.CPU.Cycle()
+	}
+
+	require.Equal(t, []BankSwitchEvent{
+		{Type: BankROM, Bank: 5, PC: 0xC002, Cycle: 2},
+		{Type: BankROM, Bank: 3, PC: 0xC00A, Cycle: 12},
+	}, e.BankSwitchLog())
+}
+
+func TestSaveFilePersistsBatteryBackedRAM(t *testing.T) {
+	romPath := writeBankedROM(t, 4, 0x03, 0x03) // MBC1+RAM+BATTERY
+	savePath := strings.TrimSuffix(romPath, ".gb") + ".sav"
+
+	e := New()
+	require.NoError(t, e.Memory.LoadROM(romPath))
+	require.NoError(t, e.loadSaveFile(romPath))
+	e.Memory.Write8(0x0000, 0x0A) // enable RAM
+	e.Memory.Write8(0xA000, 0x42)
+	e.Memory.Write8(0xBFFF, 0x24)
+	require.NoError(t, e.writeSaveFile(romPath))
+	require.FileExists(t, savePath)
+
+	reloaded := New()
+	require.NoError(t, reloaded.Memory.LoadROM(romPath))
+	require.NoError(t, reloaded.loadSaveFile(romPath))
+	reloaded.Memory.Write8(0x0000, 0x0A) // enable RAM
+	require.Equal(t, uint8(0x42), reloaded.Memory.Read8(0xA000))
+	require.Equal(t, uint8(0x24), reloaded.Memory.Read8(0xBFFF))
+}
+
+func TestSaveFileUsesConfiguredPath(t *testing.T) {
+	romPath := writeBankedROM(t, 4, 0x13, 0x03) // MBC3+RAM+BATTERY
+	savePath := filepath.Join(filepath.Dir(romPath), "custom.sav")
+
+	e := New(WithSaveFile(savePath))
+	require.NoError(t, e.Memory.LoadROM(romPath))
+	e.Memory.Write8(0x0000, 0x0A) // enable RAM
+	e.Memory.Write8(0xA000, 0x42)
+	require.NoError(t, e.writeSaveFile(romPath))
+	require.FileExists(t, savePath)
+	_, err := os.Stat(strings.TrimSuffix(romPath, ".gb") + ".sav")
+	require.True(t, os.IsNotExist(err), "expected no save file to be written")
+}
+
+func TestSaveFileWrittenWhenRAMIsDisabled(t *testing.T) {
+	romPath := writeBankedROM(t, 4, 0x03, 0x03) // MBC1+RAM+BATTERY
+	savePath := strings.TrimSuffix(romPath, ".gb") + ".sav"
+
+	e := New()
+	require.NoError(t, e.Load(romPath, ""))
+	e.Memory.Write8(0x0000, 0x0A) // enable RAM
+	e.Memory.Write8(0xA000, 0x42)
+	e.flushSaveFile()
+	_, err := os.Stat(savePath)
+	require.True(t, os.IsNotExist(err), "expected no save file while RAM is enabled")
+
+	e.Memory.Write8(0x0000, 0x00) // disable RAM
+	e.flushSaveFile()
+	data, err := ioutil.ReadFile(savePath)
+	require.NoError(t, err)
+	require.Equal(t, uint8(0x42), data[0])
+
+	// Disabling RAM again without writing to it does not rewrite the file
+	require.NoError(t, os.Remove(savePath))
+	e.Memory.Write8(0x0000, 0x0A)
+	e.Memory.Write8(0x0000, 0x00)
+	e.flushSaveFile()
+	_, err = os.Stat(savePath)
+	require.True(t, os.IsNotExist(err), "expected save file to only be written when RAM changed")
+}
+
+func TestSaveFileRetriedWhenWriteFails(t *testing.T) {
+	romPath := writeBankedROM(t, 4, 0x03, 0x03) // MBC1+RAM+BATTERY
+	saveDir := filepath.Join(filepath.Dir(romPath), "saves")
+	savePath := filepath.Join(saveDir, "rom.sav")
+
+	e := New(WithSaveFile(savePath))
+	require.NoError(t, e.Load(romPath, ""))
+	e.Memory.Write8(0x0000, 0x0A) // enable RAM
+	e.Memory.Write8(0xA000, 0x42)
+	e.Memory.Write8(0x0000, 0x00) // disable RAM
+	e.flushSaveFile()             // fails, as the directory does not exist
+
+	require.NoError(t, os.Mkdir(saveDir, 0755))
+	e.Memory.Write8(0x0000, 0x0A)
+	e.Memory.Write8(0x0000, 0x00)
+	e.flushSaveFile()
+
+	data, err := ioutil.ReadFile(savePath)
+	require.NoError(t, err)
+	require.Equal(t, uint8(0x42), data[0])
+
+	files, err := ioutil.ReadDir(saveDir)
+	require.NoError(t, err)
+	require.Len(t, files, 1, "expected no temporary files to be left behind")
+}
+
+func TestSaveFileIgnoredWithoutBattery(t *testing.T) {
+	romPath := writeBankedROM(t, 4, 0x01, 0x00) // MBC1
+
+	e := New()
+	require.NoError(t, e.Memory.LoadROM(romPath))
+	e.Memory.Write8(0xA000, 0x42)
+	require.NoError(t, e.writeSaveFile(romPath))
+	_, err := os.Stat(strings.TrimSuffix(romPath, ".gb") + ".sav")
+	require.True(t, os.IsNotExist(err), "expected no save file to be written")
+}
+
+// TestEmulatorHasNoGUIDependencies ensures the emulator (and its tests) can run
+// headless, e.g. in a Linux CI environment, by not depending on any windowing
+// or rendering library.
+func TestEmulatorHasNoGUIDependencies(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	out, err := exec.Command("go", "list", "-deps", "-test", ".").CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	for _, pkg := range strings.Split(string(out), "\n") {
+		for _, gui := range []string{"github.com/skelterjohn/go.wde", "github.com/faiface/pixel"} {
+			require.False(t, strings.HasPrefix(pkg, gui), "unexpected GUI dependency %s", pkg)
+		}
+	}
+}
+
+func TestStepStopsAtBreakpoint(t *testing.T) {
+	e := New()
+
+	program := []byte{
+		0x00, // NOP
+		0x00, // NOP
+		0x3C, // INC A
+		0x3C, // INC A
+	}
+	for i, b := range program {
+		e.Memory.Write8(0xC000+uint16(i), b)
+	}
+	e.CPU.ProgramCounter = 0xC000
+	e.CPU.Registers.Data[registerA] = 0
+
+	e.SetBreakpoint(0xC002)
+
+	cycles, err := e.Step()
+	require.NoError(t, err)
+	require.Equal(t, 1, cycles)
+
+	_, err = e.Step()
+	require.Equal(t, ErrBreakpoint, err)
+	require.Equal(t, uint16(0xC002), e.CPU.ProgramCounter)
+	require.Equal(t, uint8(0), e.CPU.Registers.Data[registerA], "expected instruction at breakpoint to not be executed")
+
+	// Stepping again runs the instruction at the breakpoint
+	_, err = e.Step()
+	require.NoError(t, err)
+	require.Equal(t, uint16(0xC003), e.CPU.ProgramCounter)
+	require.Equal(t, uint8(1), e.CPU.Registers.Data[registerA])
+}
+
+func TestContinueRunsUntilBreakpoint(t *testing.T) {
+	e := New(WithSpeedUncapped())
+
+	program := []byte{
+		0x3C,       // INC A
+		0x3C,       // INC A
+		0x3C,       // INC A
+		0x18, 0xFB, // JR -5
+	}
+	for i, b := range program {
+		e.Memory.Write8(0xC000+uint16(i), b)
+	}
+	e.CPU.ProgramCounter = 0xC000
+	e.CPU.Registers.Data[registerA] = 0
+
+	e.SetBreakpoint(0xC002)
+	require.Equal(t, ErrBreakpoint, e.Continue(context.Background()))
+	require.Equal(t, uint16(0xC002), e.CPU.ProgramCounter)
+	require.Equal(t, uint8(2), e.CPU.Registers.Data[registerA])
+
+	// Continue from the breakpoint, looping back around to it
+	require.Equal(t, ErrBreakpoint, e.Continue(context.Background()))
+	require.Equal(t, uint16(0xC002), e.CPU.ProgramCounter)
+	require.Equal(t, uint8(5), e.CPU.Registers.Data[registerA])
+
+	e.ClearBreakpoint(0xC002)
+	e.SetBreakpoint(0xC003)
+	require.Equal(t, ErrBreakpoint, e.Continue(context.Background()))
+	require.Equal(t, uint16(0xC003), e.CPU.ProgramCounter)
+	require.Equal(t, uint8(6), e.CPU.Registers.Data[registerA])
+}
+
+func TestHALTWakesOnVBlankOncePerFrame(t *testing.T) {
+	e := New()
+
+	program := []byte{
+		0x76,       // HALT
+		0x3E, 0x00, // LD A,0x00
+		0xE0, 0x0F, // LDH (0x0F),A - clear pending interrupts
+		0x76, // HALT
+		0x00, // NOP
+	}
+	for i, b := range program {
+		e.Memory.Write8(0xC000+uint16(i), b)
+	}
+	e.CPU.ProgramCounter = 0xC000
+	e.CPU.Interrupts = interruptsDisabled
+	e.Memory.Write8(0xFFFF, 0x01)               // IE: VBLANK only
+	e.Memory.Write8(0xFF0F, 0x00)               // IF: nothing pending
+	e.Memory.Write8(uint16(registerFF40), 0x80) // Enable video, starting at line 0
+
+	// Record the clock cycle (4 per machine cycle) at which the CPU wakes up
+	var wakes []int
+	elapsed := 0
+	for len(wakes) < 2 {
+		halted := e.CPU.lowPowerMode
+		cycles, err := e.Step()
+		require.NoError(t, err)
+		if halted && !e.CPU.lowPowerMode {
+			wakes = append(wakes, elapsed)
+		}
+		elapsed += cycles * 4
+
+		require.True(t, elapsed < 3*70224, "expected CPU to wake from HALT")
+	}
+
+	require.InDelta(t, 144*456, wakes[0], 8, "expected first wake when entering VBLANK")
+	require.InDelta(t, 70224, wakes[1]-wakes[0], 8, "expected second wake one frame later")
+}
+
+func TestCALLAndRETCycles(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []byte
+		flagZ   bool
+		cycles  int
+		pc      uint16
+	}{
+		{name: "CALL", program: []byte{0xCD, 0x10, 0xC0}, cycles: 6, pc: 0xC010},
+		{name: "CALL Z taken", program: []byte{0xCC, 0x10, 0xC0}, flagZ: true, cycles: 6, pc: 0xC010},
+		{name: "CALL Z not taken", program: []byte{0xCC, 0x10, 0xC0}, cycles: 3, pc: 0xC003},
+		{name: "RET", program: []byte{0xC9}, cycles: 4, pc: 0x1234},
+		{name: "RET Z taken", program: []byte{0xC8}, flagZ: true, cycles: 5, pc: 0x1234},
+		{name: "RET Z not taken", program: []byte{0xC8}, cycles: 2, pc: 0xC001},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := New()
+			for i, b := range tt.program {
+				e.Memory.Write8(0xC000+uint16(i), b)
+			}
+			e.CPU.ProgramCounter = 0xC000
+			e.CPU.Registers.Write16(registerSP, 0xDFFC)
+			e.Memory.Write16(0xDFFC, 0x1234) // return address for RET
+			e.CPU.Registers.Write1(flagZ, tt.flagZ)
+
+			cycles, err := e.Step()
+			require.NoError(t, err)
+			require.Equal(t, tt.cycles, cycles)
+			require.Equal(t, tt.pc, e.CPU.ProgramCounter)
+		})
+	}
+}
+
+func TestInterruptPendingDuringCALLIsServicedAfterCALL(t *testing.T) {
+	e := New()
+
+	program := []byte{
+		0xCD, 0x10, 0xC0, // CALL $C010
+	}
+	for i, b := range program {
+		e.Memory.Write8(0xC000+uint16(i), b)
+	}
+	e.Memory.Write8(0xC010, 0x00) // NOP
+	e.CPU.ProgramCounter = 0xC000
+	e.CPU.Registers.Write16(registerSP, 0xDFFE)
+	e.CPU.Interrupts = interruptsEnabled
+
+	// Timer increments every 4 machine cycles, and overflows part way through
+	// the 6 machine cycles of the CALL
+	e.Memory.Write8(0xFFFF, 0x04) // IE: timer
+	e.Timer.Write8(0xFF05, 0xFF)  // TIMA
+	e.Timer.Write8(0xFF07, 0x05)  // TAC: enabled, 4 machine cycles
+
+	cycles, err := e.Step()
+	require.NoError(t, err)
+	require.Equal(t, 6, cycles)
+	require.Equal(t, uint16(0xC010), e.CPU.ProgramCounter, "expected CALL to complete before servicing the interrupt")
+	require.Equal(t, uint16(0xC003), e.Memory.Read16(0xDFFC))
+	require.Equal(t, uint8(0x04), e.Memory.Read8(0xFF0F)&0x04, "expected timer interrupt to be pending")
+
+	cycles, err = e.Step()
+	require.NoError(t, err)
+	require.Equal(t, 5, cycles)
+	require.Equal(t, uint16(0x0050), e.CPU.ProgramCounter)
+	require.Equal(t, uint16(0xC010), e.Memory.Read16(0xDFFA), "expected interrupt to return to CALL target")
+	require.Equal(t, uint8(0x00), e.Memory.Read8(0xFF0F)&0x04)
+}
+
+func TestRenderNextFrameReturnsConsecutiveFrames(t *testing.T) {
+	e := New()
+
+	// Increments the background palette once per frame
+	program := []byte{
+		0x76,       // HALT
+		0xAF,       // XOR A
+		0xE0, 0x0F, // LDH ($0F),A ; clear IF
+		0x04,       // INC B
+		0x78,       // LD A,B
+		0xE0, 0x47, // LDH ($47),A ; BGP=B
+		0x18, 0xF6, // JR -10
+	}
+	for i, b := range program {
+		e.Memory.Write8(0xC000+uint16(i), b)
+	}
+	e.CPU.ProgramCounter = 0xC000
+	e.CPU.Registers.Data[registerB] = 0
+	e.Memory.Write8(0xFFFF, 0x01) // IE: VBLANK
+	e.Memory.Write8(0xFF40, 0x91) // LCD on
+
+	first := e.RenderNextFrame()
+	second := e.RenderNextFrame()
+
+	require.Len(t, first, 144)
+	require.Len(t, second, 144)
+	require.NotEqual(t, first[0][0], second[0][0], "expected frames to use different palettes")
+	require.Equal(t, second, e.Video.Frame)
+}
+
+func TestSampleChanDeliversSamplesAtSampleRate(t *testing.T) {
+	e := New(WithSampleRate(32768)) // 1 sample every 32 machine cycles
+
+	e.Memory.Write8(0xC000, 0x18) // JR -2
+	e.Memory.Write8(0xC001, 0xFE)
+	e.CPU.ProgramCounter = 0xC000
+
+	cycles := 0
+	for cycles < 4*sampleBufferSize*32+100 {
+		n, err := e.Step()
+		require.NoError(t, err)
+		cycles += n
+	}
+
+	require.Len(t, e.SampleChan, 4)
+	for i := 0; i < 4; i++ {
+		require.Len(t, <-e.SampleChan, sampleBufferSize)
+	}
+	require.Len(t, e.samples, cycles/32-4*sampleBufferSize)
+}
+
+func TestSampleChanDropsBuffersWithoutConsumer(t *testing.T) {
+	e := New(WithSampleRate(machineCyclesPerSecond)) // 1 sample every machine cycle
+
+	e.Memory.Write8(0xC000, 0x18) // JR -2
+	e.Memory.Write8(0xC001, 0xFE)
+	e.CPU.ProgramCounter = 0xC000
+
+	for cycles := 0; cycles < 2*sampleChanSize*sampleBufferSize; {
+		n, err := e.Step()
+		require.NoError(t, err)
+		cycles += n
+	}
+
+	require.Len(t, e.SampleChan, sampleChanSize)
+}
+
+func TestContinueSendsFrameUpdatesOnlyIfEnabled(t *testing.T) {
+	receive := func(e *Emulator) (frame Frame, update *FrameUpdate) {
+		e.Memory.Write8(0xC000, 0x18) // JR -2
+		e.Memory.Write8(0xC001, 0xFE)
+		e.CPU.ProgramCounter = 0xC000
+		e.Memory.Write8(0xFF40, 0x80) // enable LCD
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- e.Continue(ctx)
+		}()
+
+		select {
+		case frame = <-e.FrameChan:
+		case u := <-e.FrameUpdateChan:
+			update = &u
+		}
+		cancel()
+		require.NoError(t, <-done)
+		return frame, update
+	}
+
+	e := New(WithSpeedUncapped())
+	frame, update := receive(e)
+	require.Len(t, frame, 144)
+	require.Nil(t, update, "expected frames on FrameChan by default")
+	require.True(t, &e.Video.Frame[0][0] != &frame[0][0], "expected a copy of the frame")
+
+	e = New(WithSpeedUncapped(), WithFrameUpdates())
+	frame, update = receive(e)
+	require.Nil(t, frame, "expected no frames on FrameChan")
+	require.NotNil(t, update)
+	require.Len(t, update.Frame, 144)
+	require.True(t, &e.Video.Frame[0][0] != &update.Frame[0][0], "expected a copy of the frame")
+}
+
+func TestContinueSendsBackgroundMapWithFrame(t *testing.T) {
+	receiveUpdate := func(e *Emulator) FrameUpdate {
+		e.Memory.Write8(0xC000, 0x18) // JR -2
+		e.Memory.Write8(0xC001, 0xFE)
+		e.CPU.ProgramCounter = 0xC000
+		e.Memory.Write8(0x8010, 0xFF) // tile 1, row 0 uses color 1
+		e.Memory.Write8(0x9C00, 0x01) // place tile 1 in the top left of the 0x9C00 map
+		e.Memory.Write8(0xFF42, 0x12) // SCY
+		e.Memory.Write8(0xFF43, 0x34) // SCX
+		e.Memory.Write8(0xFF40, 0x98) // enable LCD, tile data at 0x8000, map at 0x9C00
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- e.Continue(ctx)
+		}()
+
+		update := <-e.FrameUpdateChan
+		cancel()
+		require.NoError(t, <-done)
+		return update
+	}
+
+	update := receiveUpdate(New(WithSpeedUncapped(), WithBackgroundMap()))
+	require.NotNil(t, update.Background)
+	require.Equal(t, Shade(1), update.Background.Frame[0][0])
+	require.Equal(t, Shade(0), update.Background.Frame[1][0])
+	require.Equal(t, uint8(0x34), update.Background.ScrollX)
+	require.Equal(t, uint8(0x12), update.Background.ScrollY)
+
+	update = receiveUpdate(New(WithSpeedUncapped(), WithFrameUpdates()))
+	require.Nil(t, update.Background, "expected no background map unless enabled")
+}
+
+func TestContinueSendsDirtyRowsWithFrame(t *testing.T) {
+	e := New(WithSpeedUncapped(), WithFrameUpdates())
+	e.Memory.Write8(0xC000, 0x18) // JR -2
+	e.Memory.Write8(0xC001, 0xFE)
+	e.CPU.ProgramCounter = 0xC000
+	e.Memory.Write8(0x8010, 0xFF) // tile 1, row 0 uses color 1
+	e.Memory.Write8(0x9800, 0x01) // place tile 1 in the top left of the 0x9800 map
+	e.Memory.Write8(0xFF47, 0xE4) // color 0 = white, color 3 = black
+	e.Memory.Write8(0xFF40, 0x91) // enable LCD and BG, tile data at 0x8000
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- e.Continue(ctx)
+	}()
+
+	update := <-e.FrameUpdateChan
+	require.Equal(t, []int{0}, update.DirtyRows)
+	update = <-e.FrameUpdateChan
+	require.Empty(t, update.DirtyRows, "expected unchanged frame to have no dirty rows")
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestRunFramesReturnsLastFrame(t *testing.T) {
+	path := writeBankedROM(t, 2, 0x00, 0x00)
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	// Fills tile 0 with a checkerboard, which is used for the entire background
+	program := []byte{
+		0xC3, 0x50, 0x01, // JP $0150
+	}
+	copy(data[0x0100:], program)
+	program = []byte{
+		0xAF,       // XOR A
+		0xE0, 0x40, // LDH ($40),A ; LCD off
+		0x21, 0x00, 0x80, // LD HL,$8000
+		0x06, 0x04, // LD B,4
+		0x3E, 0xAA, // LD A,$AA
+		0x22,       // LD (HL+),A
+		0x22,       // LD (HL+),A
+		0x3E, 0x55, // LD A,$55
+		0x22,       // LD (HL+),A
+		0x22,       // LD (HL+),A
+		0x05,       // DEC B
+		0x20, 0xF5, // JR NZ,-11
+		0x3E, 0x91, // LD A,$91
+		0xE0, 0x40, // LDH ($40),A ; LCD on
+		0x18, 0xFE, // JR -2
+	}
+	copy(data[0x0150:], program)
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+
+	e := New(WithSpeedUncapped())
+	require.NoError(t, e.Load(path, ""))
+
+	frame, err := e.RunFrames(context.Background(), 3)
+	require.NoError(t, err)
+	require.Len(t, frame, 144)
+
+	require.Equal(t, black, frame[0][0])
+	require.Equal(t, white, frame[0][1])
+	require.Equal(t, white, frame[1][0])
+	require.Equal(t, black, frame[1][1])
+
+	hash := sha256.New()
+	for _, row := range frame {
+		for _, shade := range row {
+			hash.Write([]byte{byte(shade)})
+		}
+	}
+	require.Equal(t, "4a976c20328b1dfebd49bb9abdf10ce057aa6c42728f1a3a3e64fc55bcc81bc3", fmt.Sprintf("%x", hash.Sum(nil)))
+}
+
+func TestRunFramesStopsWhenCancelled(t *testing.T) {
+	e := New()
+	e.Memory.Write8(0xC000, 0x18) // JR -2
+	e.Memory.Write8(0xC001, 0xFE)
+	e.CPU.ProgramCounter = 0xC000
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := e.RunFrames(ctx, 1)
+	require.Equal(t, context.Canceled, err)
+}
+
+func TestFrameIntervalScalesWithSpeed(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  OptionFunc
+		want time.Duration
+	}{
+		{name: "realtime", opt: WithSpeed(1), want: time.Second / 60},
+		{name: "slow motion", opt: WithSpeed(0.5), want: time.Second / 30},
+		{name: "fast forward", opt: WithSpeed(2), want: time.Second / 120},
+		{name: "uncapped", opt: WithSpeedUncapped(), want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := New(tt.opt)
+			require.Equal(t, tt.want, e.frameInterval())
+		})
+	}
+}
+
+func TestSetSpeedChangesFrameCap(t *testing.T) {
+	clock := newFakeClock()
+	e := New(WithSpeedUncapped(), WithClock(clock))
+	e.Memory.Write8(0xC000, 0x18) // JR -2
+	e.Memory.Write8(0xC001, 0xFE)
+	e.CPU.ProgramCounter = 0xC000
+	e.Memory.Write8(0xFF40, 0x80) // enable LCD
+
+	// Uncapped frames don't wait for the clock
+	require.Equal(t, 0, clock.runFrames(t, e, 6))
+	require.Empty(t, clock.tickerIntervals())
+
+	e.SetSpeed(0.5)
+	require.Equal(t, time.Second/30, e.frameInterval())
+	require.Equal(t, 6, clock.runFrames(t, e, 6))
+	require.Equal(t, []time.Duration{time.Second / 30}, clock.tickerIntervals())
+
+	// The speed can be changed from another goroutine while running, and takes
+	// effect from the next frame
+	done := make(chan error)
+	go func() {
+		_, err := e.RunFrames(context.Background(), 6)
+		done <- err
+	}()
+	for i := 0; i < 3; i++ {
+		clock.ticks <- clock.now
+	}
+	e.SetSpeed(1)
+	for running := true; running; {
+		select {
+		case clock.ticks <- clock.now:
+		case err := <-done:
+			require.NoError(t, err)
+			running = false
+		}
+	}
+	require.Equal(t, []time.Duration{time.Second / 30, time.Second / 30, time.Second / 60}, clock.tickerIntervals())
+}
+
+func TestRunFramesAtDoubleSpeedHalvesTheFrameInterval(t *testing.T) {
+	tests := []struct {
+		speed    float64
+		interval time.Duration
+	}{
+		{speed: 1, interval: time.Second / 60},
+		{speed: 2, interval: time.Second / 120},
+	}
+
+	for _, test := range tests {
+		clock := newFakeClock()
+		e := New(WithSpeed(test.speed), WithClock(clock))
+		e.Memory.Write8(0xC000, 0x18) // JR -2
+		e.Memory.Write8(0xC001, 0xFE)
+		e.CPU.ProgramCounter = 0xC000
+		e.Memory.Write8(0xFF40, 0x80) // enable LCD
+
+		ticks := clock.runFrames(t, e, 12)
+		require.Equal(t, 12, ticks, "expected one tick per frame at %vx", test.speed)
+		require.Equal(t, []time.Duration{test.interval}, clock.tickerIntervals(), "at %vx", test.speed)
+	}
+}
+
+func TestResetRestoresPostBootState(t *testing.T) {
+	path := writeBankedROM(t, 2, 0x00, 0x00)
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	copy(data[0x0100:], []byte{
+		0x3E, 0x42, // LD A,$42
+		0xEA, 0x00, 0xC0, // LD ($C000),A
+		0xE0, 0x80, // LDH ($80),A
+		0x04,       // INC B
+		0x18, 0xFD, // JR -3
+	})
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+
+	e := New(WithSpeedUncapped())
+	require.NoError(t, e.Load(path, ""))
+	_, err = e.RunFrames(context.Background(), 2)
+	require.NoError(t, err)
+
+	e.Memory.Write8(0xFF40, 0x00) // disable LCD to access VRAM
+	e.Memory.Write8(0x8000, 0x12)
+	e.Memory.Write8(0xFE00, 0x34)
+	e.Memory.Write8(0xFF07, 0x05)
+	require.Equal(t, uint8(0x42), e.PeekMemory(0xC000))
+	require.Equal(t, uint8(0x42), e.PeekMemory(0xFF80))
+
+	e.Reset()
+
+	require.Equal(t, RegisterSnapshot{
+		A: 0x01, F: 0xB0, B: 0x00, C: 0x13, D: 0x00, E: 0xD8, H: 0x01, L: 0x4D,
+		SP: 0xFFFE, PC: 0x0100,
+		FlagZ: true, FlagN: false, FlagH: true, FlagC: true,
+	}, e.RegisterState())
+	require.Equal(t, uint8(0x00), e.PeekMemory(0xC000), "expected WRAM to be cleared")
+	require.Equal(t, uint8(0x00), e.PeekMemory(0xFF80), "expected HRAM to be cleared")
+	require.Equal(t, uint8(0x00), e.PeekMemory(0x8000), "expected VRAM to be cleared")
+	require.Equal(t, uint8(0x00), e.PeekMemory(0xFE00), "expected OAM to be cleared")
+	require.Equal(t, uint8(0x00), e.PeekMemory(0xFF07), "expected timer to be reset")
+	require.Equal(t, uint8(0x91), e.PeekMemory(0xFF40))
+	require.Equal(t, uint8(0xFC), e.PeekMemory(0xFF47))
+	require.Equal(t, uint8(0x00), e.PeekMemory(0xFF04), "expected divider to be reset")
+
+	// The ROM is still loaded, and runs from the start
+	_, err = e.Step()
+	require.NoError(t, err)
+	require.Equal(t, uint8(0x42), e.RegisterState().A)
+}
+
+func TestRunReturnsErrorOnIllegalInstruction(t *testing.T) {
+	path := writeBankedROM(t, 2, 0x00, 0x00)
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	data[0x0100] = 0xD3 // ILLEGAL at the entry point
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+
+	e := New(WithSpeedUncapped())
+	err = e.Run(context.Background(), path, "")
+
+	var crash *CrashError
+	require.True(t, errors.As(err, &crash), "expected CrashError, got %v", err)
+	require.Equal(t, uint16(0x0100), crash.PC)
+	require.Equal(t, byte(0xD3), crash.Opcode)
+	require.EqualError(t, err, "emulation crashed at 0x0100 (opcode 0xd3): Illegal instruction [ILLEGAL] called")
+}
+
+func TestStuckDetection(t *testing.T) {
+	tests := []struct {
+		name    string
+		program map[uint16][]byte
+		want    []uint16
+	}{
+		{
+			name: "self jump",
+			program: map[uint16][]byte{
+				0x0100: {0x00, 0x18, 0xFE}, // NOP; JR -2
+			},
+			want: []uint16{0x0101},
+		},
+		{
+			name: "self jump interrupted by VBlank",
+			program: map[uint16][]byte{
+				0x0040: {0xD9}, // RETI
+				0x0100: {
+					0x3E, 0x01, // LD A,$01
+					0xE0, 0xFF, // LDH ($FF),A
+					0xFB,       // EI
+					0x18, 0xFE, // JR -2
+				},
+			},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeBankedROM(t, 2, 0x00, 0x00)
+			data, err := ioutil.ReadFile(path)
+			require.NoError(t, err)
+			for address, code := range tt.program {
+				copy(data[address:], code)
+			}
+			require.NoError(t, ioutil.WriteFile(path, data, 0644))
+
+			var stuck []uint16
+			e := New(WithSpeedUncapped(), WithStuckDetection(func(pc uint16) {
+				stuck = append(stuck, pc)
+			}))
+			require.NoError(t, e.Load(path, ""))
+
+			// Two seconds of emulated time
+			_, err = e.RunFrames(context.Background(), 120)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, stuck)
+		})
+	}
+}
+
+func TestEmbeddedBootROM(t *testing.T) {
+	boot := make([]byte, 256)
+	boot[0] = 0x31 // LD SP,$FFFE
+	boot[1] = 0xFE
+	boot[2] = 0xFF
+
+	e := New(WithEmbeddedBootROM(boot))
+	require.NoError(t, e.Load("testdata/roms/whiteout.gb", ""))
+	require.True(t, e.Memory.IsBootROMLoaded)
+	require.Equal(t, uint16(0x0000), e.CPU.ProgramCounter)
+	require.Equal(t, uint8(0x31), e.PeekMemory(0x0000))
+
+	// A boot ROM path takes precedence
+	e = New(WithEmbeddedBootROM(boot))
+	require.NoError(t, e.Load("testdata/roms/whiteout.gb", "testdata/roms/boot-whiteout.gb"))
+	require.Equal(t, uint8(0x02), e.PeekMemory(0x0000))
+}
+
+func TestLoadROMBytesRunsROM(t *testing.T) {
+	data := make([]byte, bytes32k)
+	copy(data[0x0100:], []byte{
+		0x3E, 0x42, // LD A,$42
+		0xEA, 0x00, 0xC0, // LD ($C000),A
+		0x18, 0xFE, // JR -2
+	})
+
+	e := New(WithSpeedUncapped())
+	require.NoError(t, e.LoadROMBytes(data, ""))
+	require.Equal(t, uint16(0x0100), e.CPU.ProgramCounter)
+
+	_, err := e.RunFrames(context.Background(), 1)
+	require.NoError(t, err)
+	require.Equal(t, uint8(0x42), e.PeekMemory(0xC000))
+}
+
+func TestSTOPWakesOnJoypadPress(t *testing.T) {
+	e := New()
+	e.Memory.Write8(0xC000, 0x10) // STOP
+	e.Memory.Write8(0xC001, 0x00)
+	e.Memory.Write8(0xC002, 0x3C) // INC A
+	e.Memory.Write8(0xC003, 0x3C) // INC A
+	e.CPU.ProgramCounter = 0xC000
+	e.CPU.Registers.Data[registerA] = 0
+	e.Memory.Write8(0xFF00, 0x20) // select arrows
+
+	_, err := e.Step()
+	require.NoError(t, err)
+	require.True(t, e.CPU.stopped)
+	pc := e.CPU.ProgramCounter
+
+	// Unlike HALT, pending interrupts do not wake the CPU
+	e.Memory.Write8(0xFFFF, 0x01) // IE: VBLANK
+	e.Memory.Write8(0xFF0F, 0x01) // IF: VBLANK
+	for i := 0; i < 100; i++ {
+		_, err := e.Step()
+		require.NoError(t, err)
+	}
+	require.True(t, e.CPU.PowerOn)
+	require.True(t, e.CPU.stopped)
+	require.Equal(t, pc, e.CPU.ProgramCounter)
+
+	// Pressing a button of a group that is not selected does not wake the CPU
+	e.PressButton(ButtonA)
+	_, err = e.Step()
+	require.NoError(t, err)
+	require.True(t, e.CPU.stopped)
+
+	e.PressButton(ButtonRight)
+	for e.CPU.ProgramCounter < 0xC004 {
+		_, err = e.Step()
+		require.NoError(t, err)
+	}
+	require.False(t, e.CPU.stopped)
+	require.Equal(t, uint8(2), e.CPU.Registers.Data[registerA])
+}
@@ -0,0 +1,88 @@
+package emulator
+
+import (
+	"fmt"
+	"strings"
+)
+
+var buttonNames = map[Button]string{
+	ButtonRight:  "Right",
+	ButtonLeft:   "Left",
+	ButtonUp:     "Up",
+	ButtonDown:   "Down",
+	ButtonA:      "A",
+	ButtonB:      "B",
+	ButtonSelect: "Select",
+	ButtonStart:  "Start",
+}
+
+func (b Button) String() string {
+	if name, ok := buttonNames[b]; ok {
+		return name
+	}
+	return fmt.Sprintf("Button(%d)", uint8(b))
+}
+
+// LookupButton returns the button with the given name (case insensitive, e.g.
+// "a" or "Start"), and false if no such button exists
+func LookupButton(name string) (Button, bool) {
+	for b, n := range buttonNames {
+		if strings.EqualFold(n, name) {
+			return b, true
+		}
+	}
+	return 0, false
+}
+
+// KeyMap maps keys, as identified by a frontend (e.g. the key names reported by
+// a windowing library), to joypad buttons
+//
+// The emulator does not depend on any particular frontend. Frontends translate
+// their key events into buttons using a KeyMap and pass them on to
+// PressButton/ReleaseButton.
+type KeyMap map[string]Button
+
+// Button returns the button mapped to key, and false if key is not mapped
+func (k KeyMap) Button(key string) (Button, bool) {
+	b, ok := k[key]
+	return b, ok
+}
+
+// Clone returns a copy of the key map, which can be modified without affecting
+// the original
+func (k KeyMap) Clone() KeyMap {
+	clone := make(KeyMap, len(k))
+	for key, b := range k {
+		clone[key] = b
+	}
+	return clone
+}
+
+// PressButton marks button b as held down
+//
+// Safe to call from a different goroutine than the one running the emulator.
+func (e *Emulator) PressButton(b Button) {
+	e.SetButton(b, true)
+}
+
+// ReleaseButton marks button b as released
+//
+// Safe to call from a different goroutine than the one running the emulator.
+func (e *Emulator) ReleaseButton(b Button) {
+	e.SetButton(b, false)
+}
+
+// MacroStep is a step in a macro, holding Buttons down for a number of Frames
+type MacroStep struct {
+	Buttons []Button
+	Frames  int
+}
+
+// DefineMacro plays sequence whenever button trigger is pressed, e.g. to
+// press A+B+Start+Select at once to soft-reset a game. The trigger itself is
+// not seen by the program while a macro is defined for it.
+//
+// Defining an empty sequence removes the macro for trigger.
+func (e *Emulator) DefineMacro(trigger Button, sequence []MacroStep) {
+	e.Joypad.DefineMacro(trigger, sequence)
+}
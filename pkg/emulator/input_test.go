@@ -0,0 +1,69 @@
+package emulator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyMapTranslatesKeysToButtons(t *testing.T) {
+	keyMap := KeyMap{
+		"up_arrow":    ButtonUp,
+		"down_arrow":  ButtonDown,
+		"left_arrow":  ButtonLeft,
+		"right_arrow": ButtonRight,
+		"z":           ButtonA,
+		"x":           ButtonB,
+		"return":      ButtonStart,
+		"left_shift":  ButtonSelect,
+		"right_shift": ButtonSelect,
+	}
+
+	tests := []struct {
+		key        string
+		wantButton Button
+		wantOK     bool
+	}{
+		{key: "up_arrow", wantButton: ButtonUp, wantOK: true},
+		{key: "right_arrow", wantButton: ButtonRight, wantOK: true},
+		{key: "z", wantButton: ButtonA, wantOK: true},
+		{key: "x", wantButton: ButtonB, wantOK: true},
+		{key: "return", wantButton: ButtonStart, wantOK: true},
+		{key: "right_shift", wantButton: ButtonSelect, wantOK: true},
+		{key: "escape", wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			b, ok := keyMap.Button(tt.key)
+			require.Equal(t, tt.wantOK, ok)
+			if tt.wantOK {
+				require.Equal(t, tt.wantButton, b, "got %s", b)
+			}
+		})
+	}
+}
+
+func TestPressAndReleaseButton(t *testing.T) {
+	e := New()
+	e.Memory.Write8(registerFF00, 0x10) // select buttons
+
+	e.PressButton(ButtonStart)
+	require.Equal(t, uint8(0xD7), e.Memory.Read8(registerFF00))
+
+	e.ReleaseButton(ButtonStart)
+	require.Equal(t, uint8(0xDF), e.Memory.Read8(registerFF00))
+}
+
+func TestLookupButton(t *testing.T) {
+	for b, name := range buttonNames {
+		for _, n := range []string{name, strings.ToLower(name), strings.ToUpper(name)} {
+			got, ok := LookupButton(n)
+			require.True(t, ok, n)
+			require.Equal(t, b, got)
+		}
+	}
+
+	_, ok := LookupButton("turbo")
+	require.False(t, ok)
+}
@@ -0,0 +1,81 @@
+package emulator
+
+import "fmt"
+
+// RegisterSnapshot contains the CPU registers at a point in time
+type RegisterSnapshot struct {
+	A, F, B, C, D, E, H, L uint8
+
+	SP uint16
+	PC uint16
+
+	// Flags (as stored in F)
+	FlagZ bool
+	FlagN bool
+	FlagH bool
+	FlagC bool
+}
+
+// RegisterState returns a snapshot of the CPU registers
+func (e *Emulator) RegisterState() RegisterSnapshot {
+	r := e.CPU.Registers
+	return RegisterSnapshot{
+		A:     r.Data[registerA],
+		F:     r.Data[0],
+		B:     r.Data[registerB],
+		C:     r.Data[registerC],
+		D:     r.Data[registerD],
+		E:     r.Data[registerE],
+		H:     r.Data[registerH],
+		L:     r.Data[registerL],
+		SP:    r.Read16(registerSP),
+		PC:    e.CPU.ProgramCounter,
+		FlagZ: r.Read1(flagZ),
+		FlagN: r.Read1(flagN),
+		FlagH: r.Read1(flagH),
+		FlagC: r.Read1(flagC),
+	}
+}
+
+// doctorTraceLine returns the state of the CPU, as of the start of the current
+// instruction, in the format used by Gameboy Doctor
+func (e *Emulator) doctorTraceLine() string {
+	r := e.RegisterState()
+	pc := e.CPU.instructionAddress
+	mem := e.PeekRange(pc, 4)
+
+	return fmt.Sprintf("A:%02X F:%02X B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X SP:%04X PC:%04X PCMEM:%02X,%02X,%02X,%02X\n",
+		r.A, r.F, r.B, r.C, r.D, r.E, r.H, r.L, r.SP, pc, mem[0], mem[1], mem[2], mem[3])
+}
+
+// PeekMemory returns the byte currently mapped at address
+//
+// Unlike reads by the program, peeking never fails: unmapped addresses read as
+// 0xFF. Peeking only reads memory, and so has no side effects on e.g. the
+// timer or the boot ROM mapping.
+func (e *Emulator) PeekMemory(address uint16) byte {
+	return e.Memory.Peek8(address)
+}
+
+// PeekRange returns length bytes starting at address (see PeekMemory)
+//
+// The range wraps around at the end of the address space.
+func (e *Emulator) PeekRange(start uint16, length uint16) []byte {
+	data := make([]byte, length)
+	for i := uint16(0); i < length; i++ {
+		data[i] = e.Memory.Peek8(start + i)
+	}
+	return data
+}
+
+// PPUMode returns the current mode of the PPU
+//
+// 0: HBLANK, 1: VBLANK, 2: Scanning OAM, 3: Writing pixels
+func (e *Emulator) PPUMode() uint8 {
+	return e.Video.CurrentMode()
+}
+
+// PPULine returns the scanline currently processed by the PPU (LY)
+func (e *Emulator) PPULine() uint8 {
+	return e.Video.CurrentLine()
+}
@@ -0,0 +1,136 @@
+package emulator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterStateMatchesExecutedInstructions(t *testing.T) {
+	e := New()
+
+	program := []byte{
+		0x3E, 0x10, // LD A,0x10
+		0x06, 0x01, // LD B,0x01
+		0x90,             // SUB B
+		0x21, 0x34, 0x12, // LD HL,0x1234
+		0x31, 0xF0, 0xDF, // LD SP,0xDFF0
+	}
+	for i, b := range program {
+		e.Memory.Write8(0xC000+uint16(i), b)
+	}
+	e.CPU.ProgramCounter = 0xC000
+	e.CPU.Registers.Data[0] = 0x00 // clear flags
+
+	for i := 0; i < 5; i++ {
+		_, err := e.Step()
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, RegisterSnapshot{
+		A:     0x0F,
+		F:     0x60, // N and H set
+		B:     0x01,
+		H:     0x12,
+		L:     0x34,
+		SP:    0xDFF0,
+		PC:    0xC00B,
+		FlagN: true,
+		FlagH: true,
+	}, e.RegisterState())
+}
+
+func TestPeekMemoryHasNoSideEffects(t *testing.T) {
+	e := New()
+	require.NoError(t, e.Memory.LoadROM("testdata/roms/whiteout.gb"))
+	require.NoError(t, e.Memory.LoadBootROM("testdata/roms/boot-whiteout.gb"))
+
+	e.Memory.Write8(0xC000, 0x12)
+	e.Memory.Write8(0xC001, 0x34)
+	e.Timer.Write8(uint16(registerFF04), 0x00)
+	for i := 0; i < 1000; i++ {
+		e.Timer.Cycle()
+	}
+	divider := e.Timer.Read8(uint16(registerFF04))
+
+	require.Equal(t, uint8(0x02), e.PeekMemory(0x0000), "expected boot ROM to be mapped")
+	require.Equal(t, []byte{0x12, 0x34}, e.PeekRange(0xC000, 2))
+	require.Equal(t, divider, e.PeekMemory(0xFF04))
+	require.Equal(t, uint8(0x12), e.PeekMemory(0xE000), "expected ECHO RAM to mirror WRAM")
+	require.Equal(t, uint8(0xFF), e.PeekMemory(0xFF03), "expected unused IO register to read 0xFF")
+	require.Len(t, e.PeekRange(0x0000, 0x100), 0x100)
+
+	require.True(t, e.Memory.IsBootROMLoaded)
+	require.Equal(t, divider, e.Timer.Read8(uint16(registerFF04)))
+}
+
+func TestPeekMemoryIgnoresPPULocking(t *testing.T) {
+	e := New()
+	e.Memory.Write8(0x8000, 0x12)
+	e.Memory.Write8(0xFE00, 0x34)
+
+	// Lock VRAM and OAM, as during mode 3 and an OAM DMA transfer
+	e.Video.vramAccessible = false
+	e.Video.oamAccessible = false
+	e.Video.dmaActive = true
+	require.Equal(t, uint8(0xFF), e.Memory.Read8(0x8000))
+	require.Equal(t, uint8(0xFF), e.Memory.Read8(0xFE00))
+
+	require.Equal(t, uint8(0x12), e.PeekMemory(0x8000))
+	require.Equal(t, uint8(0x34), e.PeekMemory(0xFE00))
+	require.Equal(t, uint8(0xFF), e.PeekMemory(0xFEA0), "expected unusable region to read 0xFF")
+}
+
+func TestPPUModeTransitionsAcrossLine(t *testing.T) {
+	e := New()
+	e.Memory.Write8(0xFF40, 0x80) // enable LCD
+
+	tests := []struct {
+		name     string
+		cycles   int
+		wantMode uint8
+		wantLine uint8
+	}{
+		{name: "scanning OAM", cycles: 1, wantMode: 2, wantLine: 0},
+		{name: "writing pixels", cycles: 80, wantMode: 3, wantLine: 0},
+		{name: "HBLANK", cycles: 168, wantMode: 0, wantLine: 0},
+		{name: "next line", cycles: 208, wantMode: 2, wantLine: 1},
+		{name: "VBLANK", cycles: 456 * 143, wantMode: 1, wantLine: 144},
+		{name: "last line", cycles: 456 * 9, wantMode: 1, wantLine: 153},
+		{name: "next frame", cycles: 456, wantMode: 2, wantLine: 0},
+	}
+
+	// Each case continues where the previous case left off
+	for _, tt := range tests {
+		for i := 0; i < tt.cycles; i++ {
+			e.Video.Cycle()
+		}
+		require.Equal(t, tt.wantMode, e.PPUMode(), tt.name)
+		require.Equal(t, tt.wantLine, e.PPULine(), tt.name)
+	}
+}
+
+func TestDoctorTraceWritesStateBeforeEachInstruction(t *testing.T) {
+	trace := strings.Builder{}
+	e := New(WithDoctorTrace(&trace))
+
+	program := []byte{
+		0x3E, 0x42, // LD A,$42
+		0x06, 0x13, // LD B,$13
+	}
+	for i, b := range program {
+		e.Memory.Write8(0xC000+uint16(i), b)
+	}
+	e.CPU.ProgramCounter = 0xC000
+	e.CPU.Registers.Write16(registerAF, 0x01B0)
+	e.CPU.Registers.Write16(registerSP, 0xFFFE)
+
+	e.CPU.Cycle()
+	e.CPU.Cycle()
+
+	require.Equal(t, ""+
+		"A:01 F:B0 B:00 C:00 D:00 E:00 H:00 L:00 SP:FFFE PC:C000 PCMEM:3E,42,06,13\n"+
+		"A:42 F:B0 B:00 C:00 D:00 E:00 H:00 L:00 SP:FFFE PC:C002 PCMEM:06,13,00,00\n",
+		trace.String())
+}
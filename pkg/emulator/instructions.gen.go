@@ -360,7 +360,7 @@ var instructions = []instruction{
 	{
 		Opcode:   "0x10",
 		Mnemonic: "STOP",
-		Size:     1,
+		Size:     2,
 		Cycles:   []int{1},
 		Operands: []operand{},
 		Flags: flags{
@@ -45,6 +45,9 @@ type interruptController struct {
 	interruptEnabled byte
 
 	interruptSources []*interruptSource
+
+	// memory reports accesses to addresses not mapped to a register
+	memory *memory
 }
 
 func newInterruptController() *interruptController {
@@ -62,8 +65,7 @@ func (i *interruptController) Read8(address uint16) byte {
 		return i.interruptEnabled
 	}
 
-	notImplemented("read of unimplemented INTERRUPT register at %#4x", address)
-	return byte(0)
+	return i.memory.unmapped(address, false)
 }
 
 // Write8 is exposed in the address space, and may be written to by the program
@@ -74,7 +76,7 @@ func (i *interruptController) Write8(address uint16, v byte) {
 	case 0xFFFF:
 		i.interruptEnabled = v
 	default:
-		notImplemented("write of unimplemented INTERRUPT register at %#4x", address)
+		i.memory.unmapped(address, true)
 	}
 }
 
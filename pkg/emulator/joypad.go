@@ -1,5 +1,10 @@
 package emulator
 
+import (
+	"sync"
+	"sync/atomic"
+)
+
 const (
 	// Joypad select & state (read/write)
 	//
@@ -12,8 +17,60 @@ const (
 	// Bit 1 - P11 Input Left  or Button B (0=Pressed) (Read Only)
 	// Bit 0 - P10 Input Right or Button A (0=Pressed) (Read Only)
 	registerFF00 uint16 = 0xFF00
+
+	// joypadUnusedBits are the unused bits of 0xFF00, which always read as 1
+	joypadUnusedBits byte = 0xC0
+)
+
+// Button is a button on the joypad
+//
+// The lower 4 buttons are the arrows, and the upper 4 buttons are the
+// remaining buttons, ordered by their bit in 0xFF00.
+type Button uint8
+
+const (
+	ButtonRight Button = iota
+	ButtonLeft
+	ButtonUp
+	ButtonDown
+	ButtonA
+	ButtonB
+	ButtonSelect
+	ButtonStart
 )
 
+// oppositeButtons contains the opposite direction of every arrow, which can't
+// be pressed at the same time on a physical joypad
+var oppositeButtons = map[Button]Button{
+	ButtonRight: ButtonLeft,
+	ButtonLeft:  ButtonRight,
+	ButtonUp:    ButtonDown,
+	ButtonDown:  ButtonUp,
+}
+
+// framesPerSecond is the (approximate) number of frames rendered per second
+const framesPerSecond = 60
+
+// turbo auto-repeats a held button
+type turbo struct {
+	// hz is the number of presses per second
+	hz float64
+
+	// phase is the progress through the current press/release cycle (0-1). The
+	// button is pressed during the first half of the cycle.
+	phase float64
+}
+
+// macro is a sequence of button presses played when its trigger is pressed
+type macro struct {
+	sequence []MacroStep
+
+	// step is the index of the current step in sequence, and frames is the
+	// number of frames the current step has been held for
+	step   int
+	frames int
+}
+
 // joypadController handles joypad state and interrupts
 type joypadController struct {
 	// Bit 3 - Down
@@ -30,13 +87,45 @@ type joypadController struct {
 
 	register byte
 
+	// held contains the buttons currently held down, with one bit per Button
+	held byte
+
+	// suppressed contains held arrows that are overridden by a more recently
+	// pressed opposite arrow (e.g. left and right held at the same time)
+	suppressed byte
+
+	// lines contains the state of the input lines (lower 4 bits of 0xFF00) for
+	// the currently selected group(s), where 0 = pressed
+	lines byte
+
+	// turbos contains the buttons that auto-repeat while held
+	turbos map[Button]*turbo
+
+	// macros contains the macros by trigger button, and activeMacro is the
+	// macro currently being played (if any)
+	macros      map[Button][]MacroStep
+	activeMacro *macro
+
+	// mutex guards the input state, as input is usually provided from a
+	// different goroutine than the one running the emulator
+	mutex sync.Mutex
+
+	// interruptRequested is set (to 1) when a button is pressed, and is
+	// forwarded to Interrupt by Cycle on the goroutine running the emulator
+	interruptRequested int32
+
 	// Interrupt is true if the joypad wants to trigger the INT 60 interrupt
-	// TODO: trigger interrupts when we accept input
 	Interrupt *interruptSource
+
+	// memory reports accesses to addresses not mapped to a register
+	memory *memory
 }
 
 func newJoypadController() *joypadController {
 	return &joypadController{
+		turbos:    map[Button]*turbo{},
+		macros:    map[Button][]MacroStep{},
+		lines:     0x0F,
 		Interrupt: newInterruptSource(),
 	}
 }
@@ -45,32 +134,165 @@ func newJoypadController() *joypadController {
 func (j *joypadController) Read8(address uint16) byte {
 	switch address {
 	case 0xFF00:
-		buttonSelected := readBitN(j.register, 5)
-		arrowSelected := readBitN(j.register, 4)
+		j.mutex.Lock()
+		defer j.mutex.Unlock()
 
-		out := j.register
-		if buttonSelected {
-			out = out | j.inputButton
-		}
-		if arrowSelected {
-			out = out | j.inputArrows
-		}
-
-		return out
+		return joypadUnusedBits | j.register | j.lines
 	}
 
-	notImplemented("read of unimplemented JOYPAD register at %#4x", address)
-	return byte(0)
+	return j.memory.unmapped(address, false)
 }
 
 // Write8 is exposed in the address space, and may be written to by the program
 func (j *joypadController) Write8(address uint16, v byte) {
 	switch address {
 	case 0xFF00:
+		j.mutex.Lock()
+		defer j.mutex.Unlock()
+
 		j.register = v & 0xF0 // lower 4 bits are readonly
+		j.updateLines()
 	default:
-		notImplemented("write of unimplemented JOYPAD register at %#4x", address)
+		j.memory.unmapped(address, true)
+	}
+}
+
+// SetButton marks a button as held down (pressed=true) or released
+func (j *joypadController) SetButton(button Button, pressed bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if t, ok := j.turbos[button]; ok && pressed && !readBitN(j.held, uint8(button)) {
+		t.phase = 0 // start turbo cycle with a press
+	}
+
+	if sequence, ok := j.macros[button]; ok && pressed && !readBitN(j.held, uint8(button)) {
+		j.activeMacro = &macro{sequence: sequence}
+	}
+
+	if opposite, ok := oppositeButtons[button]; ok {
+		// The most recently pressed of two opposite arrows wins, and the other
+		// arrow is restored when it is released
+		j.suppressed = writeBitN(j.suppressed, uint8(opposite), pressed)
+		j.suppressed = writeBitN(j.suppressed, uint8(button), false)
+	}
+
+	j.held = writeBitN(j.held, uint8(button), pressed)
+	j.updateInput()
+}
+
+// SetTurbo makes a held button auto-repeat with hz presses per second
+//
+// Setting hz to 0 disables turbo for the button.
+func (j *joypadController) SetTurbo(button Button, hz float64) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if hz <= 0 {
+		delete(j.turbos, button)
+	} else {
+		j.turbos[button] = &turbo{hz: hz}
+	}
+	j.updateInput()
+}
+
+// DefineMacro plays sequence whenever trigger is pressed, replacing any
+// existing macro for trigger. The trigger itself is not seen by the program.
+//
+// Defining an empty sequence removes the macro.
+func (j *joypadController) DefineMacro(trigger Button, sequence []MacroStep) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if len(sequence) == 0 {
+		delete(j.macros, trigger)
+	} else {
+		j.macros[trigger] = append([]MacroStep(nil), sequence...)
+	}
+	j.updateInput()
+}
+
+// Cycle requests the joypad interrupt if a button was pressed since the last
+// cycle
+func (j *joypadController) Cycle() {
+	if atomic.CompareAndSwapInt32(&j.interruptRequested, 1, 0) {
+		j.Interrupt.Set()
+	}
+}
+
+// NextFrame progresses per-frame input state (e.g. turbo buttons), and must be
+// called once for every rendered frame
+func (j *joypadController) NextFrame() {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	for button, t := range j.turbos {
+		if readBitN(j.held, uint8(button)) {
+			t.phase += t.hz / framesPerSecond
+			t.phase -= float64(int(t.phase))
+		}
+	}
+
+	if m := j.activeMacro; m != nil {
+		m.frames++
+		if m.frames >= m.sequence[m.step].Frames {
+			m.step++
+			m.frames = 0
+		}
+		if m.step >= len(m.sequence) {
+			j.activeMacro = nil
+		}
+	}
+
+	j.updateInput()
+}
+
+// updateInput recalculates the pressed buttons from the held buttons, the
+// state of turbo buttons, and the active macro
+func (j *joypadController) updateInput() {
+	pressed := j.held &^ j.suppressed
+	for button, t := range j.turbos {
+		if t.phase >= 0.5 {
+			pressed = writeBitN(pressed, uint8(button), false)
+		}
+	}
+	for trigger := range j.macros {
+		pressed = writeBitN(pressed, uint8(trigger), false)
+	}
+	if m := j.activeMacro; m != nil {
+		for _, button := range m.sequence[m.step].Buttons {
+			pressed = writeBitN(pressed, uint8(button), true)
+		}
+	}
+
+	j.inputArrows = pressed & 0x0F
+	j.inputButton = pressed >> 4
+	j.updateLines()
+}
+
+// updateLines recalculates the input lines from the pressed buttons of the
+// selected group(s), and requests an interrupt if any line transitioned from
+// high to low (released to pressed)
+//
+// Only the selected group(s) are connected to the input lines, so pressing a
+// button in a group that is not selected does not trigger an interrupt.
+func (j *joypadController) updateLines() {
+	arrowSelected := !readBitN(j.register, 4)
+	buttonSelected := !readBitN(j.register, 5)
+
+	// Pressed buttons in the selected group(s) read as 0
+	lines := byte(0x0F)
+	if arrowSelected {
+		lines = lines &^ j.inputArrows
+	}
+	if buttonSelected {
+		lines = lines &^ j.inputButton
+	}
+
+	if j.lines&^lines != 0 {
+		atomic.StoreInt32(&j.interruptRequested, 1)
 	}
+	j.lines = lines
 }
 
 func (j *joypadController) String() string {
@@ -0,0 +1,201 @@
+package emulator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJoypadReadsPressedButtonsOfSelectedGroup(t *testing.T) {
+	joypad := newJoypadController()
+	joypad.SetButton(ButtonA, true)
+	joypad.SetButton(ButtonDown, true)
+
+	joypad.Write8(registerFF00, 0x10) // select buttons
+	require.Equal(t, uint8(0xDE), joypad.Read8(registerFF00))
+
+	joypad.Write8(registerFF00, 0x20) // select arrows
+	require.Equal(t, uint8(0xE7), joypad.Read8(registerFF00))
+
+	joypad.Write8(registerFF00, 0x30) // select neither
+	require.Equal(t, uint8(0xFF), joypad.Read8(registerFF00))
+}
+
+func TestJoypadTurboButtonTogglesAtConfiguredRate(t *testing.T) {
+	joypad := newJoypadController()
+	joypad.Write8(registerFF00, 0x10) // select buttons
+
+	joypad.SetTurbo(ButtonA, 30)
+	joypad.SetButton(ButtonA, true)
+
+	isPressed := func() bool {
+		return !readBitN(joypad.Read8(registerFF00), 0)
+	}
+
+	// At 30 Hz and 60 frames per second, the button is pressed every other frame
+	require.True(t, isPressed(), "expected turbo button to start pressed")
+
+	presses := 0
+	for frame := 1; frame <= framesPerSecond; frame++ {
+		wasPressed := isPressed()
+		joypad.NextFrame()
+		require.Equal(t, !wasPressed, isPressed(), "expected button to toggle at frame %d", frame)
+
+		if !wasPressed && isPressed() {
+			presses++
+		}
+	}
+	require.Equal(t, 30, presses)
+
+	// Releasing the button stops the auto-repeat
+	joypad.SetButton(ButtonA, false)
+	joypad.NextFrame()
+	require.False(t, isPressed())
+	joypad.NextFrame()
+	require.False(t, isPressed())
+}
+
+func TestJoypadTurboDisabledKeepsButtonHeld(t *testing.T) {
+	joypad := newJoypadController()
+	joypad.Write8(registerFF00, 0x10) // select buttons
+
+	joypad.SetTurbo(ButtonB, 30)
+	joypad.SetTurbo(ButtonB, 0)
+	joypad.SetButton(ButtonB, true)
+
+	for frame := 0; frame < 10; frame++ {
+		joypad.NextFrame()
+		require.False(t, readBitN(joypad.Read8(registerFF00), 1))
+	}
+}
+
+func TestJoypadPressRequestsInterrupt(t *testing.T) {
+	joypad := newJoypadController()
+	joypad.Write8(registerFF00, 0x00) // select arrows and buttons
+
+	joypad.SetButton(ButtonStart, true)
+	joypad.Cycle()
+	require.True(t, joypad.Interrupt.ReadAndClear(), "expected interrupt when button is pressed")
+
+	joypad.SetButton(ButtonStart, true)
+	joypad.Cycle()
+	require.False(t, joypad.Interrupt.ReadAndClear(), "expected no interrupt when button is already pressed")
+
+	joypad.SetButton(ButtonStart, false)
+	joypad.Cycle()
+	require.False(t, joypad.Interrupt.ReadAndClear(), "expected no interrupt when button is released")
+
+	joypad.SetButton(ButtonLeft, true)
+	joypad.Cycle()
+	require.True(t, joypad.Interrupt.ReadAndClear())
+}
+
+func TestJoypadOppositeArrowsReportMostRecentlyPressed(t *testing.T) {
+	joypad := newJoypadController()
+	joypad.Write8(registerFF00, 0x20) // select arrows
+
+	joypad.SetButton(ButtonRight, true)
+	joypad.SetButton(ButtonLeft, true)
+	require.Equal(t, uint8(0xED), joypad.Read8(registerFF00), "expected only left to be pressed")
+
+	joypad.SetButton(ButtonLeft, false)
+	require.Equal(t, uint8(0xEE), joypad.Read8(registerFF00), "expected right to be pressed again")
+
+	joypad.SetButton(ButtonRight, false)
+	require.Equal(t, uint8(0xEF), joypad.Read8(registerFF00))
+}
+
+func TestJoypadInterruptOnlyForSelectedGroup(t *testing.T) {
+	tests := []struct {
+		name          string
+		selection     byte
+		button        Button
+		wantInterrupt bool
+	}{
+		{name: "button pressed with buttons selected", selection: 0x10, button: ButtonA, wantInterrupt: true},
+		{name: "button pressed with arrows selected", selection: 0x20, button: ButtonA, wantInterrupt: false},
+		{name: "arrow pressed with arrows selected", selection: 0x20, button: ButtonDown, wantInterrupt: true},
+		{name: "arrow pressed with buttons selected", selection: 0x10, button: ButtonDown, wantInterrupt: false},
+		{name: "arrow pressed with nothing selected", selection: 0x30, button: ButtonDown, wantInterrupt: false},
+		{name: "arrow pressed with both selected", selection: 0x00, button: ButtonDown, wantInterrupt: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			joypad := newJoypadController()
+			joypad.Write8(registerFF00, tt.selection)
+
+			joypad.SetButton(tt.button, true)
+			joypad.Cycle()
+			require.Equal(t, tt.wantInterrupt, joypad.Interrupt.ReadAndClear())
+		})
+	}
+}
+
+func TestJoypadInterruptWhenSelectingGroupWithPressedButton(t *testing.T) {
+	joypad := newJoypadController()
+	joypad.Write8(registerFF00, 0x20) // select arrows
+
+	joypad.SetButton(ButtonB, true)
+	joypad.Cycle()
+	require.False(t, joypad.Interrupt.ReadAndClear())
+
+	// Selecting the buttons pulls the B line low
+	joypad.Write8(registerFF00, 0x10)
+	joypad.Cycle()
+	require.True(t, joypad.Interrupt.ReadAndClear())
+
+	// Releasing the button pulls the line high, which does not interrupt
+	joypad.SetButton(ButtonB, false)
+	joypad.Cycle()
+	require.False(t, joypad.Interrupt.ReadAndClear())
+}
+
+func TestJoypadMacroPlaysSequenceOverFrames(t *testing.T) {
+	joypad := newJoypadController()
+	joypad.Write8(registerFF00, 0x10) // select buttons
+
+	joypad.DefineMacro(ButtonSelect, []MacroStep{
+		{Buttons: []Button{ButtonA, ButtonB}, Frames: 2},
+		{Buttons: []Button{ButtonStart}, Frames: 1},
+	})
+
+	// Bit 3 - Start, Bit 2 - Select, Bit 1 - B, Bit 0 - A (0=Pressed)
+	require.Equal(t, uint8(0xDF), joypad.Read8(registerFF00), "expected no input before trigger")
+
+	joypad.SetButton(ButtonSelect, true)
+	require.Equal(t, uint8(0xDC), joypad.Read8(registerFF00), "expected A+B at frame 0")
+	joypad.NextFrame()
+	require.Equal(t, uint8(0xDC), joypad.Read8(registerFF00), "expected A+B at frame 1")
+	joypad.NextFrame()
+	require.Equal(t, uint8(0xD7), joypad.Read8(registerFF00), "expected Start at frame 2")
+	joypad.NextFrame()
+	require.Equal(t, uint8(0xDF), joypad.Read8(registerFF00), "expected macro to end at frame 3")
+
+	// Holding the trigger does not replay the macro, but pressing it again does
+	joypad.NextFrame()
+	require.Equal(t, uint8(0xDF), joypad.Read8(registerFF00))
+	joypad.SetButton(ButtonSelect, false)
+	joypad.SetButton(ButtonSelect, true)
+	require.Equal(t, uint8(0xDC), joypad.Read8(registerFF00))
+}
+
+func TestJoypadRemovingMacroRestoresTrigger(t *testing.T) {
+	joypad := newJoypadController()
+	joypad.Write8(registerFF00, 0x10) // select buttons
+
+	joypad.DefineMacro(ButtonSelect, []MacroStep{{Buttons: []Button{ButtonA}, Frames: 1}})
+	joypad.DefineMacro(ButtonSelect, nil)
+
+	joypad.SetButton(ButtonSelect, true)
+	require.Equal(t, uint8(0xDB), joypad.Read8(registerFF00))
+}
+
+func TestJoypadUnusedBitsReadAsOne(t *testing.T) {
+	joypad := newJoypadController()
+
+	joypad.Write8(registerFF00, 0x00)
+	require.Equal(t, uint8(0xC0), joypad.Read8(registerFF00)&0xC0)
+
+	joypad.Write8(registerFF00, 0x30)
+	require.Equal(t, uint8(0xFF), joypad.Read8(registerFF00))
+}
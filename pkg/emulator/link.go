@@ -0,0 +1,73 @@
+package emulator
+
+import "sync"
+
+// SerialLink connects the serial port to an external device, e.g. the serial
+// port of another emulator (see NewSerialLinkPipe)
+type SerialLink interface {
+	// Exchange is called when the local device, driving the clock, starts a
+	// transfer. Sends out to the external device, and returns the byte received
+	// from it in return.
+	Exchange(out byte) (in byte)
+}
+
+// SerialBitLink is optionally implemented by a SerialLink to exchange bits as
+// they are shifted, rather than a byte at a time, such that the external device
+// observes the shifting
+type SerialBitLink interface {
+	SerialLink
+
+	// ExchangeBit is called on every serial clock driven by the local device.
+	// Sends out to the external device, and returns the bit received from it.
+	ExchangeBit(out bool) (in bool)
+}
+
+// serialPipe is one end of an in-process link cable between two emulators
+type serialPipe struct {
+	peer *serialPipe
+
+	// mutex is shared by both ends, and guards the serial ports plugged in
+	mutex *sync.Mutex
+
+	// serial is the serial port of the emulator this end is plugged into, or
+	// nil if not plugged in
+	serial *serialController
+}
+
+// NewSerialLinkPipe returns the two ends of an in-process link cable, which
+// connect two emulators when passed to WithSerialLink
+//
+// The emulators may be run on different goroutines (e.g. using Continue), as
+// both serial ports are guarded by a mutex shared by the link.
+func NewSerialLinkPipe() (SerialLink, SerialLink) {
+	mutex := &sync.Mutex{}
+	a := &serialPipe{mutex: mutex}
+	b := &serialPipe{peer: a, mutex: mutex}
+	a.peer = b
+
+	return a, b
+}
+
+// Exchange exchanges a byte a bit at a time, see ExchangeBit
+func (p *serialPipe) Exchange(out byte) byte {
+	var in byte
+	for i := 7; i >= 0; i-- {
+		in <<= 1
+		if p.ExchangeBit(readBitN(out, uint8(i))) {
+			in |= 0x01
+		}
+	}
+
+	return in
+}
+
+// ExchangeBit shifts a bit in to the serial port at the other end. Must be
+// called with the mutex of the link held, as done by the serial port driving
+// the clock.
+func (p *serialPipe) ExchangeBit(out bool) bool {
+	if p.peer.serial == nil {
+		return true // other end is not plugged in
+	}
+
+	return p.peer.serial.externalShift(out)
+}
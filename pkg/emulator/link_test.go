@@ -0,0 +1,104 @@
+package emulator
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSerialLinkPipeExchangesBytesBetweenEmulators(t *testing.T) {
+	linkA, linkB := NewSerialLinkPipe()
+	a := New(WithSerialLink(linkA))
+	b := New(WithSerialLink(linkB))
+
+	tests := []struct {
+		name   string
+		master *Emulator
+		slave  *Emulator
+	}{
+		{name: "A drives the clock", master: a, slave: b},
+		{name: "B drives the clock", master: b, slave: a},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.slave.Memory.Write8(0xFF01, 0x24)
+			tt.slave.Memory.Write8(0xFF02, 0x80) // start transfer, external clock
+			tt.master.Memory.Write8(0xFF01, 0x42)
+			tt.master.Memory.Write8(0xFF02, 0x81) // start transfer, internal clock
+
+			for i := 0; i < 1024; i++ {
+				tt.master.Serial.Cycle()
+				tt.slave.Serial.Cycle()
+			}
+
+			require.Equal(t, uint8(0x24), tt.master.Memory.Read8(0xFF01))
+			require.Equal(t, uint8(0x42), tt.slave.Memory.Read8(0xFF01))
+			require.Equal(t, uint8(0x01), tt.master.Memory.Read8(0xFF02))
+			require.Equal(t, uint8(0x00), tt.slave.Memory.Read8(0xFF02))
+			require.True(t, tt.master.Serial.Interrupt.ReadAndClear())
+			require.True(t, tt.slave.Serial.Interrupt.ReadAndClear())
+		})
+	}
+}
+
+func TestSerialLinkPipeIgnoresPeerNotWaitingForTransfer(t *testing.T) {
+	linkA, linkB := NewSerialLinkPipe()
+	a := New(WithSerialLink(linkA))
+	b := New(WithSerialLink(linkB))
+
+	b.Memory.Write8(0xFF01, 0x24) // transfer not started
+	a.Memory.Write8(0xFF01, 0x42)
+	a.Memory.Write8(0xFF02, 0x81)
+
+	for i := 0; i < 1024; i++ {
+		a.Serial.Cycle()
+	}
+
+	require.Equal(t, uint8(0xFF), a.Memory.Read8(0xFF01))
+	require.Equal(t, uint8(0x24), b.Memory.Read8(0xFF01))
+	require.False(t, b.Serial.Interrupt.ReadAndClear())
+}
+
+func TestSerialLinkPipeExchangesBytesBetweenRunningEmulators(t *testing.T) {
+	linkA, linkB := NewSerialLinkPipe()
+
+	sent := make(chan uint8, 2)
+	callback := func(data uint8) {
+		sent <- data
+	}
+	master := New(WithSpeedUncapped(), WithSerialLink(linkA), WithSerialDataCallback(callback))
+	slave := New(WithSpeedUncapped(), WithSerialLink(linkB), WithSerialDataCallback(callback))
+
+	for _, e := range []*Emulator{master, slave} {
+		e.Memory.Write8(0xC000, 0x18) // JR -2
+		e.Memory.Write8(0xC001, 0xFE)
+		e.CPU.ProgramCounter = 0xC000
+	}
+	slave.Memory.Write8(0xFF01, 0x24)
+	slave.Memory.Write8(0xFF02, 0x80) // start transfer, external clock
+	master.Memory.Write8(0xFF01, 0x42)
+	master.Memory.Write8(0xFF02, 0x81) // start transfer, internal clock
+
+	// Both emulators run on their own goroutine, as a front-end would
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, e := range []*Emulator{master, slave} {
+		wg.Add(1)
+		go func(e *Emulator) {
+			defer wg.Done()
+			require.NoError(t, e.Continue(ctx))
+		}(e)
+	}
+
+	require.ElementsMatch(t, []uint8{0x42, 0x24}, []uint8{<-sent, <-sent})
+	cancel()
+	wg.Wait()
+
+	require.Equal(t, uint8(0x24), master.Memory.Read8(0xFF01))
+	require.Equal(t, uint8(0x42), slave.Memory.Read8(0xFF01))
+	require.Equal(t, uint8(0x01), master.Memory.Read8(0xFF02))
+	require.Equal(t, uint8(0x00), slave.Memory.Read8(0xFF02))
+}
@@ -33,7 +33,8 @@ func bcdConversion(v uint8, wasSubtraction bool, halfcarry bool, carry bool) (vO
 	vOut = v
 
 	if wasSubtraction {
-		// Previous instruction was a subtraction
+		// Previous instruction was a subtraction. Only the carry flags tell if
+		// a digit underflowed, and the carry is retained as is.
 		if carry {
 			vOut = vOut - 0x60 // adjust underflow to skip from 0xF- 0x9-
 		}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"sync"
 )
 
 const (
@@ -21,11 +22,15 @@ type memoryPage interface {
 
 type bootROM struct {
 	data []byte
+
+	// rom is the cartridge mapped underneath the Boot ROM
+	rom *rom
 }
 
-func newBootROM() *bootROM {
+func newBootROM(rom *rom) *bootROM {
 	return &bootROM{
 		data: make([]byte, 256),
+		rom:  rom,
 	}
 }
 
@@ -34,10 +39,10 @@ func (b *bootROM) Read8(address uint16) byte {
 	return b.data[address]
 }
 
+// Write8 forwards writes to the MBC of the cartridge, as the Boot ROM itself is
+// read-only
 func (b *bootROM) Write8(address uint16, v byte) {
-	// BootROM is read-only
-	// TODO: decide proper semantics when/if writes like these occur
-	notImplemented("writes to MBC not implemented")
+	b.rom.Write8(address, v)
 }
 
 func (b *bootROM) LoadBootROM(path string) error {
@@ -46,11 +51,19 @@ func (b *bootROM) LoadBootROM(path string) error {
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
 		return err
-	} else if len(data) != 256 {
+	}
+
+	return b.LoadBootROMBytes(data)
+}
+
+// LoadBootROMBytes loads the Boot ROM from a copy of data, which must be
+// exactly 256 bytes
+func (b *bootROM) LoadBootROMBytes(data []byte) error {
+	if len(data) != 256 {
 		return fmt.Errorf("invalid ROM size: expected Boot ROM to contain %d bytes but contained %d bytes", 256, len(data))
 	}
 
-	b.data = data
+	b.data = append([]byte(nil), data...)
 
 	log.Printf("Loaded %d bytes from Boot ROM", len(data))
 	return nil
@@ -60,6 +73,49 @@ func (b *bootROM) String() string {
 	return "Boot ROM"
 }
 
+// MemoryError describes an access to an address that is not mapped to any
+// memory or I/O register
+type MemoryError struct {
+	Address uint16
+	Write   bool
+}
+
+func (e *MemoryError) Error() string {
+	if e.Write {
+		return fmt.Sprintf("write to unmapped address %#04x", e.Address)
+	}
+	return fmt.Sprintf("read from unmapped address %#04x", e.Address)
+}
+
+// bootControlRegister is the register at 0xFF50 used to unmap the Boot ROM
+//
+// Writing a value with bit 0 set unmaps the Boot ROM, which can not be mapped
+// again afterwards. Reads report bit 0 as set once the Boot ROM is unmapped.
+type bootControlRegister struct {
+	memory *memory
+}
+
+func newBootControlRegister() *bootControlRegister {
+	return &bootControlRegister{}
+}
+
+func (b *bootControlRegister) Read8(address uint16) byte {
+	if b.memory.IsBootROMLoaded {
+		return 0xFE
+	}
+	return 0xFF
+}
+
+func (b *bootControlRegister) Write8(address uint16, v byte) {
+	if v&0x01 == 0x01 && b.memory.IsBootROMLoaded {
+		b.memory.UnloadBootROM()
+	}
+}
+
+func (b *bootControlRegister) String() string {
+	return "BOOT"
+}
+
 type ram struct {
 	data   []byte
 	offset uint16
@@ -86,20 +142,71 @@ func (r *ram) String() string {
 	return r.name
 }
 
-//https://gbdev.io/pandocs/#ff26-nr52-sound-on-off
+// echoRAM mirrors a WRAM bank at an offset of 0x2000 (0xE000-0xFDFF mirrors 0xC000-0xDDFF)
+type echoRAM struct {
+	wRAM memoryPage
+}
+
+func newEchoRAM(wRAM memoryPage) *echoRAM {
+	return &echoRAM{
+		wRAM: wRAM,
+	}
+}
+
+func (e *echoRAM) Read8(address uint16) byte {
+	return e.wRAM.Read8(address - 0x2000)
+}
+
+func (e *echoRAM) Write8(address uint16, v byte) {
+	e.wRAM.Write8(address-0x2000, v)
+}
+
+func (e *echoRAM) String() string {
+	return fmt.Sprintf("ECHO %s", e.wRAM)
+}
+
+// cgbRegister is an IO register only present on the CGB (e.g. KEY1), which
+// reads as 0xFF and ignores writes when running in DMG mode
+//
+// For the bank select registers (VBK and SVBK) this means VRAM and WRAM
+// remain fixed to the only banks present on the DMG.
+type cgbRegister struct {
+	name string
+}
+
+func newCGBRegister(name string) *cgbRegister {
+	return &cgbRegister{
+		name: name,
+	}
+}
+
+func (c *cgbRegister) Read8(address uint16) byte {
+	return 0xFF
+}
+
+func (c *cgbRegister) Write8(address uint16, v byte) {
+	// do nothing - not supported in DMG mode
+}
+
+func (c *cgbRegister) String() string {
+	return c.name
+}
+
+// https://gbdev.io/pandocs/#ff26-nr52-sound-on-off
 // ffPage represents the last page in the address space (0xFF00-0xFFFF), contiaining various IO registers and HRAM
 //
 // The page dispatches to other more specialized memoryPages based on the accessed address. See `memory` for
 // a high-level overview of the structure of 0xFFXX or `newFFPage` for details.
 type ffPage struct {
 	entries []memoryPage
+	memory  *memory
 
 	timer *timerController
+	hram  *ram
 }
 
-func newFFPage(video *videoController, timer *timerController, interrupt *interruptController, serial *serialController, joypad *joypadController) *ffPage {
+func newFFPage(video *videoController, timer *timerController, interrupt *interruptController, serial *serialController, joypad *joypadController, dma *dmaController, sound *soundController, bootControl *bootControlRegister) *ffPage {
 	hram := newRAM("HRAM", 0xFE-0x7F, 0xFF80)
-	sound := newSoundController()
 
 	layout := []struct {
 		Controller memoryPage
@@ -112,7 +219,16 @@ func newFFPage(video *videoController, timer *timerController, interrupt *interr
 		{End: 0x0E, Controller: nil}, // UNUSED
 		{End: 0x0F, Controller: interrupt},
 		{End: 0x3F, Controller: sound},
+		{End: 0x45, Controller: video},
+		{End: 0x46, Controller: dma},
 		{End: 0x4B, Controller: video},
+		{End: 0x4C, Controller: nil}, // UNUSED
+		{End: 0x4D, Controller: newCGBRegister("KEY1")},
+		{End: 0x4E, Controller: nil}, // UNUSED
+		{End: 0x4F, Controller: newCGBRegister("VBK")},
+		{End: 0x50, Controller: bootControl},
+		{End: 0x6F, Controller: nil}, // UNUSED
+		{End: 0x70, Controller: newCGBRegister("SVBK")},
 		{End: 0x7F, Controller: nil}, // UNUSED
 		{End: 0xFE, Controller: hram},
 		{End: 0xFF, Controller: interrupt},
@@ -130,13 +246,14 @@ func newFFPage(video *videoController, timer *timerController, interrupt *interr
 	return &ffPage{
 		entries: entries,
 		timer:   timer,
+		hram:    hram,
 	}
 }
 
 func (f *ffPage) Read8(address uint16) byte {
 	entry := f.entries[address-0xFF00]
 	if entry == nil {
-		notImplemented("memory operations at address %#04x not implemented", address)
+		return f.memory.unmapped(address, false)
 	}
 
 	return entry.Read8(address)
@@ -145,7 +262,7 @@ func (f *ffPage) Read8(address uint16) byte {
 func (f *ffPage) Write8(address uint16, v byte) {
 	entry := f.entries[address-0xFF00]
 	if entry == nil {
-		log.Printf("WARNING: write to unused memory space %#04x", address)
+		f.memory.unmapped(address, true)
 		return
 	}
 
@@ -162,7 +279,7 @@ type memory struct {
 	// See https://gbdev.io/pandocs/#memory-map for details on the layout.
 	//
 	// The memory is split into pages (256 pages, higher-order byte), and
-	// each page has 265 entries (lower order byte).
+	// each page has 256 entries (lower order byte).
 	//
 	// 00-3F  16KB ROM bank 00
 	// 40-7F  16KB ROM bank 01~NN (switchable via MB)
@@ -180,16 +297,40 @@ type memory struct {
 	rom     *rom
 	bootROM *bootROM
 	video   *videoController
+	dma     *dmaController
+	sound   *soundController
+	ffPage  *ffPage
+	wRAM0   *ram
+	wRAM1   *ram
 
 	// IsBootROMLoaded is true if the Boot ROM is currently loaded
 	IsBootROMLoaded bool
+
+	// strict causes accesses to unmapped addresses to panic, rather than
+	// returning 0xFF or ignoring writes. See WithStrictMemory.
+	strict bool
+
+	// errMutex guards err and loggedErrors, as Err may be called from a
+	// different goroutine than the one running the emulator
+	errMutex sync.Mutex
+	// err is the most recent access to an unmapped address
+	err *MemoryError
+	// loggedErrors contains the unmapped addresses written to so far, such
+	// that each is only logged once
+	loggedErrors map[uint16]bool
+
+	// ioOverrides forces the value read from individual I/O registers. Only
+	// used by tests, see SetIORegisterOverride.
+	ioOverrides map[uint16]func() byte
 }
 
 func newMemory(video *videoController, timer *timerController, interrupt *interruptController, serial *serialController, joypad *joypadController) *memory {
 	rom := newROM()
-	bootROM := newBootROM()
-	ffPage := newFFPage(video, timer, interrupt, serial, joypad)
-	externalRAM := newRAM("EXTERNAL RAM", 0xC000-0xA000, 0xA000)
+	bootROM := newBootROM(rom)
+	dma := newDMAController(video)
+	sound := newSoundController()
+	bootControl := newBootControlRegister()
+	ffPage := newFFPage(video, timer, interrupt, serial, joypad, dma, sound, bootControl)
 	wRAM0 := newRAM("WRAM[0]", 0xD000-0xC000, 0xC000)
 	wRAM1 := newRAM("WRAM[1]", 0xE000-0xD000, 0xD000)
 
@@ -199,15 +340,18 @@ func newMemory(video *videoController, timer *timerController, interrupt *interr
 	}{
 		{End: 0x7F, Controller: rom},
 		{End: 0x9F, Controller: video}, // VRAM
-		{End: 0xBF, Controller: externalRAM},
+		{End: 0xBF, Controller: rom},   // External RAM
 		{End: 0xCF, Controller: wRAM0},
 		{End: 0xDF, Controller: wRAM1},
-		{End: 0xFD, Controller: nil},   // ECHO RAM
+		{End: 0xEF, Controller: newEchoRAM(wRAM0)},
+		{End: 0xFD, Controller: newEchoRAM(wRAM1)},
 		{End: 0xFE, Controller: video}, // OAM
 		{End: 0xFF, Controller: ffPage},
 	}
 
-	pages := make([]memoryPage, 265)
+	// One page for every value of the higher-order byte of an address, such
+	// that indexing using uint8(address >> 8) is always within bounds
+	pages := make([]memoryPage, 256)
 	next := uint8(0x00)
 	for _, entry := range layout {
 		for i := uint16(next); i <= uint16(entry.End); i++ {
@@ -216,18 +360,42 @@ func newMemory(video *videoController, timer *timerController, interrupt *interr
 		next = entry.End + 1
 	}
 
-	return &memory{
+	m := &memory{
 		pages:   pages,
 		rom:     rom,
 		bootROM: bootROM,
 		video:   video,
+		dma:     dma,
+		sound:   sound,
+		ffPage:  ffPage,
+		wRAM0:   wRAM0,
+		wRAM1:   wRAM1,
+		strict:  true,
 	}
+	ffPage.memory = m
+	dma.memory = m // DMA transfers read through the full address space
+	bootControl.memory = m
+
+	// Accesses to addresses not mapped to a register are reported like any
+	// other unmapped access, see unmapped
+	rom.memory = m
+	timer.memory = m
+	interrupt.memory = m
+	serial.memory = m
+	joypad.memory = m
+
+	return m
 }
 
 func (m *memory) LoadROM(path string) error {
 	return m.rom.LoadROM(path)
 }
 
+// LoadROMBytes is like LoadROM, but loads the ROM from data rather than a file
+func (m *memory) LoadROMBytes(data []byte) error {
+	return m.rom.LoadROMBytes(data)
+}
+
 // LoadBootROM loads the Boot ROM (256bytes) at the beginning of the memory space
 //
 // The Boot ROM should be unloaded again when the PC reaches 0x0100. Do so by calling
@@ -237,9 +405,24 @@ func (m *memory) LoadBootROM(path string) error {
 		return err
 	}
 
+	m.mapBootROM()
+	return nil
+}
+
+// LoadBootROMBytes is like LoadBootROM, but loads the Boot ROM from data (256
+// bytes) rather than a file
+func (m *memory) LoadBootROMBytes(data []byte) error {
+	if err := m.bootROM.LoadBootROMBytes(data); err != nil {
+		return err
+	}
+
+	m.mapBootROM()
+	return nil
+}
+
+func (m *memory) mapBootROM() {
 	m.IsBootROMLoaded = true
 	m.pages[0] = m.bootROM // expose boot ROM in the lowest page
-	return nil
 }
 
 func (m *memory) UnloadBootROM() {
@@ -249,38 +432,92 @@ func (m *memory) UnloadBootROM() {
 }
 
 func (m *memory) Read8(address uint16) byte {
-	if address == 0xFF50 { // Boot ROM loaded register
-		// TODO: the current design makes it difficult to catch this signal in a
-		// page controller assigned to this exact address. Should be improved.
-		return 0
+	if m.ioOverrides != nil {
+		if f, ok := m.ioOverrides[address]; ok {
+			return f()
+		}
 	}
 
 	pageIdx := uint8(address >> 8)
 	page := m.pages[pageIdx]
 	if page == nil {
-		notImplemented("memory operations at address %#04x not implemented", address)
+		return m.unmapped(address, false)
 	}
 
 	return page.Read8(address)
 }
 
 func (m *memory) Write8(address uint16, v byte) {
-	if address == 0xFF50 && v == 0x01 {
-		// TODO: the current design makes it difficult to catch this signal in a
-		// page controller assigned to this exact address. Should be improved.
-		m.UnloadBootROM()
-		return
-	}
-
 	pageIdx := uint8(address >> 8)
 	page := m.pages[pageIdx]
 	if page == nil {
-		notImplemented("memory operations at address %#04x not implemented", address)
+		m.unmapped(address, true)
+		return
 	}
 
 	page.Write8(address, v)
 }
 
+// unmapped handles an access to an address that is not mapped to any memory or
+// I/O register, returning the value read
+//
+// Reads and writes panic with a *MemoryError in strict mode, as they do for
+// controllers not mapped into an address space (e.g. in tests). Otherwise
+// writes are ignored and reads return 0xFF, and the error is recorded and
+// available through Err. Writes are logged once per address.
+func (m *memory) unmapped(address uint16, write bool) byte {
+	err := &MemoryError{Address: address, Write: write}
+	if m == nil || m.strict {
+		panic(err)
+	}
+
+	m.errMutex.Lock()
+	defer m.errMutex.Unlock()
+
+	if write && !m.loggedErrors[address] {
+		if m.loggedErrors == nil {
+			m.loggedErrors = map[uint16]bool{}
+		}
+		m.loggedErrors[address] = true
+		log.Printf("WARNING: %v", err)
+	}
+	m.err = err
+	return 0xFF
+}
+
+// Err returns the most recent access to an unmapped address, or nil
+//
+// Safe to call from a different goroutine than the one running the emulator.
+func (m *memory) Err() error {
+	m.errMutex.Lock()
+	defer m.errMutex.Unlock()
+
+	if m.err == nil {
+		return nil
+	}
+	return m.err
+}
+
+// Peek8 reads a byte from memory for inspection (e.g. by a debugger),
+// returning 0xFF for addresses that are not mapped
+//
+// Unlike Read8, VRAM and OAM are readable while locked by the PPU or an OAM
+// DMA transfer.
+func (m *memory) Peek8(address uint16) byte {
+	page := m.pages[uint8(address>>8)]
+	if page == nil {
+		return 0xFF
+	}
+	if f, ok := page.(*ffPage); ok && f.entries[address-0xFF00] == nil {
+		return 0xFF // unused IO register
+	}
+	if page == memoryPage(m.video) {
+		return m.video.Peek8(address) // not locked by the PPU
+	}
+
+	return page.Read8(address)
+}
+
 // Read16 reads a 16bit value from memory
 //
 // NOTE: uses little-endian
@@ -1,6 +1,11 @@
 package emulator
 
 import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -25,7 +30,8 @@ func TestLoadAndUnloadBootROM(t *testing.T) {
 	interrupt := newInterruptController()
 	memory := newMemory(video, timer, interrupt, serial, joypad)
 
-	// the whiteout.gb ROM contains only 0x01s for the entire ROM (32kb)
+	// the whiteout.gb ROM contains only 0x01s for the entire ROM (32kb), except
+	// for the ROM size in the header
 	err := memory.LoadROM("testdata/roms/whiteout.gb")
 	require.NoError(t, err)
 
@@ -42,3 +48,330 @@ func TestLoadAndUnloadBootROM(t *testing.T) {
 	require.Equal(t, uint8(0x01), memory.Read8(255), "expected 256th bit to be restored to ROM data")
 	require.False(t, memory.IsBootROMLoaded)
 }
+
+func TestLoadBootROMBytes(t *testing.T) {
+	video := newVideoController()
+	timer := newTimerController()
+	serial := newSerialController()
+	joypad := newJoypadController()
+	interrupt := newInterruptController()
+	memory := newMemory(video, timer, interrupt, serial, joypad)
+
+	err := memory.LoadROM("testdata/roms/whiteout.gb")
+	require.NoError(t, err)
+
+	data := make([]byte, 256)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	require.NoError(t, memory.LoadBootROMBytes(data))
+	data[0] = 0xFF // the boot ROM is copied
+
+	require.True(t, memory.IsBootROMLoaded)
+	require.Equal(t, memory.bootROM, memory.pages[0], "expected Boot ROM to be mapped at page 0")
+	require.Equal(t, uint8(0x00), memory.Read8(0x00))
+	require.Equal(t, uint8(0xFF), memory.Read8(0xFF))
+	require.Equal(t, uint8(0x01), memory.Read8(0x100), "expected ROM data after the Boot ROM")
+
+	require.EqualError(t, memory.LoadBootROMBytes(make([]byte, 255)), "invalid ROM size: expected Boot ROM to contain 256 bytes but contained 255 bytes")
+}
+
+func TestBootControlRegisterUnloadsBootROM(t *testing.T) {
+	video := newVideoController()
+	timer := newTimerController()
+	serial := newSerialController()
+	joypad := newJoypadController()
+	interrupt := newInterruptController()
+	memory := newMemory(video, timer, interrupt, serial, joypad)
+
+	err := memory.LoadROM("testdata/roms/whiteout.gb")
+	require.NoError(t, err)
+	err = memory.LoadBootROM("testdata/roms/boot-whiteout.gb")
+	require.NoError(t, err)
+
+	require.Equal(t, uint8(0xFE), memory.Read8(0xFF50), "expected bit 0 to be unset while Boot ROM is mapped")
+
+	memory.Write8(0xFF50, 0x00) // bit 0 unset, ignored
+	require.True(t, memory.IsBootROMLoaded)
+
+	memory.Write8(0xFF50, 0x01)
+	require.False(t, memory.IsBootROMLoaded)
+	require.Equal(t, uint8(0x01), memory.Read8(0x00), "expected ROM data after unmapping Boot ROM")
+	require.Equal(t, uint8(0xFF), memory.Read8(0xFF50), "expected bit 0 to be set after unmapping Boot ROM")
+}
+
+func TestNewMemoryAssignsControllerToEveryPage(t *testing.T) {
+	video := newVideoController()
+	timer := newTimerController()
+	serial := newSerialController()
+	joypad := newJoypadController()
+	interrupt := newInterruptController()
+	memory := newMemory(video, timer, interrupt, serial, joypad)
+
+	require.Len(t, memory.pages, 256)
+
+	for page := 0x00; page <= 0xFF; page++ {
+		require.NotNil(t, memory.pages[page], "expected controller for page %#02x", page)
+	}
+}
+
+func TestEchoRAMMirrorsWRAM(t *testing.T) {
+	video := newVideoController()
+	timer := newTimerController()
+	serial := newSerialController()
+	joypad := newJoypadController()
+	interrupt := newInterruptController()
+	memory := newMemory(video, timer, interrupt, serial, joypad)
+
+	tests := []struct {
+		name  string
+		write uint16
+		read  uint16
+		value byte
+	}{
+		{name: "WRAM[0] to ECHO", write: 0xC005, read: 0xE005, value: 0x12},
+		{name: "ECHO to WRAM[0]", write: 0xE006, read: 0xC006, value: 0x34},
+		{name: "WRAM[1] to ECHO", write: 0xDDFF, read: 0xFDFF, value: 0x56},
+		{name: "ECHO to WRAM[1]", write: 0xF000, read: 0xD000, value: 0x78},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory.Write8(tt.write, tt.value)
+			require.Equal(t, tt.value, memory.Read8(tt.read))
+		})
+	}
+}
+
+func TestOAMIsAddressableThroughMemory(t *testing.T) {
+	video := newVideoController()
+	timer := newTimerController()
+	serial := newSerialController()
+	joypad := newJoypadController()
+	interrupt := newInterruptController()
+	memory := newMemory(video, timer, interrupt, serial, joypad)
+
+	// Sprite 1: Y, X, tile, attributes
+	memory.Write8(0xFE04, 0x20)
+	memory.Write8(0xFE05, 0x18)
+	memory.Write8(0xFE06, 0x42)
+	memory.Write8(0xFE07, 0x60)
+
+	require.Equal(t, []byte{0x20, 0x18, 0x42, 0x60}, video.oam[4:8])
+	require.Equal(t, uint8(0x42), memory.Read8(0xFE06))
+}
+
+func TestUnusableRegionAfterOAMReadsFF(t *testing.T) {
+	video := newVideoController()
+	timer := newTimerController()
+	serial := newSerialController()
+	joypad := newJoypadController()
+	interrupt := newInterruptController()
+	memory := newMemory(video, timer, interrupt, serial, joypad)
+
+	for address := uint16(0xFEA0); address <= 0xFEFF; address++ {
+		memory.Write8(address, 0x12)
+		require.Equal(t, uint8(0xFF), memory.Read8(address), "expected %#04x to read 0xFF", address)
+	}
+	require.Len(t, video.oam, 0xA0)
+}
+
+func TestUnusableRegionBoundaryWithOAM(t *testing.T) {
+	video := newVideoController()
+	timer := newTimerController()
+	serial := newSerialController()
+	joypad := newJoypadController()
+	interrupt := newInterruptController()
+	memory := newMemory(video, timer, interrupt, serial, joypad)
+
+	require.NotPanics(t, func() {
+		memory.Write16(0xFE9F, 0x1242) // 0xFE9F is the last byte of OAM
+	})
+	require.NoError(t, memory.Err())
+	require.Equal(t, uint8(0x42), memory.Read8(0xFE9F))
+	require.Equal(t, uint8(0xFF), memory.Read8(0xFEA0))
+	require.Equal(t, uint16(0xFF42), memory.Read16(0xFE9F))
+	require.Equal(t, uint16(0xFFFF), memory.Read16(0xFEFE))
+}
+
+// SetIORegisterOverride forces reads of the I/O register at address to return
+// the value of f, e.g. to simulate a specific STAT mode at a precise moment.
+// Passing a nil f removes the override.
+//
+// Only available to tests.
+func (m *memory) SetIORegisterOverride(address uint16, f func() byte) {
+	if address < 0xFF00 {
+		panic(fmt.Sprintf("%#04x is not an I/O register", address))
+	}
+
+	if f == nil {
+		delete(m.ioOverrides, address)
+		return
+	}
+	if m.ioOverrides == nil {
+		m.ioOverrides = map[uint16]func() byte{}
+	}
+	m.ioOverrides[address] = f
+}
+
+func TestIORegisterOverrideDrivesSTATPolling(t *testing.T) {
+	e := New()
+
+	// Waits for VBLANK by polling the STAT mode
+	program := []byte{
+		0xF0, 0x41, // LDH A,($41)
+		0xE6, 0x03, // AND 3
+		0xFE, 0x01, // CP 1
+		0x20, 0xF8, // JR NZ,-8
+		0x04, //       INC B
+	}
+	for i, b := range program {
+		e.Memory.Write8(0xC000+uint16(i), b)
+	}
+	e.CPU.ProgramCounter = 0xC000
+	e.CPU.Registers.Data[registerB] = 0
+
+	// The LCD is off, so STAT never reports VBLANK by itself
+	for i := 0; i < 100; i++ {
+		_, err := e.Step()
+		require.NoError(t, err)
+	}
+	require.Equal(t, uint8(0), e.CPU.Registers.Data[registerB])
+
+	e.Memory.SetIORegisterOverride(0xFF41, func() byte { return 0x81 })
+	for i := 0; i < 5; i++ {
+		_, err := e.Step()
+		require.NoError(t, err)
+	}
+	require.Equal(t, uint8(1), e.CPU.Registers.Data[registerB])
+
+	e.Memory.SetIORegisterOverride(0xFF41, nil)
+	require.Equal(t, e.Video.Read8(0xFF41), e.Memory.Read8(0xFF41))
+}
+
+// requirePanicsWithMemoryError requires f to panic with a *MemoryError equal
+// to want
+func requirePanicsWithMemoryError(t *testing.T, want *MemoryError, f func()) {
+	defer func() {
+		r := recover()
+		require.NotNil(t, r, "expected access to unmapped address to panic")
+		require.Equal(t, want, r)
+	}()
+
+	f()
+}
+
+func TestStrictMemoryPanicsOnUnmappedAccess(t *testing.T) {
+	e := New(WithStrictMemory(true))
+
+	requirePanicsWithMemoryError(t, &MemoryError{Address: 0xFF03}, func() {
+		e.Memory.Read8(0xFF03)
+	})
+	requirePanicsWithMemoryError(t, &MemoryError{Address: 0xFF7F, Write: true}, func() {
+		e.Memory.Write8(0xFF7F, 0x12)
+	})
+}
+
+func TestUnmappedRegisterOfStandaloneControllerPanics(t *testing.T) {
+	serial := newSerialController() // not mapped into an address space
+
+	requirePanicsWithMemoryError(t, &MemoryError{Address: 0xFF03}, func() {
+		serial.Read8(0xFF03)
+	})
+	requirePanicsWithMemoryError(t, &MemoryError{Address: 0xFF03, Write: true}, func() {
+		serial.Write8(0xFF03, 0x12)
+	})
+}
+
+func TestLenientMemoryLogsUnmappedWriteOnce(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	e := New(WithStrictMemory(false))
+	for i := 0; i < 3; i++ {
+		e.Memory.Write8(0xFF7F, 0x12)
+	}
+	e.Memory.Write8(0xFF4C, 0x12)
+
+	require.Equal(t, 1, strings.Count(output.String(), "write to unmapped address 0xff7f"))
+	require.Equal(t, 1, strings.Count(output.String(), "write to unmapped address 0xff4c"))
+}
+
+func TestLenientMemoryRecordsUnmappedAccess(t *testing.T) {
+	e := New(WithStrictMemory(false))
+	require.NoError(t, e.Memory.Err())
+
+	require.Equal(t, byte(0xFF), e.Memory.Read8(0xFF03))
+	require.Equal(t, &MemoryError{Address: 0xFF03}, e.Memory.Err())
+	require.EqualError(t, e.Memory.Err(), "read from unmapped address 0xff03")
+
+	e.Memory.Write8(0xFF7F, 0x12)
+	require.Equal(t, &MemoryError{Address: 0xFF7F, Write: true}, e.Memory.Err())
+}
+
+func TestLenientMemoryRecordsUnmappedRegisterAccess(t *testing.T) {
+	e := New(WithStrictMemory(false))
+
+	tests := []struct {
+		name       string
+		controller memoryPage
+		address    uint16
+	}{
+		{name: "ROM", controller: e.Memory.rom, address: 0x8000},
+		{name: "TIMER", controller: e.Timer, address: 0xFF08},
+		{name: "SERIAL", controller: e.Serial, address: 0xFF03},
+		{name: "JOYPAD", controller: e.Joypad, address: 0xFF01},
+		{name: "INTERRUPT", controller: e.Interrupt, address: 0xFF10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, byte(0xFF), tt.controller.Read8(tt.address))
+			require.Equal(t, &MemoryError{Address: tt.address}, e.Memory.Err())
+
+			tt.controller.Write8(tt.address, 0x12)
+			require.Equal(t, &MemoryError{Address: tt.address, Write: true}, e.Memory.Err())
+		})
+	}
+}
+
+func TestBootROMWritesAreForwardedToMBC(t *testing.T) {
+	path := writeBankedROM(t, 4, 0x03, 0x02) // MBC1+RAM+BATTERY, 8KB RAM
+
+	e := New()
+	require.NoError(t, e.Load(path, ""))
+	require.NoError(t, e.Memory.LoadBootROMBytes(make([]byte, 256)))
+
+	e.Memory.Write8(0x0000, 0x0A) // enable RAM while the Boot ROM is mapped
+	e.Memory.Write8(0xA000, 0x42)
+	require.Equal(t, byte(0x42), e.Memory.Read8(0xA000))
+	require.Equal(t, byte(0x00), e.Memory.Read8(0x0000), "expected Boot ROM to be unchanged")
+}
+
+func TestCGBRegistersReadFFAndIgnoreWrites(t *testing.T) {
+	tests := []struct {
+		name    string
+		address uint16
+		v       byte
+	}{
+		{name: "KEY1", address: 0xFF4D, v: 0x01}, // request speed switch
+		{name: "VBK", address: 0xFF4F, v: 0x01},  // select VRAM bank 1
+		{name: "SVBK", address: 0xFF70, v: 0x02}, // select WRAM bank 2
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := New()
+			e.Memory.Write8(0xC000, 0x12)
+			e.Memory.Write8(0xD000, 0x34)
+
+			e.Memory.Write8(tt.address, tt.v)
+			require.Equal(t, byte(0xFF), e.Memory.Read8(tt.address))
+
+			// Banks remain fixed
+			require.Equal(t, byte(0x12), e.Memory.Read8(0xC000))
+			require.Equal(t, byte(0x34), e.Memory.Read8(0xD000))
+		})
+	}
+}
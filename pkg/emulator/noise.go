@@ -0,0 +1,149 @@
+package emulator
+
+// noiseDivisors contains the divisors selected by bits 2-0 of NR43, in
+// machine cycles
+var noiseDivisors = [8]int{2, 4, 8, 12, 16, 20, 24, 28}
+
+// noiseChannel generates white noise using a linear feedback shift register
+// (sound channel 4)
+//
+// The channel is configured through 4 registers (NR41 - NR44):
+//
+// NR41 - Sound Length (Write Only)
+// - Bit 5-0 - Sound length data (t1: 0-63)
+// NR42 - Volume Envelope (R/W)
+// - Bit 7-4 - Initial Volume of envelope (0-0Fh) (0=No Sound)
+// - Bit 3   - Envelope Direction (0=Decrease, 1=Increase)
+// - Bit 2-0 - Number of envelope sweep (n: 0-7) (If zero, stop envelope operation.)
+// NR43 - Polynomial Counter (R/W)
+// - Bit 7-4 - Shift Clock Frequency (s)
+// - Bit 3   - Counter Step/Width (0=15 bits, 1=7 bits)
+// - Bit 2-0 - Dividing Ratio of Frequencies (r)
+// NR44 - Counter/consecutive; Initial (R/W)
+// - Bit 7   - Initial (1=Restart Sound) (Write Only)
+// - Bit 6   - Counter/consecutive selection (Read/Write) (1=Stop output when length in NR41 expires)
+//
+// See https://gbdev.io/pandocs/Sound_Controller.html
+type noiseChannel struct {
+	// registers contains the unused register at FF1F, followed by NR41 - NR44
+	// such that the indexes match the square channels
+	registers []byte
+
+	// enabled is true while the channel is producing sound
+	enabled bool
+
+	// frequencyTimer counts down the machine cycles until the next shift
+	frequencyTimer int
+	lfsr           uint16
+
+	lengthCounter int
+
+	volumeEnvelope
+}
+
+func newNoiseChannel(registers []byte) *noiseChannel {
+	return &noiseChannel{
+		registers: registers,
+	}
+}
+
+// Write8 handles writes to register NR41 - NR44, where r is the index of the
+// register (1 - 4). The register is expected to be stored already.
+func (c *noiseChannel) Write8(r int, v byte) {
+	switch r {
+	case 1:
+		c.lengthCounter = 64 - int(v&0x3F)
+	case 2:
+		if !c.dacEnabled() {
+			c.enabled = false
+		}
+	case 4:
+		if readBitN(v, 7) {
+			c.trigger()
+		}
+	}
+}
+
+// Cycle progresses the channel by a single machine cycle
+func (c *noiseChannel) Cycle() {
+	c.frequencyTimer--
+	if c.frequencyTimer > 0 {
+		return
+	}
+	c.frequencyTimer = c.period()
+
+	// The LFSR receives no clocks with shift clock frequency 14 and 15
+	if c.registers[3]>>4 < 14 {
+		c.clockLFSR()
+	}
+}
+
+// clockLFSR shifts the LFSR right, feeding back the XOR of the two lowest bits
+// into bit 14 (and bit 6 in 7-bit mode)
+func (c *noiseChannel) clockLFSR() {
+	xor := (c.lfsr & 0x01) ^ (c.lfsr >> 1 & 0x01)
+	c.lfsr = c.lfsr>>1 | xor<<14
+	if readBitN(c.registers[3], 3) {
+		c.lfsr = c.lfsr&^(1<<6) | xor<<6
+	}
+}
+
+// Sample returns the current output of the channel (-1 to 1)
+func (c *noiseChannel) Sample() float32 {
+	if !c.enabled {
+		return 0
+	}
+
+	// The output is high when bit 0 of the LFSR is clear
+	amplitude := float32(c.volume) / 15
+	if c.lfsr&0x01 == 0 {
+		return amplitude
+	}
+	return -amplitude
+}
+
+// ClockLength is called by the frame sequencer at 256Hz
+func (c *noiseChannel) ClockLength() {
+	if !readBitN(c.registers[4], 6) || c.lengthCounter == 0 {
+		return
+	}
+
+	c.lengthCounter--
+	if c.lengthCounter == 0 {
+		c.enabled = false
+	}
+}
+
+// ClockEnvelope is called by the frame sequencer at 64Hz
+func (c *noiseChannel) ClockEnvelope() {
+	c.clockEnvelope(c.registers[2])
+}
+
+// trigger restarts the channel (bit 7 of NR44)
+func (c *noiseChannel) trigger() {
+	c.enabled = c.dacEnabled()
+	if c.lengthCounter == 0 {
+		c.lengthCounter = 64
+	}
+	c.frequencyTimer = c.period()
+	c.lfsr = 0x7FFF
+	c.triggerEnvelope(c.registers[2])
+}
+
+// PowerOff disables the channel, and resets its state
+func (c *noiseChannel) PowerOff() {
+	*c = noiseChannel{
+		registers:     c.registers,
+		lengthCounter: c.lengthCounter, // retained on DMG
+	}
+}
+
+// dacEnabled returns true if the upper 5 bits of NR42 are non-zero
+func (c *noiseChannel) dacEnabled() bool {
+	return c.registers[2]&0xF8 != 0
+}
+
+// period returns the number of machine cycles between shifts of the LFSR
+func (c *noiseChannel) period() int {
+	return noiseDivisors[c.registers[3]&0x07] << (c.registers[3] >> 4)
+}
@@ -0,0 +1,80 @@
+package emulator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoiseLFSRSequence(t *testing.T) {
+	sound := newSoundController()
+	sound.Write8(0xFF26, 0x80) // power on
+	sound.Write8(0xFF21, 0xF0) // NR42 - volume 15
+	sound.Write8(0xFF23, 0x80) // NR44 - trigger
+	require.Equal(t, uint16(0x7FFF), sound.channel4.lfsr)
+
+	var values []uint16
+	for i := 0; i < 16; i++ {
+		sound.channel4.clockLFSR()
+		values = append(values, sound.channel4.lfsr)
+	}
+
+	require.Equal(t, []uint16{
+		0x3FFF, 0x1FFF, 0x0FFF, 0x07FF, 0x03FF, 0x01FF, 0x00FF, 0x007F,
+		0x003F, 0x001F, 0x000F, 0x0007, 0x0003, 0x0001, 0x4000, 0x2000,
+	}, values)
+}
+
+func TestNoiseLFSRPeriod(t *testing.T) {
+	tests := []struct {
+		name   string
+		nr43   byte
+		mask   uint16
+		period int
+	}{
+		{name: "15 bits", nr43: 0x00, mask: 0x7FFF, period: 32767},
+		{name: "7 bits", nr43: 0x08, mask: 0x007F, period: 127},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sound := newSoundController()
+			sound.Write8(0xFF26, 0x80) // power on
+			sound.Write8(0xFF21, 0xF0) // NR42 - volume 15
+			sound.Write8(0xFF22, tt.nr43)
+			sound.Write8(0xFF23, 0x80) // NR44 - trigger
+
+			channel := sound.channel4
+			start := channel.lfsr & tt.mask
+			period := 0
+			for {
+				channel.clockLFSR()
+				period++
+				if channel.lfsr&tt.mask == start {
+					break
+				}
+			}
+			require.Equal(t, tt.period, period)
+		})
+	}
+}
+
+func TestNoiseFrequencyTimer(t *testing.T) {
+	sound := newSoundController()
+	sound.Write8(0xFF26, 0x80) // power on
+	sound.Write8(0xFF21, 0xF0) // NR42 - volume 15
+	sound.Write8(0xFF22, 0x11) // NR43 - shift 1, divisor 4 (8 cycles per shift)
+	sound.Write8(0xFF23, 0x80) // NR44 - trigger
+	require.Equal(t, float32(-1), sound.channel4.Sample())
+
+	cycleSound(sound, 7)
+	require.Equal(t, uint16(0x7FFF), sound.channel4.lfsr)
+
+	cycleSound(sound, 1)
+	require.Equal(t, uint16(0x3FFF), sound.channel4.lfsr)
+
+	// The output is high once bit 0 becomes clear
+	cycleSound(sound, 14*8)
+	require.Equal(t, uint16(0x4000), sound.channel4.lfsr)
+	require.Equal(t, float32(1), sound.channel4.Sample())
+}
@@ -0,0 +1,90 @@
+package emulator
+
+import (
+	"image/color"
+	"sort"
+)
+
+// Palette maps each of the four shades rendered by the emulator to a color
+//
+// Frames only contain shades, and it is up to the frontend to pick the colors
+// used to display them.
+type Palette [4]color.RGBA
+
+// Color returns the color used to display shade s
+//
+// Shades outside the valid range (0-3) are displayed using the color of
+// shade 0 (white), as the LCD shows when nothing is drawn.
+func (p Palette) Color(s Shade) color.RGBA {
+	if int(s) >= len(p) {
+		return p[white]
+	}
+	return p[s]
+}
+
+var (
+	// PaletteGreen is the green palette of the original Game Boy (DMG)
+	PaletteGreen = Palette{
+		{R: 155, G: 188, B: 15, A: 255},
+		{R: 139, G: 172, B: 15, A: 255},
+		{R: 48, G: 98, B: 48, A: 255},
+		{R: 15, G: 56, B: 15, A: 255},
+	}
+
+	// PalettePocket is the gray palette of the Game Boy Pocket
+	PalettePocket = Palette{
+		{R: 255, G: 255, B: 255, A: 255},
+		{R: 169, G: 169, B: 169, A: 255},
+		{R: 84, G: 84, B: 84, A: 255},
+		{R: 0, G: 0, B: 0, A: 255},
+	}
+
+	// PaletteBGB is the default palette of the BGB emulator
+	PaletteBGB = Palette{
+		{R: 224, G: 248, B: 208, A: 255},
+		{R: 136, G: 192, B: 112, A: 255},
+		{R: 52, G: 104, B: 86, A: 255},
+		{R: 8, G: 24, B: 32, A: 255},
+	}
+
+	// PaletteIceCream is the "Ice Cream GB" palette by Kerrie Lake
+	PaletteIceCream = Palette{
+		{R: 255, G: 246, B: 211, A: 255},
+		{R: 249, G: 168, B: 117, A: 255},
+		{R: 235, G: 107, B: 111, A: 255},
+		{R: 124, G: 63, B: 88, A: 255},
+	}
+
+	// PaletteKirokaze is the "Kirokaze Gameboy" palette by Kirokaze
+	PaletteKirokaze = Palette{
+		{R: 226, G: 243, B: 228, A: 255},
+		{R: 148, G: 227, B: 68, A: 255},
+		{R: 70, G: 135, B: 143, A: 255},
+		{R: 51, G: 44, B: 80, A: 255},
+	}
+)
+
+var palettes = map[string]Palette{
+	"green":     PaletteGreen,
+	"pocket":    PalettePocket,
+	"bgb":       PaletteBGB,
+	"ice-cream": PaletteIceCream,
+	"kirokaze":  PaletteKirokaze,
+}
+
+// LookupPalette returns the palette with the given name, and false if no such
+// palette exists
+func LookupPalette(name string) (Palette, bool) {
+	p, ok := palettes[name]
+	return p, ok
+}
+
+// PaletteNames returns the names accepted by LookupPalette, sorted
+func PaletteNames() []string {
+	names := make([]string, 0, len(palettes))
+	for name := range palettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
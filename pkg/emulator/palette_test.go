@@ -0,0 +1,47 @@
+package emulator
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupPalette(t *testing.T) {
+	tests := []struct {
+		name      string
+		wantWhite color.RGBA
+		wantBlack color.RGBA
+	}{
+		{name: "green", wantWhite: color.RGBA{R: 155, G: 188, B: 15, A: 255}, wantBlack: color.RGBA{R: 15, G: 56, B: 15, A: 255}},
+		{name: "pocket", wantWhite: color.RGBA{R: 255, G: 255, B: 255, A: 255}, wantBlack: color.RGBA{R: 0, G: 0, B: 0, A: 255}},
+		{name: "bgb", wantWhite: color.RGBA{R: 224, G: 248, B: 208, A: 255}, wantBlack: color.RGBA{R: 8, G: 24, B: 32, A: 255}},
+		{name: "ice-cream", wantWhite: color.RGBA{R: 255, G: 246, B: 211, A: 255}, wantBlack: color.RGBA{R: 124, G: 63, B: 88, A: 255}},
+		{name: "kirokaze", wantWhite: color.RGBA{R: 226, G: 243, B: 228, A: 255}, wantBlack: color.RGBA{R: 51, G: 44, B: 80, A: 255}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := LookupPalette(tt.name)
+			require.True(t, ok)
+			require.Equal(t, tt.wantWhite, p.Color(white))
+			require.Equal(t, tt.wantBlack, p.Color(black))
+		})
+	}
+}
+
+func TestLookupPaletteUnknownName(t *testing.T) {
+	_, ok := LookupPalette("purple")
+	require.False(t, ok)
+}
+
+func TestPaletteNamesAreSorted(t *testing.T) {
+	require.Equal(t, []string{"bgb", "green", "ice-cream", "kirokaze", "pocket"}, PaletteNames())
+}
+
+func TestPaletteColorOutOfRangeShade(t *testing.T) {
+	require.NotPanics(t, func() {
+		require.Equal(t, PalettePocket[white], PalettePocket.Color(transparrent))
+		require.Equal(t, PalettePocket[white], PalettePocket.Color(Shade(4)))
+	})
+}
@@ -4,20 +4,362 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"strings"
+	"time"
 )
 
 const (
+	romTitle       uint16 = 0x0134
+	romCGBFlag     uint16 = 0x0143
+	romSGBFlag     uint16 = 0x0146
 	romMBCProtocol uint16 = 0x0147
 
 	romSize = 0x0148
 	ramSize = 0x0149
+
+	romDestination    uint16 = 0x014A
+	romHeaderChecksum uint16 = 0x014D
+	romGlobalChecksum uint16 = 0x014E
+
+	// romHeaderEnd is the first address after the cartridge header
+	romHeaderEnd = 0x0150
+)
+
+// headerTitle returns the upper case ASCII title of the game stored in the header
+//
+// The title occupies 0x0134-0x0143, but newer cartridges use the last byte for
+// the CGB flag, in which case the title is at most 15 characters.
+func headerTitle(header []byte) string {
+	end := romCGBFlag + 1
+	if headerIsCGB(header) {
+		end = romCGBFlag
+	}
+
+	return strings.TrimRight(string(header[romTitle:end]), "\x00")
+}
+
+// headerIsCGB returns true if the header marks the cartridge as supporting CGB
+// functions (either CGB-only or DMG compatible)
+func headerIsCGB(header []byte) bool {
+	return readBitN(header[romCGBFlag], 7)
+}
+
+// mbcType is the family of memory bank controller (MBC) used by a cartridge
+type mbcType uint8
+
+const (
+	mbcNone mbcType = iota
+	mbc1
+	mbc3
 )
 
+// headerMBCType returns the family of the MBC protocol in the header, and
+// false if the protocol is not supported
+func headerMBCType(header []byte) (mbcType, bool) {
+	return lookupMBCType(header[romMBCProtocol])
+}
+
+// lookupMBCType returns the family of an MBC protocol (cartridge type), and
+// false if the protocol is not supported
+func lookupMBCType(protocol byte) (mbcType, bool) {
+	switch protocol {
+	case 0x00:
+		return mbcNone, true
+	case 0x01, 0x02, 0x03: // MBC1, MBC1+RAM, MBC1+RAM+BATTERY
+		return mbc1, true
+	case 0x0F, 0x10, 0x11, 0x12, 0x13: // MBC3(+TIMER)(+RAM)(+BATTERY)
+		return mbc3, true
+	}
+
+	return mbcNone, false
+}
+
+// headerHasRTC returns true if the header marks the cartridge as containing a
+// real time clock
+func headerHasRTC(header []byte) bool {
+	protocol := header[romMBCProtocol]
+	return protocol == 0x0F || protocol == 0x10
+}
+
+// headerHasBattery returns true if the header marks the cartridge as having a
+// battery, which retains the external RAM (and clock) when powered off
+func headerHasBattery(header []byte) bool {
+	switch header[romMBCProtocol] {
+	case 0x03, 0x0F, 0x10, 0x13:
+		return true
+	}
+
+	return false
+}
+
+// headerROMSize returns the size of the ROM (in bytes) declared by the header,
+// and false if the size code is not recognized
+func headerROMSize(header []byte) (int, bool) {
+	return lookupROMSize(header[romSize])
+}
+
+// lookupROMSize returns the size of the ROM (in bytes) for a ROM size code,
+// and false if the code is not recognized
+func lookupROMSize(code byte) (int, bool) {
+	if code > 0x08 {
+		return 0, false
+	}
+
+	return bytes32k << code, true
+}
+
+// headerRAMSize returns the size of external RAM (in bytes) provided by the
+// cartridge
+func headerRAMSize(header []byte) int {
+	size, _ := lookupRAMSize(header[ramSize])
+	return size
+}
+
+// lookupRAMSize returns the size of external RAM (in bytes) for a RAM size
+// code, and false if the code is not recognized
+func lookupRAMSize(code byte) (int, bool) {
+	switch code {
+	case 0x00:
+		return 0, true
+	case 0x01:
+		return 0x800, true // 2KB
+	case 0x02:
+		return bytes08k, true
+	case 0x03:
+		return bytes32k, true
+	case 0x04:
+		return bytes64k * 2, true
+	case 0x05:
+		return bytes64k, true
+	}
+
+	return 0, false
+}
+
+// headerChecksum computes the checksum of the header (0x0134-0x014C), which
+// is verified by the boot ROM against the checksum stored at 0x014D
+func headerChecksum(header []byte) byte {
+	x := byte(0)
+	for _, b := range header[romTitle:romHeaderChecksum] {
+		x = x - b - 1
+	}
+	return x
+}
+
+// globalChecksum computes the checksum of the entire ROM, which is the sum of
+// all bytes except for the checksum itself (0x014E-0x014F)
+//
+// The boot ROM does not verify the global checksum.
+func globalChecksum(data []byte) uint16 {
+	sum := uint16(0)
+	for i, b := range data {
+		if i != int(romGlobalChecksum) && i != int(romGlobalChecksum)+1 {
+			sum += uint16(b)
+		}
+	}
+	return sum
+}
+
+// ChecksumError is returned when loading a ROM with checksum validation enabled
+// (see WithChecksumValidation), and a checksum does not match the header
+type ChecksumError struct {
+	// Checksum is either "header" or "global"
+	Checksum string
+
+	Expected uint16
+	Computed uint16
+}
+
+func (e ChecksumError) Error() string {
+	return fmt.Sprintf("%s checksum mismatch: header declares %#04x but computed %#04x", e.Checksum, e.Expected, e.Computed)
+}
+
+// CartridgeHeader contains the information in the cartridge header
+// (0x0100-0x014F)
+type CartridgeHeader struct {
+	// Title is the upper case ASCII title of the game
+	Title string
+
+	// CGBFlag marks the cartridge as supporting CGB functions (0x80), or
+	// requiring them (0xC0)
+	CGBFlag byte
+
+	// SGBFlag marks the cartridge as supporting SGB functions (0x03)
+	SGBFlag byte
+
+	// MBCType is the cartridge type as declared in the header (0x0147)
+	MBCType byte
+
+	// ROMSizeCode is the ROM size as declared in the header (0x0148), and
+	// ROMSize the size in bytes, or 0 if the code is not recognized (see
+	// ROMSizeKnown)
+	ROMSizeCode byte
+	ROMSize     int
+
+	// ROMBanks is the number of 16KB ROM banks declared by the header
+	ROMBanks int
+
+	// RAMSizeCode is the external RAM size as declared in the header (0x0149),
+	// and RAMSize the size in bytes, or 0 if the cartridge has no RAM or the
+	// code is not recognized (see RAMSizeKnown)
+	RAMSizeCode byte
+	RAMSize     int
+
+	// RAMBanks is the number of 8KB external RAM banks declared by the header,
+	// where a 2KB RAM counts as a single bank
+	RAMBanks int
+
+	// Destination is 0x00 for cartridges sold in Japan, and 0x01 otherwise
+	Destination byte
+
+	// HeaderChecksum is the checksum stored in the header (0x014D), and
+	// ComputedHeaderChecksum the checksum computed over 0x0134-0x014C. The boot
+	// ROM locks up if they differ.
+	HeaderChecksum         byte
+	ComputedHeaderChecksum byte
+
+	// GlobalChecksum is the checksum stored in the header (0x014E-0x014F), and
+	// ComputedGlobalChecksum the checksum computed over the entire ROM
+	GlobalChecksum         uint16
+	ComputedGlobalChecksum uint16
+}
+
+// HeaderChecksumValid returns true if the header checksum matches the header
+func (h CartridgeHeader) HeaderChecksumValid() bool {
+	return h.HeaderChecksum == h.ComputedHeaderChecksum
+}
+
+// GlobalChecksumValid returns true if the global checksum matches the ROM
+func (h CartridgeHeader) GlobalChecksumValid() bool {
+	return h.GlobalChecksum == h.ComputedGlobalChecksum
+}
+
+// ROMSizeKnown returns true if the ROM size code is recognized
+func (h CartridgeHeader) ROMSizeKnown() bool {
+	_, ok := lookupROMSize(h.ROMSizeCode)
+	return ok
+}
+
+// RAMSizeKnown returns true if the RAM size code is recognized
+func (h CartridgeHeader) RAMSizeKnown() bool {
+	_, ok := lookupRAMSize(h.RAMSizeCode)
+	return ok
+}
+
+// mbcNames contains the names of the cartridge types (0x0147) as listed in the
+// Pan Docs
+var mbcNames = map[byte]string{
+	0x00: "ROM ONLY",
+	0x01: "MBC1",
+	0x02: "MBC1+RAM",
+	0x03: "MBC1+RAM+BATTERY",
+	0x05: "MBC2",
+	0x06: "MBC2+BATTERY",
+	0x08: "ROM+RAM",
+	0x09: "ROM+RAM+BATTERY",
+	0x0B: "MMM01",
+	0x0C: "MMM01+RAM",
+	0x0D: "MMM01+RAM+BATTERY",
+	0x0F: "MBC3+TIMER+BATTERY",
+	0x10: "MBC3+TIMER+RAM+BATTERY",
+	0x11: "MBC3",
+	0x12: "MBC3+RAM",
+	0x13: "MBC3+RAM+BATTERY",
+	0x19: "MBC5",
+	0x1A: "MBC5+RAM",
+	0x1B: "MBC5+RAM+BATTERY",
+	0x1C: "MBC5+RUMBLE",
+	0x1D: "MBC5+RUMBLE+RAM",
+	0x1E: "MBC5+RUMBLE+RAM+BATTERY",
+	0x20: "MBC6",
+	0x22: "MBC7+SENSOR+RUMBLE+RAM+BATTERY",
+	0xFC: "POCKET CAMERA",
+	0xFD: "BANDAI TAMA5",
+	0xFE: "HuC3",
+	0xFF: "HuC1+RAM+BATTERY",
+}
+
+// MBCName returns the name of the cartridge type, e.g. "MBC1+RAM+BATTERY"
+func (h CartridgeHeader) MBCName() string {
+	if name, ok := mbcNames[h.MBCType]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN (%#02x)", h.MBCType)
+}
+
+// MBCSupported returns true if the emulator supports the cartridge type
+func (h CartridgeHeader) MBCSupported() bool {
+	_, ok := lookupMBCType(h.MBCType)
+	return ok
+}
+
+// ParseCartridgeHeader decodes the cartridge header of a ROM, where data
+// contains the entire ROM
+//
+// Only the header itself is validated, not the ROM size or MBC type, such that
+// the header of ROMs that fail to load can still be inspected.
+func ParseCartridgeHeader(data []byte) (CartridgeHeader, error) {
+	if len(data) < romHeaderEnd {
+		return CartridgeHeader{}, fmt.Errorf("invalid ROM: expected at least %d bytes to contain the header but contained %d bytes", romHeaderEnd, len(data))
+	}
+
+	return parseHeader(data), nil
+}
+
+// ReadCartridgeHeader reads the ROM at path and decodes its cartridge header
+// (see ParseCartridgeHeader)
+func ReadCartridgeHeader(path string) (CartridgeHeader, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return CartridgeHeader{}, err
+	}
+
+	return ParseCartridgeHeader(data)
+}
+
+// parseHeader decodes the cartridge header, where data contains the entire ROM
+func parseHeader(data []byte) CartridgeHeader {
+	header := data[:romHeaderEnd]
+	size, _ := headerROMSize(header)
+	ram := headerRAMSize(header)
+
+	return CartridgeHeader{
+		Title:          headerTitle(header),
+		CGBFlag:        header[romCGBFlag],
+		SGBFlag:        header[romSGBFlag],
+		MBCType:        header[romMBCProtocol],
+		ROMSizeCode:    header[romSize],
+		ROMSize:        size,
+		ROMBanks:       size / bytes16k,
+		RAMSizeCode:    header[ramSize],
+		RAMSize:        ram,
+		RAMBanks:       (ram + bytes08k - 1) / bytes08k,
+		Destination:    header[romDestination],
+		HeaderChecksum: header[romHeaderChecksum],
+
+		ComputedHeaderChecksum: headerChecksum(header),
+		GlobalChecksum:         uint16(header[romGlobalChecksum])<<8 | uint16(header[romGlobalChecksum+1]),
+		ComputedGlobalChecksum: globalChecksum(data),
+	}
+}
+
+// rom represents the cartridge, i.e. the ROM and the external RAM (if any) as
+// mapped through the cartridge's memory bank controller (MBC)
 type rom struct {
 	// data contains the entire ROM data
 	data []byte
 
-	// bankROMLow contains the lower 5 bits of the ROM bank number
+	// ram contains the external RAM mapped to 0xA000-0xBFFF (possibly banked)
+	ram []byte
+
+	// mbc is the memory bank controller used by the cartridge
+	mbc mbcType
+
+	// cgb is true if the cartridge supports CGB functions
+	cgb bool
+
+	// bankROMLow contains the lower 5 bits of the ROM bank number (MBC1), or
+	// the entire 7 bit ROM bank number (MBC3)
 	bankROMLow byte
 
 	// bankROMHighRAM containers either the two lower bits of the RAM bank, or bit
@@ -27,43 +369,148 @@ type rom struct {
 	// bankRAMMode selects if bankROMHighRAM is used for selecting the ROM bank
 	// (false) or the RAM bank (true)
 	bankRAMMode bool
+
+	// bankRAMRTC selects the RAM bank (0x00-0x03) or the RTC register
+	// (0x08-0x0C) mapped to 0xA000-0xBFFF (MBC3)
+	bankRAMRTC byte
+
+	// ramEnabled is true if external RAM (and RTC registers for MBC3) are
+	// accessible. Cartridges without an MBC always have RAM accessible.
+	ramEnabled bool
+
+	// ramDirty is true if external RAM was written to since it was last saved,
+	// and saveRequested is set once the program disables RAM after writing to
+	// it, which games commonly do once done saving
+	ramDirty      bool
+	saveRequested bool
+
+	// validateChecksums causes LoadROM to fail if the header or global checksum
+	// does not match
+	validateChecksums bool
+
+	// battery is true if the cartridge retains external RAM when powered off
+	battery bool
+
+	// rtc is the real time clock of the cartridge (MBC3), or nil if the
+	// cartridge has no clock
+	rtc *rtc
+
+	// now returns the current wall time, used to tick the real time clock
+	now func() time.Time
+
+	// bankSwitches records bank switches, if set
+	bankSwitches *bankSwitchRecorder
+
+	// memory reports accesses to addresses not mapped to ROM or external RAM
+	memory *memory
+}
+
+// BankType identifies the type of memory bank switched by the MBC
+type BankType uint8
+
+const (
+	BankROM BankType = iota
+	BankRAM
+)
+
+// BankSwitchEvent describes a switch of ROM or RAM bank by the MBC
+type BankSwitchEvent struct {
+	Type BankType
+	Bank uint8
+
+	// PC is the address of the instruction that switched bank
+	PC uint16
+
+	// Cycle is the number of machine cycles run by the CPU before the
+	// instruction that switched bank
+	Cycle uint64
+}
+
+// bankSwitchRecorder records all bank switches made by the MBC
+type bankSwitchRecorder struct {
+	events []BankSwitchEvent
+
+	// clock returns the address of the current instruction and the number of
+	// cycles run by the CPU
+	clock func() (pc uint16, cycle uint64)
+}
+
+// record adds events for any ROM or RAM bank switched between the two states
+func (b *bankSwitchRecorder) record(before MBCState, after MBCState) {
+	pc, cycle := b.clock()
+	if before.ROMBank != after.ROMBank {
+		b.events = append(b.events, BankSwitchEvent{Type: BankROM, Bank: after.ROMBank, PC: pc, Cycle: cycle})
+	}
+	if before.RAMBank != after.RAMBank {
+		b.events = append(b.events, BankSwitchEvent{Type: BankRAM, Bank: after.RAMBank, PC: pc, Cycle: cycle})
+	}
 }
 
 func newROM() *rom {
 	return &rom{
 		data: make([]byte, bytes32k),
+		ram:  make([]byte, bytes08k),
+		now:  time.Now,
 	}
 }
 
-// Read8 reads ROM data currently mapped into the address space
-//
-// TODO: Technically, RAM is also provided by the cartridge, and the MBC
-// protocol determines if (a) ram is available (at A000-BFFF), and (b) how much
-// is available.
+// Read8 reads ROM data or external RAM currently mapped into the address space
 //
 // - 0x0000-0x3FFF    Bank 0        Mapped directly to the beginning of ROM data
 // - 0x4000-0x7FFF    Bank 01-7F
+// - 0xA000-0xBFFF    External RAM (or RTC registers for MBC3)
 func (r *rom) Read8(address uint16) byte {
 	switch {
 	case 0x0000 <= address && address <= 0x3FFF:
 		// as the ROM is placed at the beginning of the address space we don't need to offset the input address
 		return r.data[address]
 	case 0x4000 <= address && address <= 0x7FFF:
-		return r.data[0x4000*uint16(r.romBankNumber())+(address-0x4000)]
+		// Banks beyond the size of the ROM wrap around, as the MBC ignores bank
+		// bits that are not connected to the ROM
+		bank := int(r.romBankNumber()) % (len(r.data) / bytes16k)
+		return r.data[bytes16k*bank+int(address-0x4000)]
+	case 0xA000 <= address && address <= 0xBFFF:
+		return r.readRAM(address)
 	}
 
-	notImplemented("reads from ROM at address %x not implemented", address)
-	return 0
+	return r.memory.unmapped(address, false)
 }
 
 // Write8 interacts with the Memory Bank Controller (MBC), e.g. to switch ROM or
-// RAM banks
+// RAM banks, or writes to external RAM
 //
+// MBC1:
+// 0x0000-0x1FFF  Enable RAM (0x0A = enable)
 // 0x2000-0x3FFF  Set bankROMLow
 // 0x4000-0x5FFF  Set bankROMHighRAM
 // 0x6000-0x7FFF  Set bankRAMMode
+//
+// MBC3:
+// 0x0000-0x1FFF  Enable RAM and RTC registers (0x0A = enable)
+// 0x2000-0x3FFF  Set bankROMLow (7 bits)
+// 0x4000-0x5FFF  Set bankRAMRTC
+// 0x6000-0x7FFF  Latch clock data (write 0x00 followed by 0x01)
 func (r *rom) Write8(address uint16, v byte) {
+	if 0xA000 <= address && address <= 0xBFFF {
+		r.writeRAM(address, v)
+		return
+	}
+
+	if r.bankSwitches != nil {
+		before := r.State()
+		defer func() {
+			r.bankSwitches.record(before, r.State())
+		}()
+	}
+
+	if r.mbc == mbc3 {
+		r.writeMBC3(address, v)
+		return
+	}
+
 	switch {
+	case address <= 0x1FFF:
+		r.enableRAM(v&0x0F == 0x0A)
 	case 0x2000 <= address && address <= 0x3FFF:
 		r.bankROMLow = v & 0x1F // only write the lower 5 bits
 	case 0x4000 <= address && address <= 0x5FFF:
@@ -71,8 +518,94 @@ func (r *rom) Write8(address uint16, v byte) {
 	case 0x6000 <= address && address <= 0x7FFF:
 		r.bankRAMMode = readBitN(v, 0)
 	default:
-		notImplemented("writes to MBC at address %x not implemented", address)
+		r.memory.unmapped(address, true)
+	}
+}
+
+func (r *rom) writeMBC3(address uint16, v byte) {
+	switch {
+	case address <= 0x1FFF:
+		r.enableRAM(v&0x0F == 0x0A)
+	case 0x2000 <= address && address <= 0x3FFF:
+		r.bankROMLow = v & 0x7F // only write the lower 7 bits
+	case 0x4000 <= address && address <= 0x5FFF:
+		r.bankRAMRTC = v
+	case 0x6000 <= address && address <= 0x7FFF:
+		if r.rtc != nil {
+			r.rtc.WriteLatch(v)
+		}
+	}
+}
+
+// enableRAM enables or disables access to external RAM, requesting the RAM to
+// be saved if it is disabled after being written to
+func (r *rom) enableRAM(enabled bool) {
+	if r.ramEnabled && !enabled && r.ramDirty {
+		r.saveRequested = true
+	}
+	r.ramEnabled = enabled
+}
+
+func (r *rom) readRAM(address uint16) byte {
+	if r.mbc != mbcNone && !r.ramEnabled {
+		return 0xFF
+	}
+
+	if r.mbc != mbc3 {
+		return r.ram[r.ramOffset(address)]
 	}
+
+	switch {
+	case r.bankRAMRTC <= 0x03:
+		return r.ram[r.ramOffset(address)]
+	case 0x08 <= r.bankRAMRTC && r.bankRAMRTC <= 0x0C && r.rtc != nil:
+		return r.rtc.Read8(r.bankRAMRTC - 0x08)
+	}
+
+	return 0xFF
+}
+
+func (r *rom) writeRAM(address uint16, v byte) {
+	if r.mbc != mbcNone && !r.ramEnabled {
+		return
+	}
+
+	if r.mbc != mbc3 {
+		r.ram[r.ramOffset(address)] = v
+		r.ramDirty = true
+		return
+	}
+
+	switch {
+	case r.bankRAMRTC <= 0x03:
+		r.ram[r.ramOffset(address)] = v
+		r.ramDirty = true
+	case 0x08 <= r.bankRAMRTC && r.bankRAMRTC <= 0x0C && r.rtc != nil:
+		r.rtc.Write8(r.bankRAMRTC-0x08, v)
+	}
+}
+
+// ramOffset returns the offset into ram for an address in 0xA000-0xBFFF using
+// the currently selected RAM bank
+//
+// Banks beyond the size of the RAM wrap around, similar to ROM banks.
+func (r *rom) ramOffset(address uint16) int {
+	offset := int(r.ramBankNumber())*bytes08k + int(address-0xA000)
+	return offset % len(r.ram)
+}
+
+// ramBankNumber returns the RAM bank currently mapped to 0xA000-0xBFFF
+//
+// MBC1 only uses the upper bank bits for selecting the RAM bank when in RAM
+// banking mode, and otherwise always maps bank 0.
+func (r *rom) ramBankNumber() byte {
+	switch {
+	case r.mbc == mbc3:
+		return r.bankRAMRTC
+	case r.mbc == mbc1 && r.bankRAMMode:
+		return r.bankROMHighRAM
+	}
+	return 0
 }
 
 func (r *rom) String() string {
@@ -85,27 +618,167 @@ func (r *rom) LoadROM(path string) error {
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
 		return err
-	} else if len(data) < bytes32k {
+	}
+
+	return r.load(data)
+}
+
+// LoadROMBytes loads the ROM from a copy of data, validating it like LoadROM
+func (r *rom) LoadROMBytes(data []byte) error {
+	return r.load(append([]byte(nil), data...))
+}
+
+// load validates the size, MBC, and header of the ROM in data, and loads it
+func (r *rom) load(data []byte) error {
+	if len(data) < bytes32k {
 		return fmt.Errorf("invalid ROM size: expected ROM to contain at least %d bytes but contained %d bytes", bytes32k, len(data))
 	}
 
+	size, ok := headerROMSize(data)
+	if !ok {
+		return fmt.Errorf("unsupported ROM size code %#02x", data[romSize])
+	} else if len(data) < size {
+		return fmt.Errorf("truncated ROM: header declares %d bytes (%d banks) but ROM contains %d bytes", size, size/bytes16k, len(data))
+	} else if len(data) > size {
+		// e.g. overdumped or padded ROMs, for which out of range banks wrap
+		log.Printf("WARNING: header declares %d bytes (%d banks) but ROM contains %d bytes", size, size/bytes16k, len(data))
+	}
+
+	// Support memory bank controller protocols 0, 1, and 3
+	mbc, ok := headerMBCType(data)
+	if !ok {
+		return fmt.Errorf("unsupported MBC %d", data[romMBCProtocol])
+	}
+
+	header := parseHeader(data)
+	if !header.HeaderChecksumValid() {
+		err := ChecksumError{Checksum: "header", Expected: uint16(header.HeaderChecksum), Computed: uint16(header.ComputedHeaderChecksum)}
+		if r.validateChecksums {
+			return err
+		}
+		log.Printf("WARNING: %s", err)
+	}
+	if r.validateChecksums && !header.GlobalChecksumValid() {
+		return ChecksumError{Checksum: "global", Expected: header.GlobalChecksum, Computed: header.ComputedGlobalChecksum}
+	}
+
 	r.data = data
+	r.mbc = mbc
+	r.battery = headerHasBattery(data)
+	r.cgb = headerIsCGB(data)
+	if r.cgb {
+		log.Printf("detected CGB cartridge, running in DMG compatibility mode")
+	}
+
+	// Reset the banking state, RAM, and clock left by a previously loaded
+	// cartridge
+	r.bankROMLow = 0
+	r.bankROMHighRAM = 0
+	r.bankRAMMode = false
+	r.bankRAMRTC = 0
+	r.ramEnabled = false
+	r.ramDirty = false
+	r.saveRequested = false
 
-	// Support memory bank controller protocols 0 and 1
-	mbcProtocol := r.data[0x0147]
-	if mbcProtocol > 1 {
-		return fmt.Errorf("unsupported MBC %d", mbcProtocol)
+	ramBytes := headerRAMSize(data)
+	if ramBytes < bytes08k {
+		ramBytes = bytes08k
+	}
+	r.ram = make([]byte, ramBytes)
+
+	r.rtc = nil
+	if headerHasRTC(data) {
+		r.rtc = newRTC(r.now)
 	}
 
 	log.Printf("Loaded %d bytes from ROM", len(data))
 	return nil
 }
 
+// Header returns the header of the loaded cartridge
+func (r *rom) Header() CartridgeHeader {
+	return parseHeader(r.data)
+}
+
+// IsCGB returns true if the loaded cartridge supports CGB functions, either
+// as a CGB-only or a DMG compatible cartridge
+//
+// CGB functions are not supported, such that the cartridge runs as if
+// inserted into a DMG.
+func (r *rom) IsCGB() bool {
+	return r.cgb
+}
+
+// MBCState describes the current banking state of the cartridge's memory bank
+// controller
+type MBCState struct {
+	// ROMBank is the ROM bank mapped to 0x4000-0x7FFF
+	ROMBank uint8
+
+	// RAMBank is the RAM bank mapped to 0xA000-0xBFFF (or the selected RTC
+	// register, 0x08-0x0C, for MBC3)
+	RAMBank uint8
+
+	// RAMEnabled is true if external RAM is accessible
+	RAMEnabled bool
+
+	// RAMBankingMode is true if MBC1 uses the upper bank bits to select the RAM
+	// bank rather than the ROM bank
+	RAMBankingMode bool
+}
+
+// State returns the current banking state of the MBC
+func (r *rom) State() MBCState {
+	if r.mbc == mbc3 {
+		return MBCState{
+			ROMBank:    r.romBankNumber(),
+			RAMBank:    r.bankRAMRTC,
+			RAMEnabled: r.ramEnabled,
+		}
+	}
+
+	state := MBCState{
+		ROMBank:        r.romBankNumber(),
+		RAMEnabled:     r.mbc == mbcNone || r.ramEnabled,
+		RAMBankingMode: r.bankRAMMode,
+	}
+	if r.bankRAMMode {
+		state.RAMBank = r.bankROMHighRAM
+	}
+
+	return state
+}
+
+// RAM returns a copy of the external RAM
+func (r *rom) RAM() []byte {
+	data := make([]byte, len(r.ram))
+	copy(data, r.ram)
+	return data
+}
+
+// LoadRAM restores the external RAM from data, e.g. as previously returned by
+// RAM
+func (r *rom) LoadRAM(data []byte) error {
+	if len(data) > len(r.ram) {
+		return fmt.Errorf("invalid RAM size: expected at most %d bytes but got %d bytes", len(r.ram), len(data))
+	}
+
+	copy(r.ram, data)
+	return nil
+}
+
 func (r *rom) romBankNumber() uint8 {
+	if r.mbc == mbc3 {
+		if r.bankROMLow == 0 {
+			return 1 // bank 0 is interpreted as bank 1
+		}
+		return r.bankROMLow
+	}
+
 	num := r.bankROMLow
 	if num == 0 {
-		// interpret bank 0 as bank 1
-		// NOTE: bank 20, 40, and 60 are not usable due to this semantic
+		// interpret bank 0 as bank 1. The check only considers the lower 5 bits,
+		// such that bank 0x20, 0x40, and 0x60 map to 0x21, 0x41, and 0x61
 		num = 1
 	}
 	if !r.bankRAMMode {
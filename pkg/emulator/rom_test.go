@@ -0,0 +1,439 @@
+package emulator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeBankedROM writes a synthetic ROM with the given number of 16KB banks to
+// a temporary file, where the first byte of every bank contains the bank number
+func writeBankedROM(t *testing.T, banks int, mbcProtocol byte, ramSizeCode byte) string {
+	dir, err := ioutil.TempDir("", "gbemu-rom")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	data := make([]byte, banks*bytes16k)
+	for bank := 0; bank < banks; bank++ {
+		data[bank*bytes16k] = byte(bank)
+	}
+	data[romMBCProtocol] = mbcProtocol
+	data[ramSize] = ramSizeCode
+	for size := bytes32k; size < len(data); size *= 2 {
+		data[romSize]++
+	}
+
+	path := filepath.Join(dir, "rom.gb")
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+	return path
+}
+
+func TestLoadROMRejectsUnsupportedMBC(t *testing.T) {
+	path := writeBankedROM(t, 2, 0x19, 0x00) // MBC5
+
+	err := newROM().LoadROM(path)
+	require.EqualError(t, err, "unsupported MBC 25")
+}
+
+func TestLoadROMRejectsTruncatedROM(t *testing.T) {
+	path := writeBankedROM(t, 4, 0x01, 0x00) // MBC1, 64KB
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path, data[:3*bytes16k], 0644))
+
+	err = newROM().LoadROM(path)
+	require.EqualError(t, err, "truncated ROM: header declares 65536 bytes (4 banks) but ROM contains 49152 bytes")
+}
+
+func TestLoadROMAcceptsOversizedROM(t *testing.T) {
+	path := writeBankedROM(t, 4, 0x01, 0x00) // MBC1, 64KB
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path, append(data, make([]byte, bytes16k)...), 0644))
+
+	r := newROM()
+	require.NoError(t, r.LoadROM(path))
+
+	r.Write8(0x2000, 0x03)
+	require.Equal(t, uint8(3), r.Read8(0x4000))
+}
+
+func TestROMBanksBeyondROMSizeWrapAround(t *testing.T) {
+	path := writeBankedROM(t, 4, 0x01, 0x00) // MBC1, 64KB
+
+	r := newROM()
+	require.NoError(t, r.LoadROM(path))
+
+	r.Write8(0x2000, 0x06) // select bank 6, which is not present
+	require.Equal(t, uint8(2), r.Read8(0x4000), "expected bank 6 to wrap around to bank 2")
+}
+
+func TestLoadROMDetectsCGBFlag(t *testing.T) {
+	tests := []struct {
+		name    string
+		cgbFlag byte
+		wantCGB bool
+	}{
+		{name: "DMG only", cgbFlag: 0x00, wantCGB: false},
+		{name: "DMG compatible", cgbFlag: 0x80, wantCGB: true},
+		{name: "CGB only", cgbFlag: 0xC0, wantCGB: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeBankedROM(t, 2, 0x00, 0x00)
+			data, err := ioutil.ReadFile(path)
+			require.NoError(t, err)
+			data[romCGBFlag] = tt.cgbFlag
+			require.NoError(t, ioutil.WriteFile(path, data, 0644))
+
+			r := newROM()
+			require.NoError(t, r.LoadROM(path))
+			require.Equal(t, tt.wantCGB, r.IsCGB())
+		})
+	}
+}
+
+func TestMBC1AliasesBanksWithZeroLowerBits(t *testing.T) {
+	path := writeBankedROM(t, 128, 0x01, 0x00) // MBC1, 2MB
+
+	tests := []struct {
+		name     string
+		low      byte
+		high     byte
+		wantBank byte
+	}{
+		{name: "bank 0x00", low: 0x00, high: 0x00, wantBank: 0x01},
+		{name: "bank 0x20", low: 0x00, high: 0x01, wantBank: 0x21},
+		{name: "bank 0x40", low: 0x00, high: 0x02, wantBank: 0x41},
+		{name: "bank 0x60", low: 0x00, high: 0x03, wantBank: 0x61},
+		{name: "bank 0x61", low: 0x01, high: 0x03, wantBank: 0x61},
+		{name: "bank 0x7F", low: 0x1F, high: 0x03, wantBank: 0x7F},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newROM()
+			require.NoError(t, r.LoadROM(path))
+
+			r.Write8(0x2000, tt.low)
+			r.Write8(0x4000, tt.high)
+			require.Equal(t, tt.wantBank, r.State().ROMBank)
+			require.Equal(t, tt.wantBank, r.Read8(0x4000))
+		})
+	}
+}
+
+func TestMBC1GatesRAMAccess(t *testing.T) {
+	path := writeBankedROM(t, 4, 0x03, 0x02) // MBC1+RAM+BATTERY, 8KB RAM
+
+	r := newROM()
+	require.NoError(t, r.LoadROM(path))
+
+	r.Write8(0xA000, 0x42) // ignored while RAM is disabled
+	require.Equal(t, uint8(0xFF), r.Read8(0xA000))
+
+	r.Write8(0x0000, 0x0A) // enable RAM
+	r.Write8(0xA000, 0x42)
+	require.Equal(t, uint8(0x42), r.Read8(0xA000))
+
+	r.Write8(0x1FFF, 0x00) // disable RAM
+	require.Equal(t, uint8(0xFF), r.Read8(0xA000))
+	r.Write8(0xA000, 0x24)
+
+	r.Write8(0x1000, 0xFA) // only the lower nibble is checked
+	require.Equal(t, uint8(0x42), r.Read8(0xA000), "expected write while disabled to be ignored")
+}
+
+func TestLoadROMResetsPreviousCartridge(t *testing.T) {
+	r := newROM()
+	require.NoError(t, r.LoadROM(writeBankedROM(t, 8, 0x10, 0x03))) // MBC3+TIMER+RAM+BATTERY, 32KB RAM
+
+	r.Write8(0x0000, 0x0A) // enable RAM
+	r.Write8(0x2000, 0x05) // ROM bank 5
+	r.Write8(0x4000, 0x02) // RAM bank 2
+	r.Write8(0xA000, 0x42)
+	require.NotNil(t, r.rtc)
+
+	require.NoError(t, r.LoadROM(writeBankedROM(t, 8, 0x03, 0x02))) // MBC1+RAM+BATTERY, 8KB RAM
+
+	require.Equal(t, MBCState{ROMBank: 1}, r.State())
+	require.Nil(t, r.rtc, "expected no clock for a cartridge without RTC")
+	require.Len(t, r.ram, bytes08k)
+
+	r.Write8(0x0000, 0x0A) // enable RAM
+	require.Equal(t, uint8(0x00), r.Read8(0xA000), "expected RAM of previous cartridge to be cleared")
+}
+
+func TestCartridgeHeaderDecodesFields(t *testing.T) {
+	path := writeBankedROM(t, 8, 0x13, 0x03) // MBC3+RAM+BATTERY, 128KB ROM, 32KB RAM
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	copy(data[romTitle:], "POKEMON RED")
+	data[romCGBFlag] = 0x80
+	data[romSGBFlag] = 0x03
+	data[romDestination] = 0x01
+	data[romHeaderChecksum] = headerChecksum(data)
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+
+	e := New()
+	require.NoError(t, e.Memory.LoadROM(path))
+
+	require.Equal(t, CartridgeHeader{
+		Title:                  "POKEMON RED",
+		CGBFlag:                0x80,
+		SGBFlag:                0x03,
+		MBCType:                0x13,
+		ROMSizeCode:            0x02,
+		ROMSize:                128 * 1024,
+		ROMBanks:               8,
+		RAMSizeCode:            0x03,
+		RAMSize:                32 * 1024,
+		RAMBanks:               4,
+		Destination:            0x01,
+		HeaderChecksum:         data[romHeaderChecksum],
+		ComputedHeaderChecksum: data[romHeaderChecksum],
+		GlobalChecksum:         0x0000,
+		ComputedGlobalChecksum: globalChecksum(data),
+	}, e.Cartridge())
+}
+
+func TestReadCartridgeHeader(t *testing.T) {
+	path := writeBankedROM(t, 2, 0x19, 0x00) // MBC5, unsupported
+
+	header, err := ReadCartridgeHeader(path)
+	require.NoError(t, err)
+	require.Equal(t, "MBC5", header.MBCName())
+	require.False(t, header.MBCSupported())
+	require.Equal(t, 2, header.ROMBanks)
+
+	_, err = ParseCartridgeHeader(make([]byte, romHeaderEnd-1))
+	require.Error(t, err)
+}
+
+func TestCartridgeHeaderMBCName(t *testing.T) {
+	tests := []struct {
+		mbcType       byte
+		wantName      string
+		wantSupported bool
+	}{
+		{mbcType: 0x00, wantName: "ROM ONLY", wantSupported: true},
+		{mbcType: 0x03, wantName: "MBC1+RAM+BATTERY", wantSupported: true},
+		{mbcType: 0x10, wantName: "MBC3+TIMER+RAM+BATTERY", wantSupported: true},
+		{mbcType: 0x05, wantName: "MBC2", wantSupported: false},
+		{mbcType: 0x42, wantName: "UNKNOWN (0x42)", wantSupported: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.wantName, func(t *testing.T) {
+			header := CartridgeHeader{MBCType: tt.mbcType}
+			require.Equal(t, tt.wantName, header.MBCName())
+			require.Equal(t, tt.wantSupported, header.MBCSupported())
+		})
+	}
+}
+
+// writeChecksums updates the header and global checksums of the ROM at path
+func writeChecksums(t *testing.T, path string) {
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	data[romHeaderChecksum] = headerChecksum(data)
+	sum := globalChecksum(data)
+	data[romGlobalChecksum] = byte(sum >> 8)
+	data[romGlobalChecksum+1] = byte(sum)
+
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+}
+
+func TestLoadROMReportsChecksumMismatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt uint16
+		wantErr ChecksumError
+	}{
+		{name: "header", corrupt: romTitle, wantErr: ChecksumError{Checksum: "header", Expected: 0xE7, Computed: 0xE6}},
+		{name: "global", corrupt: 0x4000, wantErr: ChecksumError{Checksum: "global", Expected: 0x00E8, Computed: 0x00E9}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeBankedROM(t, 2, 0x00, 0x00)
+			writeChecksums(t, path)
+
+			data, err := ioutil.ReadFile(path)
+			require.NoError(t, err)
+			data[tt.corrupt]++
+			require.NoError(t, ioutil.WriteFile(path, data, 0644))
+
+			// Only reported when validating checksums
+			e := New()
+			require.NoError(t, e.Memory.LoadROM(path))
+			require.Equal(t, tt.name != "header", e.Cartridge().HeaderChecksumValid())
+			require.False(t, e.Cartridge().GlobalChecksumValid())
+
+			err = New(WithChecksumValidation()).Memory.LoadROM(path)
+			require.Equal(t, tt.wantErr, err)
+		})
+	}
+}
+
+func TestLoadROMAcceptsValidChecksums(t *testing.T) {
+	path := writeBankedROM(t, 2, 0x00, 0x00)
+	writeChecksums(t, path)
+
+	require.NoError(t, New(WithChecksumValidation()).Memory.LoadROM(path))
+}
+
+func TestHeaderChecksum(t *testing.T) {
+	header := make([]byte, romHeaderEnd)
+	require.Equal(t, uint8(0xE7), headerChecksum(header), "expected 25 bytes of 0x00 to sum to -25")
+
+	header[romTitle] = 0x01
+	require.Equal(t, uint8(0xE6), headerChecksum(header))
+}
+
+func TestMBC3SelectsROMBanks(t *testing.T) {
+	path := writeBankedROM(t, 64, 0x13, 0x03) // MBC3+RAM+BATTERY, 32KB RAM
+
+	r := newROM()
+	require.NoError(t, r.LoadROM(path))
+
+	tests := []struct {
+		bank     byte
+		wantBank byte
+	}{
+		{bank: 0x00, wantBank: 1}, // bank 0 is interpreted as bank 1
+		{bank: 0x01, wantBank: 1},
+		{bank: 0x1F, wantBank: 31},
+		{bank: 0x20, wantBank: 32}, // unusable with MBC1
+		{bank: 0x21, wantBank: 33},
+		{bank: 0x3F, wantBank: 63},
+	}
+	for _, tt := range tests {
+		r.Write8(0x2000, tt.bank)
+		require.Equal(t, tt.wantBank, r.Read8(0x4000), "unexpected bank mapped after selecting bank %#02x", tt.bank)
+	}
+	require.Equal(t, uint8(0), r.Read8(0x0000), "expected bank 0 to remain mapped at 0x0000")
+}
+
+func TestMBC1SelectsRAMBanksInRAMBankingMode(t *testing.T) {
+	path := writeBankedROM(t, 4, 0x03, 0x03) // MBC1+RAM+BATTERY, 32KB RAM
+
+	r := newROM()
+	require.NoError(t, r.LoadROM(path))
+	require.Len(t, r.ram, 4*bytes08k)
+
+	r.Write8(0x0000, 0x0A) // enable RAM
+	r.Write8(0x6000, 0x01) // RAM banking mode
+
+	r.Write8(0x4000, 0x00)
+	r.Write8(0xA000, 0x11)
+	r.Write8(0x4000, 0x02)
+	r.Write8(0xA000, 0x22)
+
+	r.Write8(0x4000, 0x00)
+	require.Equal(t, uint8(0x11), r.Read8(0xA000))
+	r.Write8(0x4000, 0x02)
+	require.Equal(t, uint8(0x22), r.Read8(0xA000))
+
+	r.Write8(0x6000, 0x00) // ROM banking mode always maps RAM bank 0
+	require.Equal(t, uint8(0x11), r.Read8(0xA000))
+}
+
+func TestMBC3SelectsRAMBanks(t *testing.T) {
+	path := writeBankedROM(t, 4, 0x13, 0x03) // MBC3+RAM+BATTERY, 32KB RAM
+
+	r := newROM()
+	require.NoError(t, r.LoadROM(path))
+
+	r.Write8(0xA000, 0x42) // ignored while RAM is disabled
+	require.Equal(t, uint8(0xFF), r.Read8(0xA000))
+
+	r.Write8(0x0000, 0x0A) // enable RAM
+	for bank := byte(0); bank < 4; bank++ {
+		r.Write8(0x4000, bank)
+		r.Write8(0xA000, bank+1)
+	}
+	for bank := byte(0); bank < 4; bank++ {
+		r.Write8(0x4000, bank)
+		require.Equal(t, bank+1, r.Read8(0xA000))
+	}
+}
+
+func TestMBC3LatchesRealTimeClock(t *testing.T) {
+	path := writeBankedROM(t, 4, 0x10, 0x03) // MBC3+TIMER+RAM+BATTERY
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := newROM()
+	r.now = func() time.Time { return now }
+	require.NoError(t, r.LoadROM(path))
+
+	latch := func() {
+		r.Write8(0x6000, 0x00)
+		r.Write8(0x6000, 0x01)
+	}
+	readRTC := func(register byte) byte {
+		r.Write8(0x4000, register)
+		return r.Read8(0xA000)
+	}
+
+	r.Write8(0x0000, 0x0A) // enable RAM and RTC
+
+	now = now.Add(300*24*time.Hour + 5*time.Hour + 4*time.Minute + 3*time.Second)
+	latch()
+	require.Equal(t, uint8(3), readRTC(0x08))
+	require.Equal(t, uint8(4), readRTC(0x09))
+	require.Equal(t, uint8(5), readRTC(0x0A))
+	require.Equal(t, uint8(300&0xFF), readRTC(0x0B))
+	require.Equal(t, uint8(0x01), readRTC(0x0C)) // day counter bit 8
+
+	// registers keep the latched time until latched again
+	now = now.Add(time.Second)
+	require.Equal(t, uint8(3), readRTC(0x08))
+	latch()
+	require.Equal(t, uint8(4), readRTC(0x08))
+
+	// halting the clock stops it from ticking
+	r.Write8(0x4000, 0x0C)
+	r.Write8(0xA000, 0x41) // halt, keep day counter bit 8
+	now = now.Add(time.Hour)
+	latch()
+	require.Equal(t, uint8(4), readRTC(0x08))
+	require.Equal(t, uint8(5), readRTC(0x0A))
+
+	// the day counter overflows after 512 days
+	r.Write8(0x4000, 0x0C)
+	r.Write8(0xA000, 0x01) // resume
+	now = now.Add(212 * 24 * time.Hour)
+	latch()
+	require.Equal(t, uint8(0), readRTC(0x0B))
+	require.Equal(t, uint8(0x80), readRTC(0x0C)) // day counter carry
+}
+
+func TestLoadROMBytes(t *testing.T) {
+	data := make([]byte, 4*bytes16k)
+	for bank := 0; bank < 4; bank++ {
+		data[bank*bytes16k] = byte(bank)
+	}
+	data[romMBCProtocol] = 0x01 // MBC1
+	data[romSize] = 0x01        // 64kb
+
+	r := newROM()
+	require.NoError(t, r.LoadROMBytes(data))
+	data[0] = 0xFF // the ROM is copied
+
+	require.Equal(t, byte(0x00), r.Read8(0x0000))
+	r.Write8(0x2000, 0x03) // select bank 3
+	require.Equal(t, byte(0x03), r.Read8(0x4000))
+
+	// The same validation applies as when loading from a file
+	require.EqualError(t, r.LoadROMBytes(data[:bytes32k]), "truncated ROM: header declares 65536 bytes (4 banks) but ROM contains 32768 bytes")
+	require.EqualError(t, r.LoadROMBytes(make([]byte, 100)), "invalid ROM size: expected ROM to contain at least 32768 bytes but contained 100 bytes")
+}
@@ -0,0 +1,79 @@
+package emulator
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ROMEntry describes a ROM file found by ScanROMs
+type ROMEntry struct {
+	Path  string
+	Title string
+
+	// MBCType is the cartridge type as declared in the header (0x0147)
+	MBCType byte
+
+	// CGB is true if the cartridge supports CGB functions
+	CGB bool
+}
+
+// ScanROMs walks dir (recursively) and returns an entry for every .gb/.gbc
+// file found
+//
+// Only the cartridge header is read from each file, so scanning is cheap even
+// for large directories. Files too small to contain a header are skipped.
+func ScanROMs(dir string) ([]ROMEntry, error) {
+	var entries []ROMEntry
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		ext := strings.ToLower(filepath.Ext(path))
+		if info.IsDir() || (ext != ".gb" && ext != ".gbc") {
+			return nil
+		}
+
+		header, err := readROMHeader(path)
+		if err != nil {
+			return err
+		} else if header == nil {
+			return nil // not a ROM
+		}
+
+		entries = append(entries, ROMEntry{
+			Path:    path,
+			Title:   headerTitle(header),
+			MBCType: header[romMBCProtocol],
+			CGB:     headerIsCGB(header),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// readROMHeader reads the first bytes of a ROM file up to and including the
+// cartridge header, or nil if the file is too small to contain a header
+func readROMHeader(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, romHeaderEnd)
+	if _, err := io.ReadFull(f, header); err == io.ErrUnexpectedEOF || err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return header, nil
+}
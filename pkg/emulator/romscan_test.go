@@ -0,0 +1,47 @@
+package emulator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanROMsReturnsParsedHeaders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gbemu-scan")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeHeaderROM := func(path string, title string, mbc byte, cgbFlag byte) {
+		data := make([]byte, bytes32k)
+		copy(data[romTitle:], title)
+		data[romCGBFlag] = cgbFlag
+		data[romMBCProtocol] = mbc
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, data, 0644))
+	}
+
+	writeHeaderROM(filepath.Join(dir, "a.gb"), "TETRIS", 0x00, 0x00)
+	writeHeaderROM(filepath.Join(dir, "nested", "b.gbc"), "POKEMON GOLD", 0x10, 0x80)
+	writeHeaderROM(filepath.Join(dir, "c.txt"), "NOT A ROM", 0x00, 0x00)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "d.gb"), []byte{0x00}, 0644)) // too small
+
+	entries, err := ScanROMs(dir)
+	require.NoError(t, err)
+	require.Equal(t, []ROMEntry{
+		{
+			Path:    filepath.Join(dir, "a.gb"),
+			Title:   "TETRIS",
+			MBCType: 0x00,
+			CGB:     false,
+		},
+		{
+			Path:    filepath.Join(dir, "nested", "b.gbc"),
+			Title:   "POKEMON GOLD",
+			MBCType: 0x10,
+			CGB:     true,
+		},
+	}, entries)
+}
@@ -0,0 +1,120 @@
+package emulator
+
+import "time"
+
+const (
+	rtcSeconds  = iota // 0-59
+	rtcMinutes         // 0-59
+	rtcHours           // 0-23
+	rtcDaysLow         // lower 8 bits of the day counter
+	rtcDaysHigh        // bit 0 = day counter bit 8, bit 6 = halt, bit 7 = day counter carry
+
+	// rtcMaxDays is the number of days after which the day counter overflows
+	rtcMaxDays = 512
+
+	secondsPerDay = 24 * 60 * 60
+)
+
+// rtc is the real time clock found in MBC3 cartridges
+//
+// The clock ticks from wall time while the emulator runs. The program reads
+// the clock by latching the current time into the RTC registers, which are
+// then mapped into 0xA000-0xBFFF.
+type rtc struct {
+	// now returns the current wall time
+	now func() time.Time
+
+	// last is the wall time the clock was last advanced to
+	last time.Time
+
+	// seconds contains the time counted by the clock (including days)
+	seconds uint64
+
+	halted   bool
+	dayCarry bool
+
+	// latched contains the registers as they were when the clock was last latched
+	latched [5]byte
+
+	// latchPrepared is true if 0x00 was the last value written to the latch
+	// register, such that a subsequent write of 0x01 latches the clock
+	latchPrepared bool
+}
+
+func newRTC(now func() time.Time) *rtc {
+	return &rtc{
+		now:  now,
+		last: now(),
+	}
+}
+
+// WriteLatch handles writes to the latch clock data register (0x6000-0x7FFF)
+//
+// Writing 0x00 followed by 0x01 latches the current time into the registers.
+func (c *rtc) WriteLatch(v byte) {
+	if c.latchPrepared && v == 0x01 {
+		c.latched = c.registers()
+	}
+	c.latchPrepared = v == 0x00
+}
+
+// Read8 returns the latched value of register r
+func (c *rtc) Read8(r uint8) byte {
+	return c.latched[r]
+}
+
+// Write8 sets register r of the running clock
+func (c *rtc) Write8(r uint8, v byte) {
+	c.advance()
+
+	registers := c.registers()
+	registers[r] = v
+
+	days := uint64(registers[rtcDaysLow]) | uint64(registers[rtcDaysHigh]&0x01)<<8
+	c.seconds = days*secondsPerDay +
+		uint64(registers[rtcHours]%24)*60*60 +
+		uint64(registers[rtcMinutes]%60)*60 +
+		uint64(registers[rtcSeconds]%60)
+	c.halted = readBitN(registers[rtcDaysHigh], 6)
+	c.dayCarry = readBitN(registers[rtcDaysHigh], 7)
+	c.latched[r] = registers[r]
+}
+
+// advance moves the clock forward by the (whole) seconds passed since it was
+// last advanced
+func (c *rtc) advance() {
+	now := c.now()
+	elapsed := now.Sub(c.last) / time.Second
+	if elapsed <= 0 {
+		return
+	}
+	c.last = c.last.Add(elapsed * time.Second)
+
+	if c.halted {
+		return
+	}
+
+	c.seconds += uint64(elapsed)
+	if c.seconds >= rtcMaxDays*secondsPerDay {
+		c.seconds %= rtcMaxDays * secondsPerDay
+		c.dayCarry = true
+	}
+}
+
+// registers returns the current time of the clock as register values
+func (c *rtc) registers() [5]byte {
+	c.advance()
+
+	days := c.seconds / secondsPerDay
+	daysHigh := byte(days>>8) & 0x01
+	daysHigh = writeBitN(daysHigh, 6, c.halted)
+	daysHigh = writeBitN(daysHigh, 7, c.dayCarry)
+
+	return [5]byte{
+		rtcSeconds:  byte(c.seconds % 60),
+		rtcMinutes:  byte(c.seconds / 60 % 60),
+		rtcHours:    byte(c.seconds / 60 / 60 % 24),
+		rtcDaysLow:  byte(days),
+		rtcDaysHigh: daysHigh,
+	}
+}
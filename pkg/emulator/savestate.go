@@ -0,0 +1,505 @@
+package emulator
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"time"
+)
+
+// saveStateVersion is the version of the save state format written by
+// SaveState. Bump the version whenever the format changes, and add a migration
+// (see saveStateMigrations) if older save states can still be loaded.
+//
+// Version 1 only contained the CPU, and can't be loaded. Version 2 predates
+// the timer being driven by a single internal counter (which changed the
+// meaning of the timer state), the bit-level serial transfer, and sound
+// channels 2-4, and can't be loaded either.
+const saveStateVersion uint32 = 3
+
+// saveStateMagic identifies a file as a save state
+var saveStateMagic = [4]byte{'G', 'B', 'S', 'S'}
+
+// saveStateMigrations upgrades a save state of a given (older) version to the
+// next version
+//
+// Fields added to the format are zero-filled when loading older save states,
+// so a migration is only required if zero values are not appropriate.
+var saveStateMigrations = map[uint32]func(s *saveState){}
+
+// UnsupportedSaveStateVersion is returned by LoadState if the save state was
+// written in a format this version of the emulator can't load
+type UnsupportedSaveStateVersion struct {
+	Version uint32
+}
+
+func (e UnsupportedSaveStateVersion) Error() string {
+	return fmt.Sprintf("unsupported save state version %d (supports up to version %d)", e.Version, saveStateVersion)
+}
+
+// saveStateHeader precedes the (gob encoded) save state
+type saveStateHeader struct {
+	Magic   [4]byte
+	Version uint32
+}
+
+// saveState contains the state of the emulator
+//
+// The ROM itself is not part of the state, so a save state can only be
+// restored into an emulator with the same ROM loaded.
+type saveState struct {
+	CPU       cpuState
+	Memory    memoryState
+	Cartridge cartridgeState
+	Video     videoState
+	Timer     timerState
+	Serial    serialState
+	Interrupt interruptState
+	Joypad    joypadState
+	Sound     soundState
+	DMA       dmaState
+}
+
+type cpuState struct {
+	Registers      []byte
+	ProgramCounter uint16
+	PowerOn        bool
+	LowPowerMode   bool
+	Stopped        bool
+	HaltBug        bool
+	Interrupts     imeState
+	Cycles         uint64
+}
+
+type memoryState struct {
+	WRAM0           []byte
+	WRAM1           []byte
+	HRAM            []byte
+	IsBootROMLoaded bool
+}
+
+type cartridgeState struct {
+	RAM            []byte
+	BankROMLow     byte
+	BankROMHighRAM byte
+	BankRAMMode    bool
+	BankRAMRTC     byte
+	RAMEnabled     bool
+	RTC            *rtcState
+}
+
+type rtcState struct {
+	Last          time.Time
+	Seconds       uint64
+	Halted        bool
+	DayCarry      bool
+	Latched       [5]byte
+	LatchPrepared bool
+}
+
+type videoState struct {
+	Registers      []byte
+	VRAM           []byte
+	OAM            []byte
+	VRAMAccessible bool
+	OAMAccessible  bool
+	NextCycle      uint
+	ScreenY        uint8
+	ScreenX        uint8
+	WindowY        uint8
+	WindowX        uint8
+	PlatterBG      byte
+	PlatterSprite0 byte
+	PlatterSprite1 byte
+	WindowLine     uint8
+	WindowRendered bool
+	StatLine       bool
+	Frame          Frame
+}
+
+type timerState struct {
+	Registers     []byte
+	Counter       uint16
+	ReloadPending bool
+}
+
+type serialState struct {
+	Registers     []byte
+	TransferTicks int
+	BitsShifted   uint8
+	Outgoing      byte
+	Incoming      byte
+}
+
+type interruptState struct {
+	InterruptFlag    byte
+	InterruptEnabled byte
+
+	// Pending contains the pending state of every interrupt source (INT 40 -
+	// INT 60), which is forwarded to InterruptFlag on the next cycle
+	Pending []bool
+}
+
+type joypadState struct {
+	Register byte
+}
+
+type soundState struct {
+	Registers           []byte
+	PowerOn             bool
+	FrameSequencerTicks int
+	FrameSequencerStep  uint8
+	Channel1            squareChannelState
+	Channel2            squareChannelState
+	Channel3            waveChannelState
+	Channel4            noiseChannelState
+}
+
+type squareChannelState struct {
+	Enabled        bool
+	FrequencyTimer int
+	DutyStep       uint8
+	LengthCounter  int
+	Volume         uint8
+	EnvelopeTimer  uint8
+	SweepEnabled   bool
+	SweepTimer     uint8
+	SweepFrequency uint16
+}
+
+type waveChannelState struct {
+	Enabled        bool
+	FrequencyTimer int
+	Position       uint8
+	LengthCounter  int
+}
+
+type noiseChannelState struct {
+	Enabled        bool
+	FrequencyTimer int
+	LFSR           uint16
+	LengthCounter  int
+	Volume         uint8
+	EnvelopeTimer  uint8
+}
+
+type dmaState struct {
+	Register byte
+	Active   bool
+	Source   uint16
+	Progress uint16
+}
+
+// SaveState writes the state of the emulator to w, such that it can be
+// restored using LoadState
+func (e *Emulator) SaveState(w io.Writer) error {
+	return writeSaveState(w, saveStateVersion, e.saveState())
+}
+
+// writeSaveState writes the header (marked with version) followed by state to w
+func writeSaveState(w io.Writer, version uint32, state saveState) error {
+	header := saveStateHeader{
+		Magic:   saveStateMagic,
+		Version: version,
+	}
+	if err := binary.Write(w, binary.BigEndian, header); err != nil {
+		return err
+	}
+
+	return gob.NewEncoder(w).Encode(state)
+}
+
+// LoadState restores the state of the emulator previously written by SaveState
+//
+// Returns UnsupportedSaveStateVersion if the save state was written by a newer
+// (or otherwise incompatible) version of the emulator.
+func (e *Emulator) LoadState(r io.Reader) error {
+	var header saveStateHeader
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return fmt.Errorf("invalid save state: %w", err)
+	}
+	if header.Magic != saveStateMagic {
+		return fmt.Errorf("invalid save state: unexpected header %q", header.Magic[:])
+	}
+	if header.Version == 0 || header.Version > saveStateVersion {
+		return UnsupportedSaveStateVersion{Version: header.Version}
+	}
+
+	var state saveState
+	if err := gob.NewDecoder(r).Decode(&state); err != nil {
+		return fmt.Errorf("invalid save state: %w", err)
+	}
+
+	for version := header.Version; version < saveStateVersion; version++ {
+		migrate, ok := saveStateMigrations[version]
+		if !ok {
+			return UnsupportedSaveStateVersion{Version: header.Version}
+		}
+		migrate(&state)
+	}
+
+	e.loadState(state)
+	return nil
+}
+
+func (e *Emulator) saveState() saveState {
+	cartridge := e.Memory.rom
+	video := e.Video
+	sound := e.Memory.sound
+	channel3 := sound.channel3
+	channel4 := sound.channel4
+	dma := e.Memory.dma
+
+	// the serial port may be shifted by a linked emulator while running
+	e.Serial.mutex.Lock()
+	defer e.Serial.mutex.Unlock()
+
+	state := saveState{
+		CPU: cpuState{
+			Registers:      cloneBytes(e.CPU.Registers.Data),
+			ProgramCounter: e.CPU.ProgramCounter,
+			PowerOn:        e.CPU.PowerOn,
+			LowPowerMode:   e.CPU.lowPowerMode,
+			Stopped:        e.CPU.stopped,
+			HaltBug:        e.CPU.haltBug,
+			Interrupts:     e.CPU.Interrupts,
+			Cycles:         e.CPU.cycles,
+		},
+		Memory: memoryState{
+			WRAM0:           cloneBytes(e.Memory.wRAM0.data),
+			WRAM1:           cloneBytes(e.Memory.wRAM1.data),
+			HRAM:            cloneBytes(e.Memory.ffPage.hram.data),
+			IsBootROMLoaded: e.Memory.IsBootROMLoaded,
+		},
+		Cartridge: cartridgeState{
+			RAM:            cloneBytes(cartridge.ram),
+			BankROMLow:     cartridge.bankROMLow,
+			BankROMHighRAM: cartridge.bankROMHighRAM,
+			BankRAMMode:    cartridge.bankRAMMode,
+			BankRAMRTC:     cartridge.bankRAMRTC,
+			RAMEnabled:     cartridge.ramEnabled,
+		},
+		Video: videoState{
+			Registers:      cloneBytes(video.registers),
+			VRAM:           cloneBytes(video.vram),
+			OAM:            cloneBytes(video.oam),
+			VRAMAccessible: video.vramAccessible,
+			OAMAccessible:  video.oamAccessible,
+			NextCycle:      video.nextCycle,
+			ScreenY:        video.screenY,
+			ScreenX:        video.screenX,
+			WindowY:        video.windowY,
+			WindowX:        video.windowX,
+			PlatterBG:      video.platterBG,
+			PlatterSprite0: video.platterSprite0,
+			PlatterSprite1: video.platterSprite1,
+			WindowLine:     video.windowLine,
+			WindowRendered: video.windowRendered,
+			StatLine:       video.statLine,
+			Frame:          cloneFrame(video.Frame),
+		},
+		Timer: timerState{
+			Registers:     cloneBytes(e.Timer.registers),
+			Counter:       e.Timer.counter,
+			ReloadPending: e.Timer.reloadPending,
+		},
+		Serial: serialState{
+			Registers:     cloneBytes(e.Serial.registers),
+			TransferTicks: e.Serial.transferTicks,
+			BitsShifted:   e.Serial.bitsShifted,
+			Outgoing:      e.Serial.outgoing,
+			Incoming:      e.Serial.incoming,
+		},
+		Interrupt: interruptState{
+			InterruptFlag:    e.Interrupt.interruptFlag,
+			InterruptEnabled: e.Interrupt.interruptEnabled,
+		},
+		Joypad: joypadState{
+			Register: e.Joypad.register,
+		},
+		Sound: soundState{
+			Registers:           cloneBytes(sound.registers),
+			PowerOn:             sound.powerOn,
+			FrameSequencerTicks: sound.frameSequencerTicks,
+			FrameSequencerStep:  sound.frameSequencerStep,
+			Channel1:            saveSquareChannel(sound.channel1),
+			Channel2:            saveSquareChannel(sound.channel2),
+			Channel3: waveChannelState{
+				Enabled:        channel3.enabled,
+				FrequencyTimer: channel3.frequencyTimer,
+				Position:       channel3.position,
+				LengthCounter:  channel3.lengthCounter,
+			},
+			Channel4: noiseChannelState{
+				Enabled:        channel4.enabled,
+				FrequencyTimer: channel4.frequencyTimer,
+				LFSR:           channel4.lfsr,
+				LengthCounter:  channel4.lengthCounter,
+				Volume:         channel4.volume,
+				EnvelopeTimer:  channel4.envelopeTimer,
+			},
+		},
+		DMA: dmaState{
+			Register: dma.register,
+			Active:   dma.active,
+			Source:   dma.source,
+			Progress: dma.progress,
+		},
+	}
+
+	if clock := cartridge.rtc; clock != nil {
+		state.Cartridge.RTC = &rtcState{
+			Last:          clock.last,
+			Seconds:       clock.seconds,
+			Halted:        clock.halted,
+			DayCarry:      clock.dayCarry,
+			Latched:       clock.latched,
+			LatchPrepared: clock.latchPrepared,
+		}
+	}
+
+	for _, source := range e.Interrupt.interruptSources {
+		state.Interrupt.Pending = append(state.Interrupt.Pending, source != nil && source.pending)
+	}
+
+	return state
+}
+
+func (e *Emulator) loadState(state saveState) {
+	copy(e.CPU.Registers.Data, state.CPU.Registers)
+	e.CPU.ProgramCounter = state.CPU.ProgramCounter
+	e.CPU.PowerOn = state.CPU.PowerOn
+	e.CPU.lowPowerMode = state.CPU.LowPowerMode
+	e.CPU.stopped = state.CPU.Stopped
+	e.CPU.haltBug = state.CPU.HaltBug
+	e.CPU.Interrupts = state.CPU.Interrupts
+	e.CPU.cycles = state.CPU.Cycles
+
+	copy(e.Memory.wRAM0.data, state.Memory.WRAM0)
+	copy(e.Memory.wRAM1.data, state.Memory.WRAM1)
+	copy(e.Memory.ffPage.hram.data, state.Memory.HRAM)
+	if e.Memory.IsBootROMLoaded && !state.Memory.IsBootROMLoaded {
+		e.Memory.UnloadBootROM()
+	}
+
+	cartridge := e.Memory.rom
+	copy(cartridge.ram, state.Cartridge.RAM)
+	cartridge.bankROMLow = state.Cartridge.BankROMLow
+	cartridge.bankROMHighRAM = state.Cartridge.BankROMHighRAM
+	cartridge.bankRAMMode = state.Cartridge.BankRAMMode
+	cartridge.bankRAMRTC = state.Cartridge.BankRAMRTC
+	cartridge.ramEnabled = state.Cartridge.RAMEnabled
+	if clock := state.Cartridge.RTC; clock != nil && cartridge.rtc != nil {
+		cartridge.rtc.last = clock.Last
+		cartridge.rtc.seconds = clock.Seconds
+		cartridge.rtc.halted = clock.Halted
+		cartridge.rtc.dayCarry = clock.DayCarry
+		cartridge.rtc.latched = clock.Latched
+		cartridge.rtc.latchPrepared = clock.LatchPrepared
+	}
+
+	video := e.Video
+	copy(video.registers, state.Video.Registers)
+	copy(video.vram, state.Video.VRAM)
+	video.invalidateTileRows()
+	copy(video.oam, state.Video.OAM)
+	video.lineSpritesFound = false
+	video.vramAccessible = state.Video.VRAMAccessible
+	video.oamAccessible = state.Video.OAMAccessible
+	video.nextCycle = state.Video.NextCycle
+	video.screenY = state.Video.ScreenY
+	video.screenX = state.Video.ScreenX
+	video.windowY = state.Video.WindowY
+	video.windowX = state.Video.WindowX
+	video.platterBG = state.Video.PlatterBG
+	video.platterSprite0 = state.Video.PlatterSprite0
+	video.platterSprite1 = state.Video.PlatterSprite1
+	video.windowLine = state.Video.WindowLine
+	video.windowRendered = state.Video.WindowRendered
+	video.statLine = state.Video.StatLine
+	for row := range state.Video.Frame {
+		copy(video.Frame[row], state.Video.Frame[row])
+	}
+	video.markAllRowsDirty()
+
+	copy(e.Timer.registers, state.Timer.Registers)
+	e.Timer.counter = state.Timer.Counter
+	e.Timer.reloadPending = state.Timer.ReloadPending
+
+	e.Serial.mutex.Lock()
+	copy(e.Serial.registers, state.Serial.Registers)
+	e.Serial.transferTicks = state.Serial.TransferTicks
+	e.Serial.bitsShifted = state.Serial.BitsShifted
+	e.Serial.outgoing = state.Serial.Outgoing
+	e.Serial.incoming = state.Serial.Incoming
+	e.Serial.mutex.Unlock()
+
+	e.Interrupt.interruptFlag = state.Interrupt.InterruptFlag
+	e.Interrupt.interruptEnabled = state.Interrupt.InterruptEnabled
+	for i, pending := range state.Interrupt.Pending {
+		if i < len(e.Interrupt.interruptSources) && e.Interrupt.interruptSources[i] != nil {
+			e.Interrupt.interruptSources[i].pending = pending
+		}
+	}
+
+	e.Joypad.Write8(registerFF00, state.Joypad.Register)
+
+	sound := e.Memory.sound
+	copy(sound.registers, state.Sound.Registers)
+	sound.powerOn = state.Sound.PowerOn
+	sound.frameSequencerTicks = state.Sound.FrameSequencerTicks
+	sound.frameSequencerStep = state.Sound.FrameSequencerStep
+	loadSquareChannel(sound.channel1, state.Sound.Channel1)
+	loadSquareChannel(sound.channel2, state.Sound.Channel2)
+	channel3 := sound.channel3
+	channel3.enabled = state.Sound.Channel3.Enabled
+	channel3.frequencyTimer = state.Sound.Channel3.FrequencyTimer
+	channel3.position = state.Sound.Channel3.Position
+	channel3.lengthCounter = state.Sound.Channel3.LengthCounter
+	channel4 := sound.channel4
+	channel4.enabled = state.Sound.Channel4.Enabled
+	channel4.frequencyTimer = state.Sound.Channel4.FrequencyTimer
+	channel4.lfsr = state.Sound.Channel4.LFSR
+	channel4.lengthCounter = state.Sound.Channel4.LengthCounter
+	channel4.volume = state.Sound.Channel4.Volume
+	channel4.envelopeTimer = state.Sound.Channel4.EnvelopeTimer
+
+	dma := e.Memory.dma
+	dma.register = state.DMA.Register
+	dma.active = state.DMA.Active
+	dma.source = state.DMA.Source
+	dma.progress = state.DMA.Progress
+	video.dmaActive = state.DMA.Active
+}
+
+// cloneBytes returns a copy of data
+func cloneBytes(data []byte) []byte {
+	return append([]byte{}, data...)
+}
+
+func saveSquareChannel(c *squareChannel) squareChannelState {
+	return squareChannelState{
+		Enabled:        c.enabled,
+		FrequencyTimer: c.frequencyTimer,
+		DutyStep:       c.dutyStep,
+		LengthCounter:  c.lengthCounter,
+		Volume:         c.volume,
+		EnvelopeTimer:  c.envelopeTimer,
+		SweepEnabled:   c.sweepEnabled,
+		SweepTimer:     c.sweepTimer,
+		SweepFrequency: c.sweepFrequency,
+	}
+}
+
+func loadSquareChannel(c *squareChannel, state squareChannelState) {
+	c.enabled = state.Enabled
+	c.frequencyTimer = state.FrequencyTimer
+	c.dutyStep = state.DutyStep
+	c.lengthCounter = state.LengthCounter
+	c.volume = state.Volume
+	c.envelopeTimer = state.EnvelopeTimer
+	c.sweepEnabled = state.SweepEnabled
+	c.sweepTimer = state.SweepTimer
+	c.sweepFrequency = state.SweepFrequency
+}
@@ -0,0 +1,135 @@
+package emulator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveStateRoundTripRestoresCPU(t *testing.T) {
+	e := New()
+	e.CPU.ProgramCounter = 0xC123
+	e.CPU.Registers.Write16(registerBC, 0xBEEF)
+	e.CPU.Registers.Write16(registerSP, 0xDFF0)
+	e.CPU.Interrupts = interruptsEnabled
+
+	buffer := bytes.Buffer{}
+	require.NoError(t, e.SaveState(&buffer))
+
+	restored := New()
+	require.NoError(t, restored.LoadState(&buffer))
+	require.Equal(t, uint16(0xC123), restored.CPU.ProgramCounter)
+	require.Equal(t, uint16(0xBEEF), restored.CPU.Registers.Read16(registerBC))
+	require.Equal(t, uint16(0xDFF0), restored.CPU.Registers.Read16(registerSP))
+	require.Equal(t, interruptsEnabled, restored.CPU.Interrupts)
+}
+
+func TestLoadStateRejectsNewerVersion(t *testing.T) {
+	e := New()
+
+	buffer := bytes.Buffer{}
+	require.NoError(t, writeSaveState(&buffer, saveStateVersion+1, e.saveState()))
+
+	err := e.LoadState(&buffer)
+	var unsupported UnsupportedSaveStateVersion
+	require.True(t, errors.As(err, &unsupported), "expected UnsupportedSaveStateVersion, got %v", err)
+	require.Equal(t, saveStateVersion+1, unsupported.Version)
+}
+
+func TestLoadStateRejectsInvalidHeader(t *testing.T) {
+	e := New()
+
+	err := e.LoadState(bytes.NewReader([]byte("not a save state")))
+	require.EqualError(t, err, `invalid save state: unexpected header "not "`)
+}
+
+func TestSaveStateRoundTripRestoresEntireState(t *testing.T) {
+	path := writeBankedROM(t, 4, 0x02, 0x02) // MBC1+RAM, 8KB RAM
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	copy(data[0x0100:], []byte{
+		0xC3, 0x50, 0x01, // JP $0150
+	})
+	copy(data[0x0150:], []byte{
+		0x3E, 0x0A, // LD A,$0A
+		0xEA, 0x00, 0x00, // LD ($0000),A ; enable RAM
+		0x3E, 0x02, // LD A,2
+		0xEA, 0x00, 0x20, // LD ($2000),A ; select ROM bank 2
+		0x21, 0x00, 0xC0, // LD HL,$C000
+		0x3C,             // INC A
+		0x22,             // LD (HL+),A
+		0xEA, 0x00, 0xA0, // LD ($A000),A
+		0xCB, 0xA4, // RES 4,H ; wrap around to $C000
+		0x18, 0xF7, // JR -9
+	})
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+
+	e := New(WithSpeedUncapped())
+	require.NoError(t, e.Load(path, ""))
+	_, err = e.RunFrames(context.Background(), 2)
+	require.NoError(t, err)
+
+	saved := bytes.Buffer{}
+	require.NoError(t, e.SaveState(&saved))
+	wram := e.PeekRange(0xC000, 0x2000)
+	registers := e.RegisterState()
+
+	// Progress the original emulator, which should match a restored emulator
+	// progressed by the same amount
+	expectedFrame, err := e.RunFrames(context.Background(), 1)
+	require.NoError(t, err)
+
+	e.Memory.Write8(0xC000, 0xAB)
+	e.Memory.Write8(0x2000, 0x03)
+
+	require.NoError(t, e.LoadState(bytes.NewReader(saved.Bytes())))
+	require.Equal(t, wram, e.PeekRange(0xC000, 0x2000))
+	require.Equal(t, registers, e.RegisterState())
+	require.Equal(t, uint8(2), e.MBCState().ROMBank)
+
+	restored := bytes.Buffer{}
+	require.NoError(t, e.SaveState(&restored))
+	require.Equal(t, saved.Bytes(), restored.Bytes())
+
+	// A different emulator with the same ROM continues identically
+	other := New(WithSpeedUncapped())
+	require.NoError(t, other.Load(path, ""))
+	require.NoError(t, other.LoadState(bytes.NewReader(saved.Bytes())))
+
+	frame, err := other.RunFrames(context.Background(), 1)
+	require.NoError(t, err)
+	require.Equal(t, expectedFrame, frame)
+
+	_, err = e.RunFrames(context.Background(), 1)
+	require.NoError(t, err)
+	expected, actual := bytes.Buffer{}, bytes.Buffer{}
+	require.NoError(t, e.SaveState(&expected))
+	require.NoError(t, other.SaveState(&actual))
+	require.Equal(t, expected.Bytes(), actual.Bytes())
+}
+
+func TestLoadStateRejectsOlderVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		version uint32
+	}{
+		{name: "CPU only", version: 1},
+		{name: "timer state before the internal counter", version: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := New()
+
+			buffer := bytes.Buffer{}
+			require.NoError(t, writeSaveState(&buffer, tt.version, e.saveState()))
+
+			err := e.LoadState(&buffer)
+			require.Equal(t, UnsupportedSaveStateVersion{Version: tt.version}, err)
+		})
+	}
+}
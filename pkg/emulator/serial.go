@@ -1,11 +1,22 @@
 package emulator
 
+import "sync"
+
 type serialRegister uint16
 
 const (
 	offsetSerialRegisters uint16 = 0xFF01
 )
 
+const (
+	// serialClockNormal is the frequency (in Hz) of the internal serial clock
+	serialClockNormal = 8192
+
+	// serialClockFast is the frequency (in Hz) of the internal serial clock when
+	// the clock speed bit is set (CGB only)
+	serialClockFast = 262144
+)
+
 const (
 	// Serial transfer data (read/write)
 	registerFF01 serialRegister = 0xFF01
@@ -20,29 +31,66 @@ const (
 
 type SerialDataCallback func(data uint8)
 
+// SerialReceiveCallback is called when a byte transfer starts, and returns
+// the byte received from the external device
+type SerialReceiveCallback func() uint8
+
 // serialController handles data transfers over the serial port
 //
-// Currently, does not support connecting an external device, thus:
-// a) A transfer will only happen if the device initiates it by setting bit 7 in 0xFF02
-// b) The incoming byte will always be 0xFF
+// Only one of the connected devices drives the clock (bit 0 of 0xFF02), thus:
+// a) A transfer is started by the device using the internal clock once it sets bit 7 in 0xFF02
+// b) A device using the external clock only takes part in a transfer started by the other device if bit 7 in 0xFF02 is set
+// c) The incoming byte is 0xFF if no device is connected, unless provided by ReceiveCallback
 type serialController struct {
+	// mutex guards the registers and transfer state, as a device connected
+	// through a link (see NewSerialLinkPipe) shifts bits in from the goroutine
+	// running the other emulator. Shared by both ends of a link pipe.
+	mutex *sync.Mutex
+
 	// registers contains control and data registers mapped to 0xFF01 - 0xFF02
 	registers []byte
 
 	// transferTicks represent the current number of ticks spent on transferring the
-	// current byte. Each transfer takes 1000 cycles.
+	// current bit. See transferCycles for the duration of a transfer.
 	transferTicks int
 
+	// bitsShifted is the number of bits of the current byte shifted so far
+	bitsShifted uint8
+
+	// outgoing is the byte being transferred out, as it was before shifting
+	outgoing byte
+
+	// incoming is the byte being transferred in, when received all at once from
+	// Link or ReceiveCallback
+	incoming byte
+
+	// transferCompleted is set when the last bit of a transfer is shifted, and
+	// the transfer is completed (see completeTransfer) by Cycle on the goroutine
+	// running the emulator
+	transferCompleted bool
+
 	// Interrupt is true if the serial port wants to trigger the INT 58 interrupt
 	Interrupt *interruptSource
 
 	// Callback is called (if set) on every byte that is transferred over the
 	// serial port.
 	Callback SerialDataCallback
+
+	// ReceiveCallback is called (if set) to read the incoming byte on every
+	// transfer over the serial port.
+	ReceiveCallback SerialReceiveCallback
+
+	// Link is the external device (if any) connected to the serial port. Takes
+	// precedence over ReceiveCallback.
+	Link SerialLink
+
+	// memory reports accesses to addresses not mapped to a register
+	memory *memory
 }
 
 func newSerialController() *serialController {
 	return &serialController{
+		mutex:     &sync.Mutex{},
 		registers: make([]byte, 0xFF02-0xFF01+1),
 		Interrupt: newInterruptSource(),
 	}
@@ -50,6 +98,9 @@ func newSerialController() *serialController {
 
 // Read8 is exposed in the address space, and may be read by the program
 func (s *serialController) Read8(address uint16) byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	switch address {
 	case 0xFF01:
 		return s.readRegister(registerFF01)
@@ -57,49 +108,159 @@ func (s *serialController) Read8(address uint16) byte {
 		return s.readRegister(registerFF02)
 	}
 
-	notImplemented("read of unimplemented SERIAL register at %#4x", address)
-	return byte(0)
+	return s.memory.unmapped(address, false)
 }
 
 // Write8 is exposed in the address space, and may be written to by the program
 func (s *serialController) Write8(address uint16, v byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	switch address {
 	case 0xFF01:
 		s.writeRegister(registerFF01, v)
 	case 0xFF02:
 		s.writeRegister(registerFF02, v)
+		if readBitN(v, 7) {
+			// (re)starting a transfer starts shifting from the first bit, even
+			// if a previous transfer was aborted mid-byte
+			s.bitsShifted = 0
+			s.transferTicks = 0
+		}
 	default:
-		notImplemented("write of unimplemented SERIAL register at %#4x", address)
+		s.memory.unmapped(address, true)
 	}
 }
 
 // Cycle transfers bytes on the serial port if requested
+//
+// Bytes are transferred a bit at a time, most significant bit first. On every
+// serial clock the outgoing bit is shifted out of 0xFF01, and the incoming bit
+// shifted in.
 func (s *serialController) Cycle() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.transferCompleted {
+		s.completeTransfer() // shifted in by the connected device
+	}
+
 	control := s.readRegister(0xFF02)
 	isMaster := readBitN(control, 0)
 	transferRequested := readBitN(control, 7)
 
 	if !isMaster || !transferRequested {
-		// - Do nothing if this device is not the master device, as there is no external device
-		//   to communicate with
+		// - Do nothing if this device is not the master device, as the external device
+		//   drives the transfer (see externalShift)
 		// - Do nothing if a transfer has not been requested, as the local device (as master)
 		//   should be initiating the transfer
 		return
 	}
 
 	s.transferTicks++
+	if s.transferTicks < s.transferCycles()/8 {
+		return
+	}
+	s.transferTicks = 0
 
-	transferDone := s.transferTicks >= 1000
-	if transferDone {
-		if s.Callback != nil {
-			s.Callback(s.readRegister(0xFF01))
-		}
+	if s.bitsShifted == 0 {
+		s.startTransfer()
+	}
+
+	out := readBitN(s.readRegister(0xFF01), 7)
+	in := readBitN(s.incoming, 7-s.bitsShifted)
+	if link, ok := s.Link.(SerialBitLink); ok {
+		in = link.ExchangeBit(out)
+	}
+
+	s.shift(in)
+	if s.transferCompleted {
+		s.completeTransfer()
+	}
+}
+
+// startTransfer is called before shifting the first bit of a transfer
+func (s *serialController) startTransfer() {
+	s.outgoing = s.readRegister(0xFF01)
+
+	if _, ok := s.Link.(SerialBitLink); ok {
+		return // incoming bits are exchanged as they are shifted
+	}
+
+	s.incoming = 0xFF // no device connected
+	if s.Link != nil {
+		s.incoming = s.Link.Exchange(s.outgoing)
+	} else if s.ReceiveCallback != nil {
+		s.incoming = s.ReceiveCallback()
+	}
+}
+
+// transferCycles returns the number of machine cycles it takes to transfer a
+// byte (8 bits, one bit per serial clock) using the internal clock
+//
+// The clock speed bit is only present on the CGB, but is honored regardless
+// as DMG programs are not expected to set it.
+func (s *serialController) transferCycles() int {
+	clock := serialClockNormal
+	if readBitN(s.readRegister(registerFF02), 1) {
+		clock = serialClockFast
+	}
+
+	return 8 * machineCyclesPerSecond / clock
+}
+
+// externalShift is called when the connected device, driving the clock,
+// shifts a bit in to this device. Returns the outgoing bit, which is always
+// 1 if this device is not waiting for a transfer.
+//
+// Must be called with mutex held, which a link pipe shares with the device
+// driving the clock.
+func (s *serialController) externalShift(in bool) bool {
+	control := s.readRegister(0xFF02)
+	isMaster := readBitN(control, 0)
+	transferRequested := readBitN(control, 7)
+
+	if isMaster || !transferRequested {
+		return true
+	}
+
+	if s.bitsShifted == 0 {
+		s.outgoing = s.readRegister(0xFF01)
+	}
+
+	out := readBitN(s.readRegister(0xFF01), 7)
+	s.shift(in)
+	return out
+}
+
+// shift shifts the incoming bit into 0xFF01, and marks the transfer as
+// completed once all 8 bits are shifted
+func (s *serialController) shift(in bool) {
+	data := s.readRegister(0xFF01) << 1
+	if in {
+		data |= 0x01
+	}
+	s.writeRegister(0xFF01, data)
+
+	s.bitsShifted++
+	if s.bitsShifted < 8 {
+		return
+	}
+	s.bitsShifted = 0
+
+	s.writeRegister(0xFF02, writeBitN(s.readRegister(0xFF02), 7, false))
+	s.transferCompleted = true
+}
+
+// completeTransfer reports the transferred byte to Callback and triggers the
+// interrupt
+func (s *serialController) completeTransfer() {
+	s.transferCompleted = false
 
-		s.transferTicks = 0
-		s.writeRegister(0xFF01, 0xFF)
-		s.writeRegister(0xFF02, writeBitN(control, 7, false))
-		s.Interrupt.Set()
+	if s.Callback != nil {
+		s.Callback(s.outgoing)
 	}
+	s.Interrupt.Set()
 }
 
 func (s *serialController) readRegister(r serialRegister) byte {
@@ -10,7 +10,7 @@ func TestSerialCycleTriggersInterruptWhenByteIsTransferred(t *testing.T) {
 	serial := newSerialController()
 	serial.Write8(0xFF02, 0x81) // 01000001 - set transfer start flag and set master mode
 
-	for i := 0; i < 1000; i++ {
+	for i := 0; i < 1024; i++ {
 		require.False(t, serial.Interrupt.ReadAndClear())
 		serial.Cycle()
 	}
@@ -21,3 +21,115 @@ func TestSerialCycleTriggersInterruptWhenByteIsTransferred(t *testing.T) {
 	transferStarted := readBitN(serial.Read8(0xFF02), 7)
 	require.False(t, transferStarted)
 }
+
+func TestSerialCycleReadsIncomingByteFromReceiveCallback(t *testing.T) {
+	serial := newSerialController()
+	serial.ReceiveCallback = func() uint8 {
+		return 0x42
+	}
+
+	var sent []uint8
+	serial.Callback = func(data uint8) {
+		sent = append(sent, data)
+	}
+
+	serial.Write8(0xFF01, 0x17)
+	serial.Write8(0xFF02, 0x81) // 01000001 - set transfer start flag and set master mode
+
+	for i := 0; i < 1024; i++ {
+		serial.Cycle()
+	}
+
+	require.Equal(t, []uint8{0x17}, sent)
+	require.Equal(t, uint8(0x42), serial.Read8(0xFF01))
+}
+
+func TestSerialTransferRestartsAfterAbort(t *testing.T) {
+	serial := newSerialController()
+	var sent []uint8
+	serial.Callback = func(data uint8) {
+		sent = append(sent, data)
+	}
+
+	serial.Write8(0xFF01, 0x17)
+	serial.Write8(0xFF02, 0x81) // start transfer, internal clock
+	for i := 0; i < 512; i++ {
+		serial.Cycle() // shift half of the byte
+	}
+	serial.Write8(0xFF02, 0x01) // abort transfer
+
+	serial.Write8(0xFF01, 0x42)
+	serial.Write8(0xFF02, 0x81) // restart transfer with new data
+	for i := 0; i < 1023; i++ {
+		serial.Cycle()
+	}
+	require.Empty(t, sent, "expected restarted transfer to shift all 8 bits")
+	require.False(t, serial.Interrupt.ReadAndClear())
+
+	serial.Cycle()
+	require.Equal(t, []uint8{0x42}, sent)
+	require.True(t, serial.Interrupt.ReadAndClear())
+}
+
+func TestSerialTransferDurationDependsOnClockSpeed(t *testing.T) {
+	tests := []struct {
+		name       string
+		control    byte
+		wantCycles int
+	}{
+		{name: "normal (8192Hz)", control: 0x81, wantCycles: 1024},
+		{name: "fast (262144Hz)", control: 0x83, wantCycles: 32},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serial := newSerialController()
+			serial.Write8(0xFF02, tt.control)
+
+			cycles := 0
+			for cycles < 2048 && !serial.Interrupt.ReadAndClear() {
+				serial.Cycle()
+				cycles++
+			}
+
+			require.Equal(t, tt.wantCycles, cycles)
+		})
+	}
+}
+
+// bitPatternLink is a SerialBitLink returning the bits of incoming, and
+// recording the outgoing bits
+type bitPatternLink struct {
+	incoming byte
+	sent     []bool
+}
+
+func (l *bitPatternLink) Exchange(out byte) byte {
+	panic("expected bits to be exchanged individually")
+}
+
+func (l *bitPatternLink) ExchangeBit(out bool) bool {
+	l.sent = append(l.sent, out)
+	return readBitN(l.incoming, uint8(8-len(l.sent)))
+}
+
+func TestSerialShiftsOneBitPerSerialClock(t *testing.T) {
+	link := &bitPatternLink{incoming: 0xA5} // 10100101
+	serial := newSerialController()
+	serial.Link = link
+
+	serial.Write8(0xFF01, 0x3C) // 00111100
+	serial.Write8(0xFF02, 0x81) // set transfer start flag and set master mode
+	wantData := []byte{0x79, 0xF2, 0xE5, 0xCA, 0x94, 0x29, 0x52, 0xA5}
+
+	for i, want := range wantData {
+		for j := 0; j < 128; j++ {
+			serial.Cycle()
+		}
+		require.Equal(t, want, serial.Read8(0xFF01), "after shifting %d bits", i+1)
+		require.Equal(t, i == 7, serial.Interrupt.ReadAndClear())
+	}
+
+	require.Equal(t, []bool{false, false, true, true, true, true, false, false}, link.sent)
+	require.False(t, readBitN(serial.Read8(0xFF02), 7))
+}
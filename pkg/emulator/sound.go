@@ -1,18 +1,100 @@
 package emulator
 
+const (
+	offsetSoundRegisters uint16 = 0xFF10
+)
+
+// soundLengthMasks contains the length counter bits of the NRx1 registers
+//
+// On DMG, the length counters remain writable while sound is powered off.
+var soundLengthMasks = map[uint16]byte{
+	0xFF11: 0x3F, // NR11 - Bit 5-0 Sound length data
+	0xFF16: 0x3F, // NR21 - Bit 5-0 Sound length data
+	0xFF1B: 0xFF, // NR31 - Bit 7-0 Sound length
+	0xFF20: 0x3F, // NR41 - Bit 5-0 Sound length data
+}
+
+// soundReadMasks contains the bits of the sound registers (0xFF10 - 0xFF2F)
+// that always read as 1, as they are either unused or write-only
+//
+// Registers without an entry are unused, and always read as 0xFF.
+var soundReadMasks = map[uint16]byte{
+	0xFF10: 0x80, // NR10 - Bit 7 Not used
+	0xFF11: 0x3F, // NR11 - Bit 5-0 Sound length data (Write Only)
+	0xFF12: 0x00, // NR12
+	0xFF13: 0xFF, // NR13 - Frequency lo (Write Only)
+	0xFF14: 0xBF, // NR14 - Bit 7 Initial (Write Only), Bit 5-3 Not used, Bit 2-0 Frequency hi (Write Only)
+	0xFF16: 0x3F, // NR21 - Bit 5-0 Sound length data (Write Only)
+	0xFF17: 0x00, // NR22
+	0xFF18: 0xFF, // NR23 - Frequency lo (Write Only)
+	0xFF19: 0xBF, // NR24 - Bit 7 Initial (Write Only), Bit 5-3 Not used, Bit 2-0 Frequency hi (Write Only)
+	0xFF1A: 0x7F, // NR30 - Bit 6-0 Not used
+	0xFF1B: 0xFF, // NR31 - Bit 7-0 Sound length (Write Only)
+	0xFF1C: 0x9F, // NR32 - Bit 7 and 4-0 Not used
+	0xFF1D: 0xFF, // NR33 - Frequency lo (Write Only)
+	0xFF1E: 0xBF, // NR34 - Bit 7 Initial (Write Only), Bit 5-3 Not used, Bit 2-0 Frequency hi (Write Only)
+	0xFF20: 0xFF, // NR41 - Bit 7-6 Not used, Bit 5-0 Sound length data (Write Only)
+	0xFF21: 0x00, // NR42
+	0xFF22: 0x00, // NR43
+	0xFF23: 0xBF, // NR44 - Bit 7 Initial (Write Only), Bit 5-0 Not used
+	0xFF24: 0x00, // NR50
+	0xFF25: 0x00, // NR51
+	0xFF26: 0x70, // NR52 - Bit 6-4 Not used
+}
+
+// frameSequencerPeriod is the number of machine cycles between steps of the
+// frame sequencer (512Hz), which clocks length counters, envelopes and sweep
+const frameSequencerPeriod = 2048
+
 // soundController handles everything sound related
 //
-// TODO: For now, only support on/off of sound - all other sound is disabled
+// The output of the four sound channels is mixed according to NR50 and NR51:
+//
+// NR50 - Channel control / ON-OFF / Volume (R/W)
+// - Bit 6-4 - SO2 output level (volume)  (0-7)
+// - Bit 2-0 - SO1 output level (volume)  (0-7)
+// NR51 - Selection of Sound output terminal (R/W)
+// - Bit 7-4 - Output sound 4-1 to SO2 terminal
+// - Bit 3-0 - Output sound 4-1 to SO1 terminal
+//
 // Registers, see https://gbdev.io/pandocs/#sound-controller
 // FF10 - FF1E
 // FF20 - FF26
 // FF30 - FF3F
 type soundController struct {
+	// registers contains the sound registers mapped to 0xFF10 - 0xFF3F
+	// (including Wave Pattern RAM at 0xFF30 - 0xFF3F)
+	registers []byte
+
 	powerOn bool
+
+	// channel1 is a square wave with frequency sweep (NR10 - NR14)
+	channel1 *squareChannel
+	// channel2 is a square wave without frequency sweep (NR21 - NR24)
+	channel2 *squareChannel
+	// channel3 plays back Wave Pattern RAM (NR30 - NR34)
+	channel3 *waveChannel
+	// channel4 is noise (NR41 - NR44)
+	channel4 *noiseChannel
+
+	// frameSequencerTicks counts machine cycles towards the next step of the
+	// frame sequencer, and frameSequencerStep is the current step (0 - 7)
+	frameSequencerTicks int
+	frameSequencerStep  uint8
 }
 
 func newSoundController() *soundController {
-	return &soundController{}
+	registers := make([]byte, 0xFF3F-0xFF10+1)
+	return &soundController{
+		registers: registers,
+		channel1:  newSquareChannel(registers[0xFF10-offsetSoundRegisters:0xFF15-offsetSoundRegisters], true),
+		channel2:  newSquareChannel(registers[0xFF15-offsetSoundRegisters:0xFF1A-offsetSoundRegisters], false),
+		channel3: newWaveChannel(
+			registers[0xFF1A-offsetSoundRegisters:0xFF1F-offsetSoundRegisters],
+			registers[0xFF30-offsetSoundRegisters:0xFF40-offsetSoundRegisters],
+		),
+		channel4: newNoiseChannel(registers[0xFF1F-offsetSoundRegisters : 0xFF24-offsetSoundRegisters]),
+	}
 }
 
 // Read8 is exposed in the address space, and may be read by the program
@@ -24,23 +106,165 @@ func (s *soundController) Read8(address uint16) byte {
 		// Bit 2 - Sound 3 ON flag (Read Only)
 		// Bit 1 - Sound 2 ON flag (Read Only)
 		// Bit 0 - Sound 1 ON flag (Read Only)
-		return writeBitN(byte(0), 7, s.powerOn)
+		v := writeBitN(soundReadMasks[address], 7, s.powerOn)
+		v = writeBitN(v, 3, s.channel4.enabled)
+		v = writeBitN(v, 2, s.channel3.enabled)
+		v = writeBitN(v, 1, s.channel2.enabled)
+		return writeBitN(v, 0, s.channel1.enabled)
+	}
+
+	if address >= 0xFF30 {
+		// Wave Pattern RAM
+		return s.registers[address-offsetSoundRegisters]
+	}
+
+	if mask, ok := soundReadMasks[address]; ok {
+		return s.registers[address-offsetSoundRegisters] | mask
 	}
 
-	// ignore all reads
-	return byte(0)
+	// unused registers
+	return 0xFF
 }
 
 // Write8 is exposed in the address space, and may be written to by the program
 func (s *soundController) Write8(address uint16, v byte) {
-	switch address {
-	case 0xFF26:
+	switch {
+	case address == 0xFF26:
 		// Bit 7 - All sound on/off  (0: stop all sound circuits) (Read/Write)
-		s.powerOn = readBitN(v, 7)
+		powerOn := readBitN(v, 7)
+		if s.powerOn && !powerOn {
+			s.powerOff()
+		}
+		s.powerOn = powerOn
+	case address >= 0xFF30:
+		// Wave Pattern RAM is accessible regardless of power
+		s.registers[address-offsetSoundRegisters] = v
+	case !s.powerOn:
+		// Registers are read-only while powered off, except for the length
+		// counters on DMG
+		if mask, ok := soundLengthMasks[address]; ok {
+			current := s.registers[address-offsetSoundRegisters]
+			s.registers[address-offsetSoundRegisters] = (current &^ mask) | (v & mask)
+			s.writeChannel(address, v)
+		}
 	default:
-		// Ignore all unimplemented writes on purpose
+		s.registers[address-offsetSoundRegisters] = v
+		s.writeChannel(address, v)
+	}
+}
+
+// writeChannel forwards a write to the channel owning the register
+func (s *soundController) writeChannel(address uint16, v byte) {
+	switch {
+	case address < 0xFF15:
+		s.channel1.Write8(int(address-0xFF10), v)
+	case address < 0xFF1A:
+		s.channel2.Write8(int(address-0xFF15), v)
+	case address < 0xFF1F:
+		s.channel3.Write8(int(address-0xFF1A), v)
+	case address < 0xFF24:
+		s.channel4.Write8(int(address-0xFF1F), v)
+	}
+}
+
+// Cycle progresses the sound channels by a single machine cycle
+func (s *soundController) Cycle() {
+	if !s.powerOn {
+		return
+	}
+
+	s.frameSequencerTicks++
+	if s.frameSequencerTicks >= frameSequencerPeriod {
+		s.frameSequencerTicks = 0
+		s.stepFrameSequencer()
+	}
+
+	s.channel1.Cycle()
+	s.channel2.Cycle()
+	s.channel3.Cycle()
+	s.channel4.Cycle()
+}
+
+// stepFrameSequencer clocks the length counters (256Hz), sweep (128Hz) and
+// envelopes (64Hz)
+//
+// Step   Length Ctr  Vol Env     Sweep
+// ---------------------------------------
+// 0      Clock       -           -
+// 1      -           -           -
+// 2      Clock       -           Clock
+// 3      -           -           -
+// 4      Clock       -           -
+// 5      -           -           -
+// 6      Clock       -           Clock
+// 7      -           Clock       -
+func (s *soundController) stepFrameSequencer() {
+	step := s.frameSequencerStep
+	s.frameSequencerStep = (s.frameSequencerStep + 1) % 8
+
+	if step%2 == 0 {
+		s.channel1.ClockLength()
+		s.channel2.ClockLength()
+		s.channel3.ClockLength()
+		s.channel4.ClockLength()
+	}
+	if step == 2 || step == 6 {
+		s.channel1.ClockSweep()
+	}
+	if step == 7 {
+		s.channel1.ClockEnvelope()
+		s.channel2.ClockEnvelope()
+		s.channel4.ClockEnvelope()
+	}
+}
+
+// Sample returns the mixed output of the sound channels (-1 to 1)
+//
+// Both terminals are mixed into a single mono sample.
+func (s *soundController) Sample() float32 {
+	if !s.powerOn {
+		return 0
+	}
+
+	samples := [4]float32{
+		s.channel1.Sample(),
+		s.channel2.Sample(),
+		s.channel3.Sample(),
+		s.channel4.Sample(),
+	}
+
+	nr50 := s.registers[0xFF24-offsetSoundRegisters]
+	nr51 := s.registers[0xFF25-offsetSoundRegisters]
+
+	var so1, so2 float32
+	for i, sample := range samples {
+		if readBitN(nr51, uint8(i)) {
+			so1 += sample
+		}
+		if readBitN(nr51, uint8(i+4)) {
+			so2 += sample
+		}
+	}
+
+	so1 *= float32(nr50&0x07+1) / 8
+	so2 *= float32((nr50>>4)&0x07+1) / 8
+	return (so1 + so2) / 8
+}
+
+// powerOff clears all sound registers (NR10-NR51), except for the length
+// counters which are retained on DMG
+func (s *soundController) powerOff() {
+	for address := uint16(0xFF10); address < 0xFF26; address++ {
+		mask := soundLengthMasks[address]
+		s.registers[address-offsetSoundRegisters] &= mask
 	}
 
+	s.channel1.PowerOff()
+	s.channel2.PowerOff()
+	s.channel3.PowerOff()
+	s.channel4.PowerOff()
+	s.frameSequencerTicks = 0
+	s.frameSequencerStep = 0
 }
 
 func (s *soundController) String() string {
@@ -0,0 +1,163 @@
+package emulator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSoundRegistersAreReadOnlyWhilePoweredOff(t *testing.T) {
+	sound := newSoundController()
+	sound.Write8(0xFF26, 0x80) // power on
+	sound.Write8(0xFF12, 0xF3) // NR12
+
+	sound.Write8(0xFF26, 0x00) // power off
+	require.Equal(t, uint8(0x00), sound.registers[0xFF12-offsetSoundRegisters], "expected power off to clear NR12")
+
+	sound.Write8(0xFF12, 0xF3)
+	require.Equal(t, uint8(0x00), sound.registers[0xFF12-offsetSoundRegisters], "expected write to be ignored")
+}
+
+func TestSoundLengthRegistersAreWritableWhilePoweredOff(t *testing.T) {
+	sound := newSoundController()
+
+	sound.Write8(0xFF11, 0xFF) // NR11 - duty (ignored) and length
+	sound.Write8(0xFF1B, 0xAB) // NR31 - length
+
+	require.Equal(t, uint8(0x3F), sound.registers[0xFF11-offsetSoundRegisters])
+	require.Equal(t, uint8(0xAB), sound.registers[0xFF1B-offsetSoundRegisters])
+
+	// Length is retained when powering on and off again
+	sound.Write8(0xFF26, 0x80)
+	sound.Write8(0xFF26, 0x00)
+	require.Equal(t, uint8(0x3F), sound.registers[0xFF11-offsetSoundRegisters])
+}
+
+func TestSoundWaveRAMIsWritableWhilePoweredOff(t *testing.T) {
+	sound := newSoundController()
+	sound.Write8(0xFF30, 0x12)
+	require.Equal(t, uint8(0x12), sound.registers[0xFF30-offsetSoundRegisters])
+}
+
+func TestSoundUnusedBitsReadAsOne(t *testing.T) {
+	sound := newSoundController()
+
+	require.Equal(t, uint8(0x70), sound.Read8(0xFF26), "expected NR52 bits 6-4 to read as 1")
+
+	sound.Write8(0xFF26, 0x80) // power on
+	require.Equal(t, uint8(0xF0), sound.Read8(0xFF26))
+
+	tests := []struct {
+		name    string
+		address uint16
+		v       byte
+		want    byte
+	}{
+		{name: "NR11", address: 0xFF11, v: 0x80, want: 0xBF},
+		{name: "NR21", address: 0xFF16, v: 0x40, want: 0x7F},
+		{name: "NR31", address: 0xFF1B, v: 0x12, want: 0xFF},
+		{name: "NR41", address: 0xFF20, v: 0x12, want: 0xFF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sound.Write8(tt.address, tt.v)
+			require.Equal(t, tt.want, sound.Read8(tt.address))
+		})
+	}
+}
+
+func TestSoundReportsEnabledChannels(t *testing.T) {
+	sound := newSoundController()
+	sound.Write8(0xFF26, 0x80) // power on
+	sound.Write8(0xFF12, 0xF0) // NR12 - volume 15
+	sound.Write8(0xFF17, 0xF0) // NR22 - volume 15
+	sound.Write8(0xFF1A, 0x80) // NR30 - playback
+	sound.Write8(0xFF21, 0xF0) // NR42 - volume 15
+	require.Equal(t, uint8(0xF0), sound.Read8(0xFF26))
+
+	sound.Write8(0xFF14, 0x80) // NR14 - trigger
+	sound.Write8(0xFF19, 0x80) // NR24 - trigger
+	sound.Write8(0xFF1E, 0x80) // NR34 - trigger
+	sound.Write8(0xFF23, 0x80) // NR44 - trigger
+	require.Equal(t, uint8(0xFF), sound.Read8(0xFF26))
+
+	sound.Write8(0xFF26, 0x00) // power off
+	sound.Write8(0xFF26, 0x80) // power on
+	require.Equal(t, uint8(0xF0), sound.Read8(0xFF26))
+}
+
+func TestSoundMixesChannels(t *testing.T) {
+	tests := []struct {
+		name string
+		nr50 byte
+		nr51 byte
+		want float32
+	}{
+		{name: "not routed", nr50: 0x77, nr51: 0x00, want: 0},
+		{name: "SO1", nr50: 0x77, nr51: 0x01, want: 0.125},
+		{name: "SO2", nr50: 0x77, nr51: 0x10, want: 0.125},
+		{name: "both terminals", nr50: 0x77, nr51: 0x11, want: 0.25},
+		{name: "half volume", nr50: 0x33, nr51: 0x11, want: 0.125},
+		{name: "other channels", nr50: 0x77, nr51: 0xEE, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sound := newSoundController()
+			sound.Write8(0xFF26, 0x80) // power on
+			sound.Write8(0xFF24, tt.nr50)
+			sound.Write8(0xFF25, tt.nr51)
+			sound.Write8(0xFF12, 0xF0)  // NR12 - volume 15
+			sound.Write8(0xFF14, 0x80)  // NR14 - trigger
+			sound.channel1.dutyStep = 7 // high with 12.5% duty
+
+			require.Equal(t, float32(1), sound.channel1.Sample())
+			require.Equal(t, tt.want, sound.Sample())
+		})
+	}
+}
+
+func TestSoundWaveRAMReadsBack(t *testing.T) {
+	sound := newSoundController()
+	for address := uint16(0xFF30); address <= 0xFF3F; address++ {
+		sound.Write8(address, byte(address))
+	}
+
+	for address := uint16(0xFF30); address <= 0xFF3F; address++ {
+		require.Equal(t, byte(address), sound.Read8(address))
+	}
+}
+
+func TestSoundRegistersReadBack(t *testing.T) {
+	sound := newSoundController()
+	sound.Write8(0xFF26, 0x80) // power on
+
+	tests := []struct {
+		name    string
+		address uint16
+		v       byte
+		want    byte
+	}{
+		{name: "NR10", address: 0xFF10, v: 0x7F, want: 0xFF},
+		{name: "NR12", address: 0xFF12, v: 0x00, want: 0x00},
+		{name: "NR13", address: 0xFF13, v: 0x12, want: 0xFF},
+		{name: "NR14", address: 0xFF14, v: 0x47, want: 0xFF},
+		{name: "NR22", address: 0xFF17, v: 0xA5, want: 0xA5},
+		{name: "NR30", address: 0xFF1A, v: 0x00, want: 0x7F},
+		{name: "NR32", address: 0xFF1C, v: 0x60, want: 0xFF},
+		{name: "NR43", address: 0xFF22, v: 0x5A, want: 0x5A},
+		{name: "NR44", address: 0xFF23, v: 0x00, want: 0xBF},
+		{name: "NR50", address: 0xFF24, v: 0x77, want: 0x77},
+		{name: "NR51", address: 0xFF25, v: 0xF3, want: 0xF3},
+		{name: "unused", address: 0xFF15, v: 0x00, want: 0xFF},
+		{name: "unused after NR52", address: 0xFF27, v: 0x00, want: 0xFF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sound.Write8(tt.address, tt.v)
+			require.Equal(t, tt.want, sound.Read8(tt.address))
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package emulator
+
+// squareDutyPatterns contains the waveforms selectable by bits 7-6 of NRx1,
+// one bit per step (from step 0 at the most significant bit)
+//
+// 00: 12.5% ( _-------_-------_------- )
+// 01: 25%   ( __------__------__------ )
+// 10: 50%   ( ____----____----____---- )
+// 11: 75%   ( ______--______--______-- )
+var squareDutyPatterns = [4]byte{
+	0b00000001,
+	0b10000001,
+	0b10000111,
+	0b01111110,
+}
+
+// squareChannel generates a square wave (sound channel 1 and 2)
+//
+// The channel is configured through 5 registers (NRx0 - NRx4), where NRx0 is
+// only used by channel 1 (frequency sweep):
+//
+// NRx0 - Sweep register (R/W)
+// - Bit 6-4 - Sweep Time (in 128Hz ticks)
+// - Bit 3   - Sweep Increase/Decrease (0: Addition, 1: Subtraction)
+// - Bit 2-0 - Number of sweep shift
+// NRx1 - Sound length/Wave pattern duty (R/W)
+// - Bit 7-6 - Wave Pattern Duty
+// - Bit 5-0 - Sound length data (Write Only) (t1: 0-63)
+// NRx2 - Volume Envelope (R/W)
+// - Bit 7-4 - Initial Volume of envelope (0-0Fh) (0=No Sound)
+// - Bit 3   - Envelope Direction (0=Decrease, 1=Increase)
+// - Bit 2-0 - Number of envelope sweep (n: 0-7) (If zero, stop envelope operation.)
+// NRx3 - Frequency lo (Write Only)
+// NRx4 - Frequency hi (R/W)
+// - Bit 7   - Initial (1=Restart Sound) (Write Only)
+// - Bit 6   - Counter/consecutive selection (Read/Write) (1=Stop output when length in NRx1 expires)
+// - Bit 2-0 - Frequency's higher 3 bits (x) (Write Only)
+//
+// See https://gbdev.io/pandocs/Sound_Controller.html
+type squareChannel struct {
+	// registers contains NRx0 - NRx4 of the channel
+	registers []byte
+
+	// hasSweep is true if the channel supports frequency sweep (channel 1)
+	hasSweep bool
+
+	// enabled is true while the channel is producing sound
+	enabled bool
+
+	// frequencyTimer counts down the machine cycles until the next duty step
+	frequencyTimer int
+	dutyStep       uint8
+
+	lengthCounter int
+
+	volumeEnvelope
+
+	sweepEnabled   bool
+	sweepTimer     uint8
+	sweepFrequency uint16 // shadow frequency register
+}
+
+func newSquareChannel(registers []byte, hasSweep bool) *squareChannel {
+	return &squareChannel{
+		registers: registers,
+		hasSweep:  hasSweep,
+	}
+}
+
+// Write8 handles writes to register NRx0 - NRx4, where r is the index of the
+// register (0 - 4). The register is expected to be stored already.
+func (c *squareChannel) Write8(r int, v byte) {
+	switch r {
+	case 1:
+		c.lengthCounter = 64 - int(v&0x3F)
+	case 2:
+		if !c.dacEnabled() {
+			c.enabled = false
+		}
+	case 4:
+		if readBitN(v, 7) {
+			c.trigger()
+		}
+	}
+}
+
+// Cycle progresses the channel by a single machine cycle
+func (c *squareChannel) Cycle() {
+	c.frequencyTimer--
+	if c.frequencyTimer <= 0 {
+		c.frequencyTimer = c.period()
+		c.dutyStep = (c.dutyStep + 1) % 8
+	}
+}
+
+// Sample returns the current output of the channel (-1 to 1)
+func (c *squareChannel) Sample() float32 {
+	if !c.enabled {
+		return 0
+	}
+
+	amplitude := float32(c.volume) / 15
+	duty := squareDutyPatterns[c.registers[1]>>6]
+	if readBitN(duty, 7-c.dutyStep) {
+		return amplitude
+	}
+	return -amplitude
+}
+
+// ClockLength is called by the frame sequencer at 256Hz
+func (c *squareChannel) ClockLength() {
+	if !readBitN(c.registers[4], 6) || c.lengthCounter == 0 {
+		return
+	}
+
+	c.lengthCounter--
+	if c.lengthCounter == 0 {
+		c.enabled = false
+	}
+}
+
+// ClockEnvelope is called by the frame sequencer at 64Hz
+func (c *squareChannel) ClockEnvelope() {
+	c.clockEnvelope(c.registers[2])
+}
+
+// ClockSweep is called by the frame sequencer at 128Hz
+func (c *squareChannel) ClockSweep() {
+	if !c.hasSweep {
+		return
+	}
+
+	if c.sweepTimer > 0 {
+		c.sweepTimer--
+	}
+	if c.sweepTimer > 0 {
+		return
+	}
+	c.sweepTimer = c.sweepPeriod()
+
+	if !c.sweepEnabled || (c.registers[0]>>4)&0x07 == 0 {
+		return
+	}
+
+	frequency := c.calculateSweep()
+	shift := c.registers[0] & 0x07
+	if frequency <= 2047 && shift > 0 {
+		c.sweepFrequency = frequency
+		c.setFrequency(frequency)
+		c.calculateSweep() // overflow check using the new frequency
+	}
+}
+
+// calculateSweep returns the next frequency of the sweep, and disables the
+// channel if it overflows
+func (c *squareChannel) calculateSweep() uint16 {
+	shift := c.registers[0] & 0x07
+	delta := c.sweepFrequency >> shift
+
+	frequency := c.sweepFrequency + delta
+	if readBitN(c.registers[0], 3) {
+		frequency = c.sweepFrequency - delta
+	}
+
+	if frequency > 2047 {
+		c.enabled = false
+	}
+	return frequency
+}
+
+// trigger restarts the channel (bit 7 of NRx4)
+func (c *squareChannel) trigger() {
+	c.enabled = c.dacEnabled()
+	if c.lengthCounter == 0 {
+		c.lengthCounter = 64
+	}
+	c.frequencyTimer = c.period()
+	c.triggerEnvelope(c.registers[2])
+
+	if c.hasSweep {
+		c.sweepFrequency = c.frequency()
+		c.sweepTimer = c.sweepPeriod()
+		shift := c.registers[0] & 0x07
+		c.sweepEnabled = (c.registers[0]>>4)&0x07 > 0 || shift > 0
+		if shift > 0 {
+			c.calculateSweep()
+		}
+	}
+}
+
+// PowerOff disables the channel, and resets its state
+func (c *squareChannel) PowerOff() {
+	*c = squareChannel{
+		registers:     c.registers,
+		hasSweep:      c.hasSweep,
+		lengthCounter: c.lengthCounter, // retained on DMG
+	}
+}
+
+// dacEnabled returns true if the upper 5 bits of NRx2 are non-zero
+func (c *squareChannel) dacEnabled() bool {
+	return c.registers[2]&0xF8 != 0
+}
+
+// frequency returns the 11-bit frequency from NRx3 and NRx4
+func (c *squareChannel) frequency() uint16 {
+	return uint16(c.registers[4]&0x07)<<8 | uint16(c.registers[3])
+}
+
+func (c *squareChannel) setFrequency(frequency uint16) {
+	c.registers[3] = byte(frequency)
+	c.registers[4] = (c.registers[4] &^ 0x07) | byte(frequency>>8)&0x07
+}
+
+// period returns the number of machine cycles per duty step
+func (c *squareChannel) period() int {
+	return 2048 - int(c.frequency())
+}
+
+// sweepPeriod returns the sweep time, where 0 is treated as 8
+func (c *squareChannel) sweepPeriod() uint8 {
+	period := (c.registers[0] >> 4) & 0x07
+	if period == 0 {
+		return 8
+	}
+	return period
+}
+
+// volumeEnvelope adjusts the volume of the square and noise channels
+// periodically, as configured by NRx2:
+//
+// - Bit 7-4 - Initial Volume of envelope (0-0Fh) (0=No Sound)
+// - Bit 3   - Envelope Direction (0=Decrease, 1=Increase)
+// - Bit 2-0 - Number of envelope sweep (n: 0-7) (If zero, stop envelope operation.)
+type volumeEnvelope struct {
+	volume        uint8
+	envelopeTimer uint8
+}
+
+// triggerEnvelope restarts the envelope when the channel is triggered
+func (e *volumeEnvelope) triggerEnvelope(nrx2 byte) {
+	e.volume = nrx2 >> 4
+	e.envelopeTimer = nrx2 & 0x07
+}
+
+// clockEnvelope is called by the frame sequencer at 64Hz
+func (e *volumeEnvelope) clockEnvelope(nrx2 byte) {
+	period := nrx2 & 0x07
+	if period == 0 {
+		return
+	}
+
+	if e.envelopeTimer > 0 {
+		e.envelopeTimer--
+	}
+	if e.envelopeTimer > 0 {
+		return
+	}
+	e.envelopeTimer = period
+
+	increase := readBitN(nrx2, 3)
+	if increase && e.volume < 15 {
+		e.volume++
+	} else if !increase && e.volume > 0 {
+		e.volume--
+	}
+}
@@ -0,0 +1,129 @@
+package emulator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func cycleSound(s *soundController, cycles int) {
+	for i := 0; i < cycles; i++ {
+		s.Cycle()
+	}
+}
+
+func TestSquareEnvelopeDecrementTiming(t *testing.T) {
+	sound := newSoundController()
+	sound.Write8(0xFF26, 0x80) // power on
+	sound.Write8(0xFF12, 0xF1) // NR12 - volume 15, decrease, period 1
+	sound.Write8(0xFF14, 0x80) // NR14 - trigger
+	require.Equal(t, uint8(15), sound.channel1.volume)
+
+	// The envelope is clocked on step 7 of the frame sequencer (64Hz)
+	cycleSound(sound, 8*frameSequencerPeriod-1)
+	require.Equal(t, uint8(15), sound.channel1.volume)
+
+	cycleSound(sound, 1)
+	require.Equal(t, uint8(14), sound.channel1.volume)
+
+	cycleSound(sound, 8*frameSequencerPeriod)
+	require.Equal(t, uint8(13), sound.channel1.volume)
+}
+
+func TestSquareEnvelopePeriodAndDirection(t *testing.T) {
+	sound := newSoundController()
+	sound.Write8(0xFF26, 0x80) // power on
+	sound.Write8(0xFF12, 0xEA) // NR12 - volume 14, increase, period 2
+	sound.Write8(0xFF14, 0x80) // NR14 - trigger
+
+	cycleSound(sound, 8*frameSequencerPeriod)
+	require.Equal(t, uint8(14), sound.channel1.volume, "expected no change after a single envelope clock")
+
+	cycleSound(sound, 8*frameSequencerPeriod)
+	require.Equal(t, uint8(15), sound.channel1.volume)
+
+	cycleSound(sound, 16*frameSequencerPeriod)
+	require.Equal(t, uint8(15), sound.channel1.volume, "expected volume to stop at 15")
+}
+
+func TestSquareSweepFrequencyCalculation(t *testing.T) {
+	tests := []struct {
+		name      string
+		nr10      byte
+		frequency uint16
+		want      uint16
+		enabled   bool
+	}{
+		{name: "addition", nr10: 0x11, frequency: 0x400, want: 0x600, enabled: true},
+		{name: "subtraction", nr10: 0x19, frequency: 0x400, want: 0x200, enabled: true},
+		{name: "larger shift", nr10: 0x13, frequency: 0x400, want: 0x480, enabled: true},
+		{name: "overflow disables channel", nr10: 0x11, frequency: 0x600, want: 0x900, enabled: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sound := newSoundController()
+			sound.Write8(0xFF26, 0x80) // power on
+			sound.Write8(0xFF10, tt.nr10)
+			sound.Write8(0xFF12, 0xF0)
+
+			channel := sound.channel1
+			channel.enabled = true
+			channel.sweepFrequency = tt.frequency
+
+			require.Equal(t, tt.want, channel.calculateSweep())
+			require.Equal(t, tt.enabled, channel.enabled)
+		})
+	}
+}
+
+func TestSquareSweepUpdatesFrequency(t *testing.T) {
+	sound := newSoundController()
+	sound.Write8(0xFF26, 0x80) // power on
+	sound.Write8(0xFF10, 0x11) // NR10 - period 1, addition, shift 1
+	sound.Write8(0xFF12, 0xF0) // NR12 - volume 15
+	sound.Write8(0xFF13, 0x00) // NR13 - frequency lo
+	sound.Write8(0xFF14, 0x81) // NR14 - trigger, frequency hi
+
+	// The sweep is clocked on step 2 and 6 of the frame sequencer (128Hz)
+	cycleSound(sound, 3*frameSequencerPeriod)
+	require.Equal(t, uint16(0x180), sound.channel1.frequency())
+
+	cycleSound(sound, 4*frameSequencerPeriod)
+	require.Equal(t, uint16(0x240), sound.channel1.frequency())
+	require.True(t, sound.channel1.enabled)
+}
+
+func TestSquareLengthCounterDisablesChannel(t *testing.T) {
+	sound := newSoundController()
+	sound.Write8(0xFF26, 0x80) // power on
+	sound.Write8(0xFF11, 0x3E) // NR11 - length 2
+	sound.Write8(0xFF12, 0xF0) // NR12 - volume 15
+	sound.Write8(0xFF14, 0xC0) // NR14 - trigger, length enabled
+	require.Equal(t, uint8(0x81), sound.Read8(0xFF26)&0x81)
+
+	// The length counter is clocked on every other step of the frame sequencer
+	cycleSound(sound, frameSequencerPeriod)
+	require.Equal(t, uint8(0x81), sound.Read8(0xFF26)&0x81)
+
+	cycleSound(sound, 2*frameSequencerPeriod)
+	require.Equal(t, uint8(0x80), sound.Read8(0xFF26)&0x81)
+	require.Equal(t, float32(0), sound.Sample())
+}
+
+func TestSquareOutputsDutyCycle(t *testing.T) {
+	sound := newSoundController()
+	sound.Write8(0xFF26, 0x80) // power on
+	sound.Write8(0xFF11, 0x80) // NR11 - 50% duty
+	sound.Write8(0xFF12, 0xF0) // NR12 - volume 15
+	sound.Write8(0xFF13, 0xFF) // NR13 - frequency lo
+	sound.Write8(0xFF14, 0x87) // NR14 - trigger, frequency hi (1 cycle per step)
+
+	var samples []float32
+	for i := 0; i < 8; i++ {
+		sound.Cycle()
+		samples = append(samples, sound.channel1.Sample())
+	}
+
+	require.Equal(t, []float32{-1, -1, -1, -1, 1, 1, 1, 1}, samples)
+}
@@ -1,7 +1,5 @@
 package emulator
 
-import "log"
-
 type timerRegister uint16
 
 const (
@@ -11,7 +9,8 @@ const (
 const (
 	// Divider register (read/write)
 	//
-	// Writing any value to the register resets it to zero.
+	// Upper byte of the internal 16-bit counter. Writing any value to the
+	// register resets the entire counter to zero.
 	registerFF04 timerRegister = 0xFF04
 
 	// Timer Counter (read/write)
@@ -25,32 +24,41 @@ const (
 
 	// Timer Control (read/write)
 	//
-	// Bits 1-0 control the frequency at which FF05 is incremented. Each
-	// cycle will add one or more increments to an internal counter,
-	// incrementing FF05 when we reach 256 increments.
+	// Bits 1-0 select the bit of the internal counter that FF05 is incremented
+	// by, which happens on every falling edge of the bit.
 	//
 	// Bit  2   - Timer Enable
 	// Bits 1-0 - Input Clock Select
-	//            00: Cycle / 256 = 1 increment
-	//            01: Cycle / 4   = 64 increments
-	//            10: Cycle / 16  = 16 increments
-	//            11: Cycle / 64  = 4 increments
+	//            00: Bit 9 (every 256 machine cycles)
+	//            01: Bit 3 (every 4 machine cycles)
+	//            10: Bit 5 (every 16 machine cycles)
+	//            11: Bit 7 (every 64 machine cycles)
 	registerFF07 = 0xFF07
 )
 
+// timerInputBits maps the input clock select of FF07 to the bit of the
+// internal counter driving the timer
+var timerInputBits = [4]uint16{1 << 9, 1 << 3, 1 << 5, 1 << 7}
+
 // timerController handles time counters and interrupts
 type timerController struct {
-	// registers contains control and status registers mapped to 0xFF04 - 0xFF07
+	// registers contains control and status registers mapped to 0xFF04 - 0xFF07,
+	// except for the divider, which is derived from counter
 	registers []byte
 
-	// incrementalTimer counts increments towards increasing the timer counter (see registerFF07)
-	incrementalTimer int
+	// counter is the internal 16-bit counter, incremented on every clock (4 per
+	// machine cycle). Drives both the divider and the timer counter.
+	counter uint16
 
-	// incrementDivider counts increments towards increasing the divider counter (see registerFF04)
-	incrementalDivider int
+	// reloadPending is true for the machine cycle after the timer counter
+	// overflowed, before it is reloaded from the timer modulo
+	reloadPending bool
 
 	// Interrupt is true if the timer wants to trigger the INT 50 interrupt
 	Interrupt *interruptSource
+
+	// memory reports accesses to addresses not mapped to a register
+	memory *memory
 }
 
 func newTimerController() *timerController {
@@ -64,7 +72,7 @@ func newTimerController() *timerController {
 func (t *timerController) Read8(address uint16) byte {
 	switch address {
 	case 0xFF04:
-		return t.readRegister(registerFF04)
+		return byte(t.counter >> 8)
 	case 0xFF05:
 		return t.readRegister(registerFF05)
 	case 0xFF06:
@@ -73,25 +81,27 @@ func (t *timerController) Read8(address uint16) byte {
 		return t.readRegister(registerFF07)
 	}
 
-	notImplemented("read of unimplemented TIMER register at %#4x", address)
-	return byte(0)
+	return t.memory.unmapped(address, false)
 }
 
 // Write8 is exposed in the address space, and may be written to by the program
 func (t *timerController) Write8(address uint16, v byte) {
 	switch address {
 	case 0xFF04:
-		t.writeRegister(registerFF04, 0) // write 0 on any write
-		t.incrementalDivider = 0
+		t.setCounter(0) // write 0 on any write
 	case 0xFF05:
 		t.writeRegister(registerFF05, v)
+		t.reloadPending = false // a write during the reload delay cancels the reload
 	case 0xFF06:
 		t.writeRegister(registerFF06, v)
 	case 0xFF07:
+		wasHigh := t.timerInput()
 		t.writeRegister(registerFF07, v)
-		t.incrementalTimer = 0
+		if wasHigh && !t.timerInput() {
+			t.incrementTimer() // disabling or switching input may cause a falling edge
+		}
 	default:
-		notImplemented("write of unimplemented TIMER register at %#4x", address)
+		t.memory.unmapped(address, true)
 	}
 }
 
@@ -101,38 +111,47 @@ func (t *timerController) Write8(address uint16, v byte) {
 // edge cases not currently handled.
 // See https://gbdev.io/pandocs/Timer_Obscure_Behaviour.html
 func (t *timerController) Cycle() {
-	t.incrementalDivider++
-	if t.incrementalDivider >= 256 {
-		t.incrementalDivider = 0
-		t.writeRegister(registerFF04, t.readRegister(registerFF04)+1)
+	if t.reloadPending {
+		t.reloadPending = false
+		t.writeRegister(registerFF05, t.readRegister(registerFF06))
+		t.Interrupt.Set()
 	}
 
-	timerEnabled := readBitN(t.readRegister(registerFF07), 2)
-	if timerEnabled {
-		mode := t.readRegister(registerFF07) & 0x03 // read lower 2 bits only
-		switch mode {
-		case 0:
-			t.incrementalTimer++
-		case 1:
-			t.incrementalTimer += 64
-		case 2:
-			t.incrementalTimer += 16
-		case 3:
-			t.incrementalTimer += 4
-		default:
-			log.Panicf("unexpected mode (%d) for 0xFF07 timer observed", mode)
-		}
+	t.setCounter(t.counter + 4)
+}
 
-		if t.incrementalTimer >= 256 {
-			t.incrementalTimer = 0
-			t.writeRegister(registerFF05, t.readRegister(registerFF05)+1)
+// setCounter updates the internal counter, incrementing the timer counter on a
+// falling edge of the selected bit
+//
+// As resetting the counter may cause a falling edge, writing to the divider can
+// increment the timer counter.
+func (t *timerController) setCounter(v uint16) {
+	wasHigh := t.timerInput()
+	t.counter = v
+	if wasHigh && !t.timerInput() {
+		t.incrementTimer()
+	}
+}
 
-			interruptTriggered := t.readRegister(registerFF05) == 0
-			if interruptTriggered {
-				t.writeRegister(registerFF05, t.readRegister(registerFF06))
-				t.Interrupt.Set()
-			}
-		}
+// timerInput returns the value of the counter bit selected by FF07, ANDed with
+// the timer enable bit
+func (t *timerController) timerInput() bool {
+	control := t.readRegister(registerFF07)
+	if !readBitN(control, 2) {
+		return false
+	}
+
+	return t.counter&timerInputBits[control&0x03] != 0
+}
+
+// incrementTimer increments the timer counter
+//
+// When the counter overflows it reads 0x00 for one machine cycle, after which it
+// is reloaded from the timer modulo and an interrupt is triggered.
+func (t *timerController) incrementTimer() {
+	t.writeRegister(registerFF05, t.readRegister(registerFF05)+1)
+	if t.readRegister(registerFF05) == 0 {
+		t.reloadPending = true
 	}
 }
 
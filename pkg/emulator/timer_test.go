@@ -6,9 +6,10 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func TestDividerIncrementsAfter256Cycles(t *testing.T) {
+func TestDividerIncrementsAfter64Cycles(t *testing.T) {
 	timer := newTimerController()
-	for i := 0; i < 256; i++ {
+	for i := 0; i < 64; i++ {
+		require.Equal(t, uint8(0), timer.Read8(0xFF04))
 		timer.Cycle()
 	}
 
@@ -39,6 +40,78 @@ func TestTimerCanInterrupt(t *testing.T) {
 		}
 	}
 
+	// the reload (and interrupt) is delayed by a cycle after the overflow
+	require.False(t, timer.Interrupt.ReadAndClear())
+	timer.Cycle()
+
 	require.True(t, timer.Interrupt.ReadAndClear())
 	require.Equal(t, uint8(0x20), timer.Read8(0xFF05))
 }
+
+func TestDividerWriteIncrementsTimerOnFallingEdge(t *testing.T) {
+	tests := []struct {
+		name     string
+		cycles   int
+		wantTIMA byte
+	}{
+		{name: "selected bit low", cycles: 1, wantTIMA: 0},
+		{name: "selected bit high", cycles: 2, wantTIMA: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timer := newTimerController()
+			timer.Write8(0xFF07, 0x05) // b00000101 - enable timer, mode 1 (bit 3)
+
+			for i := 0; i < tt.cycles; i++ {
+				timer.Cycle()
+			}
+			require.Equal(t, uint8(0), timer.Read8(0xFF05))
+
+			timer.Write8(0xFF04, 0x00)
+			require.Equal(t, tt.wantTIMA, timer.Read8(0xFF05))
+		})
+	}
+}
+
+func TestTimerControlWriteIncrementsTimerOnFallingEdge(t *testing.T) {
+	timer := newTimerController()
+	timer.Write8(0xFF07, 0x05) // b00000101 - enable timer, mode 1 (bit 3)
+	timer.Cycle()
+	timer.Cycle() // bit 3 is now high
+
+	timer.Write8(0xFF07, 0x01) // disable timer
+	require.Equal(t, uint8(1), timer.Read8(0xFF05))
+}
+
+// overflowTimer cycles the timer until the timer counter overflows
+func overflowTimer(timer *timerController) {
+	timer.Write8(0xFF06, 0x20) // value of 0xFF05 after reload
+	timer.Write8(0xFF05, 0xFF)
+	timer.Write8(0xFF07, 0x05) // b00000101 - enable timer, mode 1
+	for i := 0; i < 4; i++ {
+		timer.Cycle()
+	}
+}
+
+func TestTimerReloadIsDelayedByOneCycle(t *testing.T) {
+	timer := newTimerController()
+	overflowTimer(timer)
+
+	require.Equal(t, uint8(0x00), timer.Read8(0xFF05))
+	require.False(t, timer.Interrupt.ReadAndClear())
+
+	timer.Cycle()
+	require.Equal(t, uint8(0x20), timer.Read8(0xFF05))
+	require.True(t, timer.Interrupt.ReadAndClear())
+}
+
+func TestTimerWriteDuringReloadDelayCancelsReload(t *testing.T) {
+	timer := newTimerController()
+	overflowTimer(timer)
+
+	timer.Write8(0xFF05, 0x42)
+	timer.Cycle()
+	require.Equal(t, uint8(0x42), timer.Read8(0xFF05))
+	require.False(t, timer.Interrupt.ReadAndClear())
+}
@@ -2,7 +2,7 @@ package emulator
 
 import (
 	"fmt"
-	"log"
+	"image"
 	"strings"
 )
 
@@ -79,11 +79,18 @@ const (
 	registerFF4B = 0xFF4B
 )
 
+// statUnusedBits are the unused bits of 0xFF41 (bit 7), which always read as 1
+const statUnusedBits byte = 0x80
+
 // shadePriority is used to determine which of two (or more) overlapping shades
 // should be shown on the LCD
 //
 // The shadePriority constants are ordered by their priority, so sp1 > sp2 means
 // that sp1 should be shown over sp2.
+//
+// Sprites with the OBJ-to-BG priority attribute set are drawn behind background
+// and window colors 1-3, but above color 0. Color 0 refers to the color number
+// in the tile, before the palette is applied.
 type shadePriority uint8
 
 const (
@@ -115,6 +122,10 @@ const (
 	lcdHeight = 144
 )
 
+// tileDataRows is the number of tile rows (2 bytes each) in the Tile Data
+// Table at 0x8000-0x97FF
+const tileDataRows = (0x9800 - 0x8000) / 2
+
 var (
 	flagVideoEnabled           = videoFlag{register: 0xFF40, bitOffset: 7}
 	flagWindowTileMapSelect    = videoFlag{register: 0xFF40, bitOffset: 6}
@@ -172,6 +183,13 @@ type videoController struct {
 	vram           []byte
 	vramAccessible bool
 
+	// tileRows caches the color numbers of the 8 pixels in every row of the
+	// Tile Data Table (0x8000-0x97FF), indexed by the row's offset in VRAM / 2.
+	// Rows are decoded on first use, and invalidated when written to (see
+	// writeVRAM).
+	tileRows        [tileDataRows][8]uint8
+	tileRowsDecoded [tileDataRows]bool
+
 	// oam contains the Sprite attribute table at 0xFE00 - 0xFE9F
 	//
 	// The Sprite attribute table contains up to 40 entries of 4 bytes
@@ -189,13 +207,40 @@ type videoController struct {
 	oam           []byte
 	oamAccessible bool
 
+	// dmaActive is true while an OAM DMA transfer is in progress, during which
+	// OAM is inaccessible to the program
+	dmaActive bool
+
 	nextCycle uint
 
-	// scanline data (snapshot at the start of a line)
-	screenY uint8
-	screenX uint8
-	windowY uint8
-	windowX uint8
+	// scanline data, where the scroll registers are snapshot at the start of a
+	// line (or on every dot if perDotRendering is enabled), and the window and
+	// platter registers on every dot
+	screenY        uint8
+	screenX        uint8
+	windowY        uint8
+	windowX        uint8
+	platterBG      byte
+	platterSprite0 byte
+	platterSprite1 byte
+
+	// lineSpriteColors and lineSpriteAttributes contain the color number and
+	// attributes of the sprite pixel drawn on every dot of the current line
+	// (color number 0 if none), such that the sprites are only searched once
+	// per line. lineSpritesFound is false until searched for the current line.
+	lineSpriteColors     [lcdWidth]byte
+	lineSpriteAttributes [lcdWidth]byte
+	lineSpritesFound     bool
+
+	// windowLine is the internal line counter of the window, which only
+	// advances on lines where the window was rendered
+	windowLine uint8
+
+	// windowRendered is true if the window was rendered on the current line
+	windowRendered bool
+
+	// perDotRendering refreshes the scanline data on every dot (see WithPerDotRendering)
+	perDotRendering bool
 
 	Frame Frame // row -> col -> color
 
@@ -203,9 +248,16 @@ type videoController struct {
 	// to screen.
 	FrameReady bool
 
-	// lastLineCompare stores the previous cycles result for line comparison, such
-	// that we can trigger interrupts only on changes to this value
-	lastLineCompare bool
+	// rowsChanged marks the rows of the frame in progress that differ from the
+	// previous frame, and dirtyRows lists the changed rows of the last
+	// completed frame (see DirtyRows)
+	rowsChanged [lcdHeight]bool
+	dirtyRows   []int
+
+	// statLine is the combined (ORed) state of all enabled STAT interrupt
+	// conditions in the previous cycle. The STAT interrupt is only triggered
+	// when the combined line goes from low to high (STAT blocking).
+	statLine bool
 
 	InterruptVBlank     *interruptSource // INT 40
 	InterruptLCDCStatus *interruptSource // INT 48
@@ -215,7 +267,7 @@ func newVideoController() *videoController {
 	v := &videoController{
 		registers:           make([]byte, 0xFF4B-0xFF40+1),
 		vram:                make([]byte, 0x9FFF-0x8000+1),
-		oam:                 make([]byte, 0xFEFF-0xFE00+1),
+		oam:                 make([]byte, 0xFE9F-0xFE00+1),
 		vramAccessible:      true,
 		oamAccessible:       true,
 		InterruptLCDCStatus: newInterruptSource(),
@@ -227,21 +279,54 @@ func newVideoController() *videoController {
 }
 
 func (s *videoController) clearFrame() {
-	frame := make([][]Shade, 144)
-	for row := 0; row < 144; row++ {
-		frame[row] = make([]Shade, 160)
+	s.Frame = newFrame(144, 160)
+}
+
+// newFrame returns a blank frame with the given number of rows and columns
+func newFrame(height, width int) Frame {
+	frame := make([][]Shade, height)
+	for row := 0; row < height; row++ {
+		frame[row] = make([]Shade, width)
 	}
 
-	s.Frame = frame
+	return frame
 }
 
 // Read8 is exposed in the address space, and may be read by the program
 func (s *videoController) Read8(address uint16) byte {
 	if s.isRegisterAddress(address) {
+		if address == uint16(registerFF41) {
+			return s.registers[address-offsetRegisters] | statUnusedBits
+		}
 		return s.registers[address-offsetRegisters]
 	}
 
+	if s.isUnusableAddress(address) {
+		return 0xFF
+	}
+
 	if s.isOAMAddress(address) {
+		if s.dmaActive || !s.oamAccessible {
+			return 0xFF
+		}
+		return s.oam[address-offsetOAM]
+	}
+
+	if !s.vramAccessible {
+		return 0xFF // locked by the PPU during mode 3
+	}
+	return s.vram[address-offsetVRAM]
+}
+
+// Peek8 reads VRAM, OAM, or a register for inspection (e.g. by a debugger),
+// regardless of whether the PPU currently locks access to it
+func (s *videoController) Peek8(address uint16) byte {
+	switch {
+	case s.isRegisterAddress(address):
+		return s.Read8(address)
+	case s.isUnusableAddress(address):
+		return 0xFF
+	case s.isOAMAddress(address):
 		return s.oam[address-offsetOAM]
 	}
 
@@ -252,32 +337,80 @@ func (s *videoController) Read8(address uint16) byte {
 func (s *videoController) Write8(address uint16, v byte) {
 	if s.isRegisterAddress(address) {
 		switch address {
+		case uint16(registerFF40):
+			wasEnabled := s.readFlag(flagVideoEnabled)
+			s.registers[address-offsetRegisters] = v
+			if !wasEnabled && s.readFlag(flagVideoEnabled) {
+				s.enable()
+			} else if wasEnabled && !s.readFlag(flagVideoEnabled) {
+				s.disable()
+			}
 		case registerFF41:
 			// lowest 3 bits are read-only
 			current := s.registers[address-offsetRegisters]
 			s.registers[address-offsetRegisters] = copyBits(v, current, 0, 1, 2)
 		case registerFF44:
 			// do nothing - address is read-only
-		case 0xFF46:
-			notImplemented("OAM DMA transfers not implemented")
+		case registerFF45:
+			s.registers[address-offsetRegisters] = v
+			if !s.readFlag(flagVideoEnabled) {
+				s.updateLineCompareFlag()
+			}
 		default:
 			s.registers[address-offsetRegisters] = v
 		}
 		return
 	}
 
+	if s.isUnusableAddress(address) {
+		return // writes are ignored
+	}
+
 	if s.isOAMAddress(address) {
-		if s.oamAccessible {
+		if s.oamAccessible && !s.dmaActive {
 			s.oam[address-offsetOAM] = v
 		}
 		return
 	}
 
 	if s.vramAccessible {
-		s.vram[address-offsetVRAM] = v
+		s.writeVRAM(address, v)
 	}
 }
 
+// enable restarts the PPU from the first dot of line 0 when the LCD is switched on
+//
+// The LY=LYC comparison for line 0 is treated as already observed, such that a
+// coincidence that was true when the LCD was switched on does not trigger a
+// (spurious) STAT interrupt on the first cycle.
+func (s *videoController) enable() {
+	s.nextCycle = 0
+	s.writeRegister(registerFF44, 0)
+	s.statLine = readBitN(s.readRegister(registerFF41), 6) && s.readRegister(registerFF45) == 0
+}
+
+// disable resets the PPU when the LCD is switched off
+//
+// LY reads 0 and STAT reports mode 0 until the LCD is switched on again, and
+// the coincidence flag follows writes to LYC (see updateLineCompareFlag).
+func (s *videoController) disable() {
+	s.nextCycle = 0
+	s.writeRegister(registerFF44, 0)
+	s.writeRegister(registerFF41, copyBits(s.readRegister(registerFF41), 0, 0, 1))
+	s.updateLineCompareFlag()
+
+	// VRAM and OAM are always accessible while the LCD is off
+	s.vramAccessible = true
+	s.oamAccessible = true
+}
+
+// updateLineCompareFlag sets the coincidence flag (bit 2 of 0xFF41) if LY=LYC,
+// for use while the PPU is not cycling
+func (s *videoController) updateLineCompareFlag() {
+	equal := s.readRegister(registerFF44) == s.readRegister(registerFF45)
+	s.writeRegister(registerFF41, writeBitN(s.readRegister(registerFF41), 2, equal))
+}
+
 // Cycle progresses the video rendering (i.e. PPU)
 //
 // The exact process used by the GB is not fully understood and some details, such
@@ -314,14 +447,16 @@ func (s *videoController) Cycle() {
 	interruptMode1Enabled := readBitN(status, 4)
 	interruptMode0Enabled := readBitN(status, 3)
 
-	lineCompare := s.readRegister(registerFF45)
-	lineCompareEqual := uint(lineCompare) == line
-	lineCompareChanged := lineCompareEqual != s.lastLineCompare
-
-	if interruptLineCompareEnabled && lineCompareEqual && lineCompareChanged {
-		s.InterruptLCDCStatus.Set()
+	// LY reads 0 for all but the first machine cycle of line 153, such that
+	// LY=LYC for LYC=0 is detected before the start of the next frame
+	ly := uint8(line)
+	if line == 153 && dot >= 4 {
+		ly = 0
 	}
 
+	lineCompare := s.readRegister(registerFF45)
+	lineCompareEqual := lineCompare == ly
+
 	s.FrameReady = false
 
 	var mode uint8
@@ -332,9 +467,7 @@ func (s *videoController) Cycle() {
 			// Entered VBLANK, signal that we have a complete frame ready
 			s.FrameReady = true
 			s.InterruptVBlank.Set()
-			if interruptMode1Enabled {
-				s.InterruptLCDCStatus.Set()
-			}
+			s.publishDirtyRows()
 		}
 		mode = 1
 		s.vramAccessible = true
@@ -342,40 +475,53 @@ func (s *videoController) Cycle() {
 	case dot < 80: // Scanning OAM
 		if dot == 0 {
 			// Start of scanline
-			s.screenY = s.readRegister(registerFF42)
-			s.screenX = s.readRegister(registerFF43)
-			s.windowY = s.readRegister(registerFF4A)
-			s.windowX = s.readRegister(registerFF4B)
-			if interruptMode2Enabled {
-				s.InterruptLCDCStatus.Set()
-			}
+			s.snapshotScrollRegisters()
+			s.advanceWindowLine(line)
+			s.lineSpritesFound = false
 		}
 		mode = 2
 		s.vramAccessible = true
 		s.oamAccessible = false
 	case dot < 80+168: // Write pixels
+		// Changes to the window and platter registers take effect mid-line
+		s.snapshotDotRegisters()
+
 		y := uint8(line)
 		x := uint8(dot - 80)
-		if x < 160 {
-			s.Frame[y][x] = s.calculateShade(y, x)
+		if s.perDotRendering {
+			// Raster effects may change the scroll registers mid-line, so use the
+			// values in effect at this exact dot rather than the start-of-line
+			// snapshot
+			s.snapshotScrollRegisters()
+			if x < 160 {
+				s.setShade(y, x, s.calculateShade(y, x))
+			}
+		} else if x < 160 {
+			s.setShade(y, x, s.renderShade(y, x))
 		}
 
 		mode = 3
 		s.vramAccessible = false
 		s.oamAccessible = false
 	default: // HBLANK
-		if dot == 80+168 {
-			// Start of HBLANK
-			if interruptMode0Enabled {
-				s.InterruptLCDCStatus.Set()
-			}
-		}
 		mode = 0
 		s.vramAccessible = true
 		s.oamAccessible = true
 	}
 
-	s.writeRegister(registerFF44, uint8(line))
+	// All STAT interrupt conditions are ORed into a single line, which only
+	// triggers an interrupt on a rising edge. For example, the LY=LYC interrupt
+	// does not trigger when entering a line while still in (an enabled) HBLANK.
+	statLine := (interruptLineCompareEnabled && lineCompareEqual) ||
+		(interruptMode0Enabled && mode == 0) ||
+		(interruptMode1Enabled && mode == 1) ||
+		(interruptMode2Enabled && mode == 2)
+	if statLine && !s.statLine {
+		s.InterruptLCDCStatus.Set()
+	}
+	s.statLine = statLine
+
+	s.writeRegister(registerFF44, ly)
 
 	// Set mode in 0xFF41 (lower two bits)
 	status = copyBits(status, mode, 0, 1)
@@ -383,6 +529,46 @@ func (s *videoController) Cycle() {
 	s.writeRegister(registerFF41, status)
 }
 
+// CurrentMode returns the mode of the PPU (0-3) as of the last cycle, see Cycle
+func (s *videoController) CurrentMode() uint8 {
+	return s.readRegister(registerFF41) & 0x03
+}
+
+// CurrentLine returns the line (LY) being processed by the PPU as of the last
+// cycle, with line 153 reported as 0 once LY resets (see Cycle)
+func (s *videoController) CurrentLine() uint8 {
+	return s.readRegister(registerFF44)
+}
+
+// advanceWindowLine moves the window's internal line counter to the next line
+// if the window was rendered on the previous line, or resets it at the start of
+// a frame
+func (s *videoController) advanceWindowLine(line uint) {
+	if line == 0 {
+		s.windowLine = 0
+	} else if s.windowRendered {
+		s.windowLine++
+	}
+	s.windowRendered = false
+}
+
+// snapshotScrollRegisters captures the scroll registers used when rendering the
+// current scanline
+func (s *videoController) snapshotScrollRegisters() {
+	s.screenY = s.readRegister(registerFF42)
+	s.screenX = s.readRegister(registerFF43)
+}
+
+// snapshotDotRegisters captures the window and platter registers used when
+// rendering the current dot
+func (s *videoController) snapshotDotRegisters() {
+	s.windowY = s.readRegister(registerFF4A)
+	s.windowX = s.readRegister(registerFF4B)
+	s.platterBG = s.readRegister(registerFF47)
+	s.platterSprite0 = s.readRegister(registerFF48)
+	s.platterSprite1 = s.readRegister(registerFF49)
+}
+
 // calculateShade determines the shade of color for given line, dot coordinate
 //
 // The GB display shows the contents of the screen (inner area shown below using "-").
@@ -398,6 +584,71 @@ func (s *videoController) Cycle() {
 // The shade is calculated by overlaying the background, window, and sprites,
 // with various rules of priority, transparrency, etc.
 func (s *videoController) calculateShade(line uint8, dot uint8) Shade {
+	spriteShade, spritePriority := s.calculateSpriteShade(uint16(line), uint16(dot))
+	return s.overlayShade(line, dot, spriteShade, spritePriority)
+}
+
+// renderShade determines the same shade as calculateShade, but only searches
+// the sprites on the line once (see findLineSprites), rather than for every dot
+func (s *videoController) renderShade(line uint8, dot uint8) Shade {
+	if !s.lineSpritesFound {
+		s.findLineSprites(int(line))
+		s.lineSpritesFound = true
+	}
+
+	spriteShade, spritePriority := Shade(transparrent), shadePriorityHidden
+	if colorNum := s.lineSpriteColors[dot]; colorNum != 0 && s.readFlag(flagSpriteDisplay) {
+		spriteShade, spritePriority = s.spriteShade(colorNum, s.lineSpriteAttributes[dot])
+	}
+
+	return s.overlayShade(line, dot, spriteShade, spritePriority)
+}
+
+// setShade sets the shade of a dot in the frame, marking the row as changed if
+// the shade differs from the previous frame
+func (s *videoController) setShade(line uint8, dot uint8, shade Shade) {
+	if s.Frame[line][dot] != shade {
+		s.Frame[line][dot] = shade
+		s.rowsChanged[line] = true
+	}
+}
+
+// publishDirtyRows makes the rows changed while rendering the frame available
+// through DirtyRows, and starts tracking changes for the next frame
+func (s *videoController) publishDirtyRows() {
+	dirty := []int{}
+	for row, changed := range s.rowsChanged {
+		if changed {
+			dirty = append(dirty, row)
+		}
+	}
+
+	s.dirtyRows = dirty
+	s.rowsChanged = [lcdHeight]bool{}
+}
+
+// markAllRowsDirty reports every row of the frame in progress as changed, e.g.
+// when the frame was replaced while loading a savestate
+func (s *videoController) markAllRowsDirty() {
+	for row := range s.rowsChanged {
+		s.rowsChanged[row] = true
+	}
+}
+
+// DirtyRows returns the rows (in ascending order) of the last completed frame
+// that changed compared to the frame before it, allowing a front-end to only
+// redraw those rows
+//
+// The returned slice must not be modified. Front-ends receive a copy with every
+// frame sent on FrameChan (see FrameUpdate), as the rows change while running.
+func (s *videoController) DirtyRows() []int {
+	return s.dirtyRows
+}
+
+// overlayShade determines the shade for given line, dot coordinate by
+// overlaying the window and background with the shade of the sprite at the
+// dot, see calculateShade
+func (s *videoController) overlayShade(line uint8, dot uint8, spriteShade Shade, spritePriority shadePriority) Shade {
 	matchShade := white // fallback color if no other layers apply
 	matchPriority := shadePriorityHidden
 
@@ -416,7 +667,6 @@ func (s *videoController) calculateShade(line uint8, dot uint8) Shade {
 		}
 	}
 
-	spriteShade, spritePriority := s.calculateSpriteShade(uint16(line), uint16(dot))
 	if spritePriority > matchPriority {
 		matchShade = spriteShade
 		matchPriority = spritePriority
@@ -465,8 +715,7 @@ func (s *videoController) calculateBackgroundShade(line uint8, dot uint8) (Shade
 		shadePriority = shadePriorityBackgroundWindowZero
 	}
 
-	shadePlatter := s.readRegister(registerFF47)
-	return lookupShadeInPlatter(shadePlatter, colorNum), shadePriority
+	return lookupShadeInPlatter(s.platterBG, colorNum), shadePriority
 }
 
 // calculateWindowShade determines the shade for the window layer
@@ -489,18 +738,24 @@ func (s *videoController) calculateWindowShade(line uint8, dot uint8) (Shade, sh
 		return transparrent, shadePriorityHidden
 	}
 
-	windowStartY := int(s.readRegister(registerFF4A))
-	windowStartX := int(s.readRegister(registerFF4B)) - 7
+	// The window starts at WX-7, such that:
+	// - WX=7 starts the window at the left edge of the screen
+	// - WX<7 starts the window at the left edge, with the leftmost 7-WX pixels
+	//   of the window clipped
+	// - WX>=167 starts the window beyond the right edge, hiding it
+	//
+	// NOTE: hardware quirks for WX=0 and WX=166 are not emulated
+	windowStartY := int(s.windowY)
+	windowStartX := int(s.windowX) - 7
 
 	if int(line) < windowStartY || int(dot) < windowStartX {
 		return transparrent, shadePriorityHidden
 	}
 
-	if windowStartX < 0 || windowStartX == 159 {
-		log.Printf("Warning: window X position set to %d which triggers a hardware bug that is not emulated", windowStartX)
-	}
-
-	windowY := uint16(int(line) - windowStartY)
+	// The window uses its own line counter rather than the screen line, such
+	// that it continues where it left off if hidden for a number of lines
+	s.windowRendered = true
+	windowY := uint16(s.windowLine)
 	windowX := uint16(int(dot) - windowStartX)
 
 	// Find tile # in Window Tile Map. Every tile in the window tile map
@@ -517,8 +772,7 @@ func (s *videoController) calculateWindowShade(line uint8, dot uint8) (Shade, sh
 		shadePriority = shadePriorityBackgroundWindowZero
 	}
 
-	shadePlatter := s.readRegister(registerFF47)
-	return lookupShadeInPlatter(shadePlatter, colorNum), shadePriority
+	return lookupShadeInPlatter(s.platterBG, colorNum), shadePriority
 }
 
 func (s *videoController) calculateSpriteShade(line uint16, dot uint16) (Shade, shadePriority) {
@@ -532,11 +786,20 @@ func (s *videoController) calculateSpriteShade(line uint16, dot uint16) (Shade,
 		spriteHeight = 16
 	}
 
-	spritesFoundOnLine := 0
+	// Search for the highest priority sprite with a (non-transparent) pixel at
+	// line, dot
+	//
+	// Rules:
+	// - At most 10 sprites are selected for a line, being the first 10 sprites
+	//   (by OAM index) that overlap with the line, regardless of their x-coordinate
+	// - Sprites are priorited by their x-coordinate (lower is better)
+	// - Sprites with the same x-coordinate are priorited on their spriteIdx (lower is better)
+	// - Transparent pixels (color 0) are skipped, showing lower priority sprites
+	sprites, count := s.spritesOnLine(int(line), spriteHeight)
 
 	match := false
-	var matchY, matchX int
-	var matchTileNumber byte
+	var matchX int
+	var matchColorNum byte
 
 	// Bit7   OBJ-to-BG Priority (0=OBJ Above BG, 1=OBJ Behind BG color 1-3) Used for both BG and Window. BG color 0 is always behind OBJ)
 	// Bit6   Y flip          (0=Normal, 1=Vertically mirrored)
@@ -544,80 +807,138 @@ func (s *videoController) calculateSpriteShade(line uint16, dot uint16) (Shade,
 	// Bit4   Palette number  (0=OBP0, 1=OBP1)
 	var matchAttributes byte
 
-	// Search for the highest priority sprite with a pixel at line, dot
-	//
-	// Rules:
-	// - At most 10 sprites may be evaluated that overlap with line
-	// - Sprites are priorited by their x-coordinate (lower is better)
-	// - Sprites with the same x-coordinate are priorited on their spriteIdx (lower is better)
-	for spriteIdx := 0; spriteIdx < 40; spriteIdx++ {
-		if spritesFoundOnLine >= 10 {
-			continue // evaluate at most 10 sprites on the current line
+	for _, spriteIdx := range sprites[:count] {
+		offset := spriteIdx * 4        // each sprite is 4 bytes long
+		y := int(s.oam[offset+0]) - 16 // y is offset by 16 such that 0 = hide sprite
+		x := int(s.oam[offset+1]) - 8  // x is offset by 8 such that 0 = hide sprite
+		tileNumber := s.oam[offset+2]
+		attributes := s.oam[offset+3]
+
+		if int(dot) < x || x+spriteWidth <= int(dot) {
+			continue // sprite does not cover dot
+		}
+		if match && matchX <= x {
+			continue // existing sprite has higher priority
 		}
 
+		colorNum := s.lookupSpriteTile(int(line)-y, int(dot)-x, spriteHeight, tileNumber, attributes)
+		if colorNum == 0 {
+			continue // transparent
+		}
+
+		match = true
+		matchX = x
+		matchColorNum = colorNum
+		matchAttributes = attributes
+	}
+
+	if !match {
+		return transparrent, shadePriorityHidden
+	}
+
+	return s.spriteShade(matchColorNum, matchAttributes)
+}
+
+// spriteShade returns the shade and priority of a (non-transparent) sprite
+// pixel with color number colorNum
+func (s *videoController) spriteShade(colorNum byte, attributes byte) (Shade, shadePriority) {
+	shadePriority := shadePrioritySpriteHigh
+	if readBitN(attributes, 7) { // sprite behind background colors 1-3
+		shadePriority = shadePrioritySpriteLow
+	}
+
+	shadePlatter := s.platterSprite0
+	if readBitN(attributes, 4) {
+		shadePlatter = s.platterSprite1
+	}
+
+	return lookupShadeInPlatter(shadePlatter, colorNum), shadePriority
+}
+
+// findLineSprites finds the sprite pixel drawn on every dot of line, like
+// calculateSpriteShade does for a single dot, and stores its color number and
+// attributes in lineSpriteColors and lineSpriteAttributes
+//
+// Dots not covered by a (non-transparent) sprite pixel have color number 0. The
+// platter is applied when rendering each dot, such that platter changes take
+// effect mid-line.
+func (s *videoController) findLineSprites(line int) {
+	s.lineSpriteColors = [lcdWidth]byte{}
+
+	spriteHeight := 8
+	if s.readFlag(flagSpriteSize) { // 0=8x8 1=8x16
+		spriteHeight = 16
+	}
+
+	// matchX is the x-coordinate of the sprite drawn at every dot, where
+	// sprites with a lower x-coordinate have priority. The sprites are visited
+	// in OAM order, so of sprites with the same x-coordinate the first one wins.
+	var matchX [lcdWidth]int
+
+	sprites, count := s.spritesOnLine(line, spriteHeight)
+	for _, spriteIdx := range sprites[:count] {
 		offset := spriteIdx * 4        // each sprite is 4 bytes long
 		y := int(s.oam[offset+0]) - 16 // y is offset by 16 such that 0 = hide sprite
 		x := int(s.oam[offset+1]) - 8  // x is offset by 8 such that 0 = hide sprite
 		tileNumber := s.oam[offset+2]
 		attributes := s.oam[offset+3]
 
-		if y <= int(line) && int(line) < y+spriteHeight {
-			spritesFoundOnLine++
-			if x <= int(dot) && int(dot) < x+spriteWidth {
-				if match && matchX < x {
-					continue // existing sprite has higher priority
-				}
-
-				match = true
-				matchY = y
-				matchX = x
-				matchTileNumber = tileNumber
-				matchAttributes = attributes
+		for dot := x; dot < x+8; dot++ {
+			if dot < 0 || dot >= lcdWidth {
+				continue // off-screen
+			}
+			if s.lineSpriteColors[dot] != 0 && matchX[dot] <= x {
+				continue // existing sprite has higher priority
+			}
+
+			colorNum := s.lookupSpriteTile(line-y, dot-x, spriteHeight, tileNumber, attributes)
+			if colorNum == 0 {
+				continue // transparent
 			}
+
+			matchX[dot] = x
+			s.lineSpriteColors[dot] = colorNum
+			s.lineSpriteAttributes[dot] = attributes
 		}
 	}
+}
 
-	if !match {
-		return transparrent, shadePriorityHidden
+// spritesOnLine returns the indexes of the sprites (at most 10) selected for
+// rendering on line, which are the first sprites in OAM that overlap the line
+func (s *videoController) spritesOnLine(line int, spriteHeight int) (sprites [10]int, count int) {
+	for spriteIdx := 0; spriteIdx < 40 && count < len(sprites); spriteIdx++ {
+		y := int(s.oam[spriteIdx*4]) - 16 // y is offset by 16 such that 0 = hide sprite
+		if y <= line && line < y+spriteHeight {
+			sprites[count] = spriteIdx
+			count++
+		}
 	}
 
-	tileY := uint8(int(line) - matchY)
-	tileX := uint8(int(dot) - matchX)
+	return sprites, count
+}
 
-	if readBitN(matchAttributes, 6) { // y-flip
-		tileY = uint8(spriteHeight) - 1 - tileY
+// lookupSpriteTile returns the color number of the pixel at tileY, tileX
+// within a sprite, taking flipping and 8x16 sprites into account
+func (s *videoController) lookupSpriteTile(tileY int, tileX int, spriteHeight int, tileNumber byte, attributes byte) byte {
+	if readBitN(attributes, 6) { // y-flip
+		tileY = spriteHeight - 1 - tileY
 	}
-	if readBitN(matchAttributes, 5) { // x-flip
-		tileX = uint8(spriteWidth) - 1 - tileX
+	if readBitN(attributes, 5) { // x-flip
+		tileX = 7 - tileX
 	}
 
 	if spriteHeight == 16 {
 		// stacked tile mode, in this mode the upper tile has the lower bit in
 		// tileNumber forced to 0, and the lower tile has the lower bit forced to 1
 		if tileY <= 7 {
-			matchTileNumber = matchTileNumber & 0xFE
+			tileNumber = tileNumber & 0xFE
 		} else {
-			matchTileNumber = matchTileNumber | 0x01
+			tileNumber = tileNumber | 0x01
 			tileY = tileY - 8
 		}
 	}
 
-	colorNum := s.lookupTile(tileY, tileX, matchTileNumber, true)
-	if colorNum == 0 {
-		return transparrent, shadePriorityHidden
-	}
-
-	shadePriority := shadePrioritySpriteHigh
-	if readBitN(matchAttributes, 7) { // sprite behind background colors 1-3
-		shadePriority = shadePrioritySpriteLow
-	}
-
-	shadePlatter := s.readRegister(registerFF48) // platter 0
-	if readBitN(matchAttributes, 4) {
-		shadePlatter = s.readRegister(registerFF49) // platter 1
-	}
-
-	return lookupShadeInPlatter(shadePlatter, colorNum), shadePriority
+	return s.lookupTile(uint8(tileY), uint8(tileX), tileNumber, true)
 }
 
 // lookupTileNumber returns the tile # for a given absolute x, y
@@ -651,24 +972,102 @@ func (s *videoController) lookupTile(tileY, tileX uint8, tileNumber byte, tileDa
 	}
 
 	rowAddress := offsetAddress(tileAddress, 2*int16(tileY)) // 2 bytes for every row
+	return s.decodeTileRow(rowAddress)[tileX]
+}
+
+// decodeTileRow returns the color numbers of the 8 pixels in the tile row at
+// rowAddress, decoding the row only if not already cached
+func (s *videoController) decodeTileRow(rowAddress uint16) *[8]uint8 {
+	idx := (rowAddress - offsetVRAM) / 2
+	row := &s.tileRows[idx]
+	if s.tileRowsDecoded[idx] {
+		return row
+	}
+
 	lowerByte := s.readVRAM(rowAddress)
 	higherByte := s.readVRAM(rowAddress + 1)
 
-	// The leftmost pixel is represented by the rightmost (index-0) bit, thus the "7-"
-	lowerBit := readBitN(lowerByte, 7-tileX)
-	higherBit := readBitN(higherByte, 7-tileX)
+	for tileX := uint8(0); tileX < 8; tileX++ {
+		// The leftmost pixel is represented by the rightmost (index-0) bit, thus the "7-"
+		lowerBit := readBitN(lowerByte, 7-tileX)
+		higherBit := readBitN(higherByte, 7-tileX)
+
+		colorNum := uint8(0)
+		colorNum = writeBitN(colorNum, 0, lowerBit)
+		colorNum = writeBitN(colorNum, 1, higherBit)
+		row[tileX] = colorNum
+	}
+	s.tileRowsDecoded[idx] = true
+
+	return row
+}
+
+// invalidateTileRows drops all decoded tile rows, which is required after
+// modifying vram without going through writeVRAM (e.g. loading a savestate)
+func (s *videoController) invalidateTileRows() {
+	s.tileRowsDecoded = [tileDataRows]bool{}
+}
+
+// RenderTileData renders all 384 tiles in VRAM (0x8000-0x97FF) for debugging
+//
+// The tiles are laid out in 24 rows of 16 tiles, i.e. a 128x192 frame, in the
+// order they are stored in VRAM. The shades are the color numbers of the tile
+// data, not mapped through a palette.
+func (s *videoController) RenderTileData() Frame {
+	frame := newFrame(24*8, 16*8)
+	for tile := 0; tile < 384; tile++ {
+		// Tiles 0-255 are reachable using 8000 addressing, and tiles 256-383
+		// using 8800 addressing with tile numbers 0-127
+		tileNumber := byte(tile)
+		tileDataSelect := tile < 256
+
+		for tileY := uint8(0); tileY < 8; tileY++ {
+			for tileX := uint8(0); tileX < 8; tileX++ {
+				colorNum := s.lookupTile(tileY, tileX, tileNumber, tileDataSelect)
+				frame[tile/16*8+int(tileY)][tile%16*8+int(tileX)] = Shade(colorNum)
+			}
+		}
+	}
+
+	return frame
+}
 
-	colorNum := uint8(0)
-	colorNum = writeBitN(colorNum, 0, lowerBit)
-	colorNum = writeBitN(colorNum, 1, higherBit)
+// RenderTileMap renders the full 256x256 background of a tile map for
+// debugging
+//
+// tileMapSelect selects the tile map at 0x9800 (false) or 0x9C00 (true). Tile
+// data is looked up using the addressing mode currently selected in LCDC, and
+// the shades are the color numbers of the tile data, not mapped through a
+// palette.
+func (s *videoController) RenderTileMap(tileMapSelect bool) Frame {
+	frame := newFrame(256, 256)
+	tileDataSelect := s.readFlag(flagBGWindowTileDataSelect)
+	for y := uint16(0); y < 256; y++ {
+		for x := uint16(0); x < 256; x++ {
+			tileNumber := s.lookupTileNumber(y, x, tileMapSelect)
+			colorNum := s.lookupTile(uint8(y%8), uint8(x%8), tileNumber, tileDataSelect)
+			frame[y][x] = Shade(colorNum)
+		}
+	}
 
-	return colorNum
+	return frame
 }
 
 func (s *videoController) readVRAM(address uint16) byte {
 	return s.vram[address-offsetVRAM]
 }
 
+// writeVRAM writes to VRAM, bypassing any access restrictions
+//
+// All writes to VRAM must go through writeVRAM, such that any state derived
+// from VRAM contents (e.g. decoded tiles) can be kept in sync.
+func (s *videoController) writeVRAM(address uint16, v byte) {
+	s.vram[address-offsetVRAM] = v
+	if idx := (address - offsetVRAM) / 2; idx < tileDataRows {
+		s.tileRowsDecoded[idx] = false
+	}
+}
+
 func (s *videoController) readFlag(f videoFlag) bool {
 	return readBitN(s.readRegister(f.register), f.bitOffset)
 }
@@ -686,7 +1085,13 @@ func (s *videoController) isRegisterAddress(address uint16) bool {
 }
 
 func (s *videoController) isOAMAddress(address uint16) bool {
-	return 0xFE00 <= address && address <= 0xFEFF
+	return 0xFE00 <= address && address <= 0xFE9F
+}
+
+// isUnusableAddress returns true for the unusable region following OAM
+// (0xFEA0 - 0xFEFF), which reads as 0xFF and ignores writes
+func (s *videoController) isUnusableAddress(address uint16) bool {
+	return 0xFEA0 <= address && address <= 0xFEFF
 }
 
 func (s *videoController) String() string {
@@ -707,6 +1112,26 @@ func (f Frame) Render() string {
 	return sb.String()
 }
 
+// ToImage converts the frame to an image using the colors of palette, i.e. a
+// 160x144 image for frames drawn on the LCD screen
+//
+// The image is not scaled, which is left to the caller.
+func (f Frame) ToImage(palette Palette) *image.RGBA {
+	width := 0
+	if len(f) > 0 {
+		width = len(f[0])
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, len(f)))
+	for y, row := range f {
+		for x, shade := range row {
+			img.SetRGBA(x, y, palette.Color(shade))
+		}
+	}
+
+	return img
+}
+
 // lookupShadeInPlatter returns the shade encoded for a colorNum in a platter
 //
 // A platter contains 4 shades, 2 bits each, with color 0 encoded using the
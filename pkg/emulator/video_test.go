@@ -1,6 +1,8 @@
 package emulator
 
 import (
+	"image"
+	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -67,8 +69,950 @@ func TestVideoYLineResetsBackToZeroAfterFullFrame(t *testing.T) {
 	require.Equal(t, uint8(0), video.Read8(registerFF44)) // FF44 = Y-offset
 }
 
+func TestVideoLYDuringVBlank(t *testing.T) {
+	video := newVideoController()
+
+	video.Write8(uint16(registerFF40), 0x80) // Enable Video
+
+	progressCycles(video, 456*144+1)
+	require.Equal(t, uint8(144), video.Read8(registerFF44), "expected LY=144 at start of VBLANK")
+	require.Equal(t, uint8(1), video.Read8(registerFF41)&0x03)
+
+	for line := 145; line <= 153; line++ {
+		progressCycles(video, 456)
+		require.Equal(t, uint8(line), video.Read8(registerFF44))
+		require.Equal(t, uint8(1), video.Read8(registerFF41)&0x03)
+	}
+
+	// LY reads 0 after the first machine cycle of line 153
+	progressCycles(video, 3)
+	require.Equal(t, uint8(153), video.Read8(registerFF44))
+	progressCycles(video, 1)
+	require.Equal(t, uint8(0), video.Read8(registerFF44))
+	require.Equal(t, uint8(1), video.Read8(registerFF41)&0x03, "expected VBLANK to continue")
+
+	progressCycles(video, 456-4)
+	require.Equal(t, uint8(0), video.Read8(registerFF44))
+	require.Equal(t, uint8(2), video.Read8(registerFF41)&0x03, "expected next frame to start")
+}
+
+func TestVideoLineCompareZeroMatchesDuringLine153(t *testing.T) {
+	video := newVideoController()
+
+	video.Write8(uint16(registerFF45), 0)    // LYC=0
+	video.Write8(uint16(registerFF41), 0x40) // Enable LY=LYC interrupt
+	video.Write8(uint16(registerFF40), 0x80) // Enable Video
+
+	progressCycles(video, 456*153+4)
+	video.InterruptLCDCStatus.ReadAndClear()
+
+	progressCycles(video, 1)
+	require.True(t, video.InterruptLCDCStatus.ReadAndClear(), "expected interrupt when LY reads 0 on line 153")
+	require.True(t, readBitN(video.Read8(registerFF41), 2))
+
+	progressCycles(video, 456)
+	require.False(t, video.InterruptLCDCStatus.ReadAndClear(), "expected no second interrupt at line 0")
+}
+
+func TestVideoSTATInterruptSourcesShareSingleLine(t *testing.T) {
+	video := newVideoController()
+
+	video.Write8(uint16(registerFF45), 5)    // LYC=5
+	video.Write8(uint16(registerFF41), 0x48) // Enable LY=LYC and mode 0 (HBLANK) interrupts
+	video.Write8(uint16(registerFF40), 0x80) // Enable Video
+
+	type position struct {
+		line, dot int
+	}
+
+	var interrupts []position
+	for line := 0; line < 8; line++ {
+		for dot := 0; dot < 456; dot++ {
+			video.Cycle()
+			if video.InterruptLCDCStatus.ReadAndClear() {
+				interrupts = append(interrupts, position{line, dot})
+			}
+		}
+	}
+
+	// Line 5 starts while the line is still high from the HBLANK of line 4,
+	// and the HBLANK of line 5 overlaps with LY=LYC
+	require.Equal(t, []position{
+		{0, 248}, {1, 248}, {2, 248}, {3, 248}, {4, 248},
+		{6, 248}, {7, 248},
+	}, interrupts)
+}
+
+func TestVideoSTATInterruptOnLineCompareAfterMode2(t *testing.T) {
+	video := newVideoController()
+
+	video.Write8(uint16(registerFF45), 5)    // LYC=5
+	video.Write8(uint16(registerFF41), 0x60) // Enable LY=LYC and mode 2 (OAM) interrupts
+	video.Write8(uint16(registerFF40), 0x80) // Enable Video
+
+	progressCycles(video, 456*5)
+	video.InterruptLCDCStatus.ReadAndClear()
+
+	// Mode 2 and LY=LYC become true at the same time, triggering a single
+	// interrupt
+	video.Cycle()
+	require.True(t, video.InterruptLCDCStatus.ReadAndClear())
+	progressCycles(video, 455)
+	require.False(t, video.InterruptLCDCStatus.ReadAndClear())
+}
+
 func progressCycles(v *videoController, cycles uint) {
 	for i := uint(0); i < cycles; i++ {
 		v.Cycle()
 	}
 }
+
+func TestVideoPlatterChangeMidLine(t *testing.T) {
+	tests := []struct {
+		name            string
+		perDotRendering bool
+	}{
+		{name: "per-line rendering", perDotRendering: false},
+		{name: "per-dot rendering", perDotRendering: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			video := newVideoController()
+			video.perDotRendering = tt.perDotRendering
+
+			video.Write8(registerFF47, 0x00)         // color 0 = white
+			video.Write8(uint16(registerFF40), 0x91) // Enable Video and BG, 8000 addressing
+
+			progressCycles(video, 80+80)     // OAM scan + left half of line 0
+			video.Write8(registerFF47, 0x03) // color 0 = black
+			progressCycles(video, 456-80-80)
+
+			for x := 0; x < 80; x++ {
+				require.Equal(t, white, video.Frame[0][x], "unexpected shade at x=%d", x)
+			}
+			for x := 80; x < 160; x++ {
+				require.Equal(t, black, video.Frame[0][x], "expected platter in effect at x=%d", x)
+			}
+		})
+	}
+}
+
+func TestVideoScrollChangeMidLine(t *testing.T) {
+	tests := []struct {
+		name            string
+		perDotRendering bool
+		wantRightShade  Shade
+	}{
+		{
+			name:            "per-line rendering uses scroll from start of line",
+			perDotRendering: false,
+			wantRightShade:  white,
+		},
+		{
+			name:            "per-dot rendering uses scroll in effect at each dot",
+			perDotRendering: true,
+			wantRightShade:  black,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			video := newVideoController()
+			video.perDotRendering = tt.perDotRendering
+
+			// The 2nd row of the background shows tile 1 (solid black)
+			for i := uint16(0); i < 16; i++ {
+				video.Write8(0x8010+i, 0xFF)
+			}
+			for i := uint16(0); i < 32; i++ {
+				video.Write8(0x9800+32+i, 0x01)
+			}
+			video.Write8(registerFF47, 0xE4)         // color 0 = white, color 3 = black
+			video.Write8(uint16(registerFF40), 0x91) // Enable Video and BG, 8000 addressing
+
+			progressCycles(video, 80+80)     // OAM scan + left half of line 0
+			video.Write8(registerFF42, 0x08) // SCY=8
+			progressCycles(video, 456-80-80)
+
+			for x := 0; x < 80; x++ {
+				require.Equal(t, white, video.Frame[0][x], "unexpected shade at x=%d", x)
+			}
+			for x := 80; x < 160; x++ {
+				require.Equal(t, tt.wantRightShade, video.Frame[0][x], "unexpected shade at x=%d", x)
+			}
+		})
+	}
+}
+
+func TestVideoEnableDoesNotTriggerSpuriousLineCompareInterrupt(t *testing.T) {
+	video := newVideoController()
+	video.Write8(registerFF45, 0)    // LYC=0
+	video.Write8(registerFF41, 0x40) // Enable LYC=LY interrupt
+
+	// Leave the PPU in the middle of a frame before switching it off again
+	video.Write8(uint16(registerFF40), 0x80)
+	progressCycles(video, 456*50)
+	video.Write8(uint16(registerFF40), 0x00)
+	video.InterruptLCDCStatus.ReadAndClear()
+
+	video.Write8(uint16(registerFF40), 0x80)
+	video.Cycle()
+	require.Equal(t, uint8(0), video.Read8(registerFF44))
+	require.True(t, readBitN(video.Read8(registerFF41), 2), "expected coincidence flag to be set")
+	require.False(t, video.InterruptLCDCStatus.ReadAndClear())
+
+	// The interrupt triggers once LY=LYC is reached again, when LY reads 0
+	// early during line 153
+	progressCycles(video, 456*153+3)
+	require.False(t, video.InterruptLCDCStatus.ReadAndClear())
+	video.Cycle()
+	require.True(t, video.InterruptLCDCStatus.ReadAndClear())
+}
+
+func TestLookupTileIn8800AddressingMode(t *testing.T) {
+	tests := []struct {
+		name        string
+		tileNumber  byte
+		tileAddress uint16
+	}{
+		{
+			name:        "tile 0 resolves to 0x9000",
+			tileNumber:  0,
+			tileAddress: 0x9000,
+		},
+		{
+			name:        "tile 127 resolves to 0x97F0",
+			tileNumber:  127,
+			tileAddress: 0x97F0,
+		},
+		{
+			name:        "tile 128 (-128) resolves to 0x8800",
+			tileNumber:  128,
+			tileAddress: 0x8800,
+		},
+		{
+			name:        "tile 255 (-1) resolves to 0x8FF0",
+			tileNumber:  255,
+			tileAddress: 0x8FF0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			video := newVideoController()
+
+			// Row 1 of the tile is color 3 for the leftmost pixel, color 1 for the
+			// rightmost pixel, and color 0 otherwise.
+			video.Write8(tt.tileAddress+2, 0x81) // 10000001
+			video.Write8(tt.tileAddress+3, 0x80) // 10000000
+
+			require.Equal(t, uint8(0), video.lookupTile(0, 0, tt.tileNumber, false))
+			require.Equal(t, uint8(3), video.lookupTile(1, 0, tt.tileNumber, false))
+			require.Equal(t, uint8(0), video.lookupTile(1, 1, tt.tileNumber, false))
+			require.Equal(t, uint8(1), video.lookupTile(1, 7, tt.tileNumber, false))
+
+			// Render the tile as the first background tile, using 8800 addressing
+			video.Write8(0x9800, tt.tileNumber)
+			video.Write8(registerFF47, 0xE4)         // 11100100 - color N = shade N
+			video.Write8(uint16(registerFF40), 0x81) // Enable Video and BG, 8800 addressing
+			progressCycles(video, 456*2)
+
+			require.Equal(t, black, video.Frame[1][0])
+			require.Equal(t, white, video.Frame[1][1])
+			require.Equal(t, grayLight, video.Frame[1][7])
+		})
+	}
+}
+
+func TestVideoRendersUpdatedTileDataAfterVRAMWrite(t *testing.T) {
+	video := newVideoController()
+	video.Write8(registerFF47, 0xE4) // 11100100 - color N = shade N
+
+	// Background uses tile 0 everywhere, with the first row set to color 1
+	video.Write8(0x8000, 0xFF)
+	video.Write8(uint16(registerFF40), 0x91) // Enable Video and BG, 8000 addressing
+
+	progressCycles(video, 456*154)
+	require.Equal(t, grayLight, video.Frame[0][0])
+
+	// Update tile 0 during VBLANK, changing the first row to color 2
+	progressCycles(video, 456*144)
+	video.Write8(0x8000, 0x00)
+	video.Write8(0x8001, 0xFF)
+
+	progressCycles(video, 456*10+456)
+	require.Equal(t, grayDark, video.Frame[0][0])
+}
+
+func TestVideoModeSequenceAcrossFrame(t *testing.T) {
+	video := newVideoController()
+	video.Write8(registerFF41, 0x20) // Enable mode 2 interrupt
+	video.Write8(uint16(registerFF40), 0x80)
+
+	mode2Interrupts := 0
+	for line := 0; line < 154; line++ {
+		var modes []uint8
+		for dot := 0; dot < 456; dot++ {
+			video.Cycle()
+			ly := uint8(line)
+			if line == 153 && dot >= 4 {
+				ly = 0 // LY reads 0 after the first machine cycle of line 153
+			}
+			require.Equal(t, ly, video.Read8(registerFF44), "unexpected LY at line %d, dot %d", line, dot)
+
+			mode := video.Read8(registerFF41) & 0x03
+			if len(modes) == 0 || modes[len(modes)-1] != mode {
+				modes = append(modes, mode)
+			}
+			if video.InterruptLCDCStatus.ReadAndClear() {
+				require.Equal(t, 0, dot, "expected mode 2 interrupt at the start of line %d", line)
+				mode2Interrupts++
+			}
+		}
+
+		if line < 144 {
+			require.Equal(t, []uint8{2, 3, 0}, modes, "unexpected mode sequence for line %d", line)
+		} else {
+			require.Equal(t, []uint8{1}, modes, "unexpected mode sequence for line %d", line)
+		}
+	}
+	require.Equal(t, 144, mode2Interrupts)
+
+	// The next frame starts with OAM scan of line 0
+	video.Cycle()
+	require.Equal(t, uint8(0), video.Read8(registerFF44))
+	require.Equal(t, uint8(2), video.Read8(registerFF41)&0x03)
+	require.True(t, video.InterruptLCDCStatus.ReadAndClear())
+}
+
+func TestVideoRendersWindowAtWXAndWY(t *testing.T) {
+	video := newVideoController()
+	video.Write8(registerFF47, 0xE4) // 11100100 - color N = shade N
+	video.Write8(registerFF4A, 10)   // WY
+	video.Write8(registerFF4B, 7+8)  // WX
+
+	// Tile 1 is color 3 everywhere, and is placed in the upper left corner of
+	// the window tile map (0x9C00). Everything else uses tile 0 (color 0).
+	for address := uint16(0x8010); address < 0x8020; address++ {
+		video.Write8(address, 0xFF)
+	}
+	video.Write8(0x9C00, 0x01)
+	video.Write8(uint16(registerFF40), 0xF1) // Enable Video, window (9C00 map), BG, 8000 addressing
+
+	progressCycles(video, 456*154)
+
+	for y := 0; y < 144; y++ {
+		for x := 0; x < 160; x++ {
+			want := white
+			if 10 <= y && y < 18 && 8 <= x && x < 16 {
+				want = black
+			}
+			require.Equal(t, want, video.Frame[y][x], "unexpected shade at x=%d, y=%d", x, y)
+		}
+	}
+}
+
+func TestVideoWindowLineCounterOnlyAdvancesWhenWindowIsRendered(t *testing.T) {
+	video := newVideoController()
+	video.Write8(registerFF47, 0xE4) // 11100100 - color N = shade N
+	video.Write8(registerFF4A, 0)    // WY
+	video.Write8(registerFF4B, 7)    // WX
+
+	// The first row of tiles in the window uses tile 1 (color 3), the
+	// remaining rows use tile 0 (color 0)
+	for address := uint16(0x8010); address < 0x8020; address++ {
+		video.Write8(address, 0xFF)
+	}
+	for address := uint16(0x9C00); address < 0x9C20; address++ {
+		video.Write8(address, 0x01)
+	}
+	video.Write8(uint16(registerFF40), 0xF1) // Enable Video, window (9C00 map), BG, 8000 addressing
+
+	// Render window lines 0-3 on screen lines 0-3, hide the window on screen
+	// lines 4-11, and render window lines 4-7 on screen lines 12-15
+	progressCycles(video, 456*4)
+	video.Write8(uint16(registerFF40), 0xD1) // Disable window
+	progressCycles(video, 456*8)
+	video.Write8(uint16(registerFF40), 0xF1) // Enable window
+	progressCycles(video, 456*(154-12))
+
+	for y := 0; y < 144; y++ {
+		want := white
+		if y < 4 || (12 <= y && y < 16) {
+			want = black
+		}
+		require.Equal(t, want, video.Frame[y][0], "unexpected shade at y=%d", y)
+	}
+}
+
+func TestVideoWindowLeftBoundary(t *testing.T) {
+	tests := []struct {
+		name       string
+		wx         byte
+		wantShades []Shade // expected shades of the first 12 pixels of the line
+	}{
+		{
+			name:       "WX=7 starts window at left edge",
+			wx:         7,
+			wantShades: []Shade{black, black, black, black, white, white, white, white, black, black, black, black},
+		},
+		{
+			name:       "WX=3 clips leftmost 4 pixels of window",
+			wx:         3,
+			wantShades: []Shade{white, white, white, white, black, black, black, black, white, white, white, white},
+		},
+		{
+			name:       "WX=11 starts window at x=4",
+			wx:         11,
+			wantShades: []Shade{grayLight, grayLight, grayLight, grayLight, black, black, black, black, white, white, white, white},
+		},
+		{
+			name:       "WX=167 hides window",
+			wx:         167,
+			wantShades: []Shade{grayLight, grayLight, grayLight, grayLight, grayLight, grayLight, grayLight, grayLight, grayLight, grayLight, grayLight, grayLight},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			video := newVideoController()
+			video.Write8(registerFF47, 0xE4) // 11100100 - color N = shade N
+			video.Write8(registerFF4A, 0)    // WY
+			video.Write8(registerFF4B, tt.wx)
+
+			// Tile 1 (window) has color 3 in the left half and color 0 in the right
+			// half. Tile 2 (background) has color 1 everywhere.
+			for address := uint16(0x8010); address < 0x8020; address++ {
+				video.Write8(address, 0xF0)
+			}
+			for address := uint16(0x8020); address < 0x8030; address += 2 {
+				video.Write8(address, 0xFF)
+			}
+			for offset := uint16(0); offset < 0x400; offset++ {
+				video.Write8(0x9800+offset, 0x02)
+				video.Write8(0x9C00+offset, 0x01)
+			}
+			video.Write8(uint16(registerFF40), 0xF1) // Enable Video, window (9C00 map), BG (9800 map), 8000 addressing
+
+			progressCycles(video, 456)
+
+			require.Equal(t, tt.wantShades, []Shade(video.Frame[0][:12]))
+		})
+	}
+}
+
+// newSpriteTestVideo returns a video controller with sprites enabled, where
+// tile 1 is filled with color 1, tile 2 with color 2, and tile 3 only has its
+// left half filled with color 3
+func newSpriteTestVideo() *videoController {
+	video := newVideoController()
+	video.Write8(uint16(registerFF40), 0x02) // Enable sprites (LCD off)
+	video.platterSprite0 = 0xE4              // 3 2 1 0
+	video.platterSprite1 = 0x1B              // 0 1 2 3
+
+	for row := uint16(0); row < 8; row++ {
+		video.Write8(0x8010+row*2, 0xFF)   // tile 1, color 1
+		video.Write8(0x8020+row*2+1, 0xFF) // tile 2, color 2
+		video.Write8(0x8030+row*2, 0xF0)   // tile 3, color 3 (left half)
+		video.Write8(0x8030+row*2+1, 0xF0)
+	}
+
+	return video
+}
+
+func setSprite(video *videoController, spriteIdx int, y, x, tileNumber, attributes byte) {
+	copy(video.oam[spriteIdx*4:], []byte{y, x, tileNumber, attributes})
+}
+
+func TestVideoSpritesLimitedToFirstTenOnLine(t *testing.T) {
+	video := newSpriteTestVideo()
+
+	// 11 sprites overlap line 0, and the first 10 (by OAM index) are placed to
+	// the right of the 11th
+	for i := 0; i < 10; i++ {
+		setSprite(video, i, 16, byte(8+80+i*8), 1, 0)
+	}
+	setSprite(video, 10, 16, 8, 1, 0)
+
+	for i := 0; i < 10; i++ {
+		shade, _ := video.calculateSpriteShade(0, uint16(80+i*8))
+		require.Equal(t, grayLight, shade, "expected sprite %d to be rendered", i)
+	}
+	shade, _ := video.calculateSpriteShade(0, 0)
+	require.Equal(t, Shade(transparrent), shade, "expected 11th sprite on line to not be rendered")
+
+	// The 11th sprite is rendered on lines where fewer sprites overlap
+	setSprite(video, 10, 16+8, 8, 1, 0)
+	shade, _ = video.calculateSpriteShade(8, 0)
+	require.Equal(t, grayLight, shade)
+}
+
+func TestVideoSpritesOffscreenCountTowardsLimit(t *testing.T) {
+	video := newSpriteTestVideo()
+
+	// Sprites hidden horizontally (x=0) still count towards the limit
+	for i := 0; i < 10; i++ {
+		setSprite(video, i, 16, 0, 1, 0)
+	}
+	setSprite(video, 10, 16, 8, 1, 0)
+
+	shade, _ := video.calculateSpriteShade(0, 0)
+	require.Equal(t, Shade(transparrent), shade)
+}
+
+func TestVideoSpritePriority(t *testing.T) {
+	tests := []struct {
+		name    string
+		sprites [][4]byte // y, x, tile, attributes
+		dot     uint16
+		want    Shade
+	}{
+		{
+			name:    "lower x wins",
+			sprites: [][4]byte{{16, 12, 1, 0}, {16, 8, 2, 0}},
+			dot:     4,
+			want:    grayDark,
+		},
+		{
+			name:    "equal x, lower OAM index wins",
+			sprites: [][4]byte{{16, 8, 1, 0}, {16, 8, 2, 0}},
+			dot:     0,
+			want:    grayLight,
+		},
+		{
+			name:    "equal x, lower OAM index wins (with palette)",
+			sprites: [][4]byte{{16, 8, 2, 0x10}, {16, 8, 1, 0}},
+			dot:     0,
+			want:    grayLight, // color 2 in OBP1
+		},
+		{
+			name:    "transparent pixel shows lower priority sprite",
+			sprites: [][4]byte{{16, 8, 3, 0}, {16, 8, 1, 0}},
+			dot:     6,
+			want:    grayLight,
+		},
+		{
+			name:    "opaque pixel of higher priority sprite",
+			sprites: [][4]byte{{16, 8, 3, 0}, {16, 8, 1, 0}},
+			dot:     2,
+			want:    black,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			video := newSpriteTestVideo()
+			for i, sprite := range tt.sprites {
+				setSprite(video, i, sprite[0], sprite[1], sprite[2], sprite[3])
+			}
+
+			shade, _ := video.calculateSpriteShade(0, tt.dot)
+			require.Equal(t, tt.want, shade)
+		})
+	}
+}
+
+func TestVideoSpriteBehindBackgroundPriority(t *testing.T) {
+	tests := []struct {
+		name       string
+		lcdc       byte
+		platterBG  byte
+		bgTile     byte
+		attributes byte
+		want       Shade
+	}{
+		{
+			name:       "behind BG sprite over BG color 0",
+			lcdc:       0x13,
+			platterBG:  0xE4,
+			bgTile:     0,
+			attributes: 0x80,
+			want:       grayLight, // sprite
+		},
+		{
+			name:       "behind BG sprite over BG color 2",
+			lcdc:       0x13,
+			platterBG:  0xE4,
+			bgTile:     2,
+			attributes: 0x80,
+			want:       grayDark, // background
+		},
+		{
+			name:       "sprite above BG over BG color 2",
+			lcdc:       0x13,
+			platterBG:  0xE4,
+			bgTile:     2,
+			attributes: 0x00,
+			want:       grayLight, // sprite
+		},
+		{
+			name:       "behind BG sprite over BG color 0 with a dark BG palette",
+			lcdc:       0x13,
+			platterBG:  0x27, // color 0 is black, color 2 is dark gray
+			bgTile:     0,
+			attributes: 0x80,
+			want:       grayLight, // sprite, as priority depends on the color number
+		},
+		{
+			name:       "behind BG sprite with BG disabled",
+			lcdc:       0x12,
+			platterBG:  0xE4,
+			bgTile:     2,
+			attributes: 0x80,
+			want:       grayLight, // sprite
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			video := newSpriteTestVideo()
+			video.Write8(uint16(registerFF40), tt.lcdc)
+			video.platterBG = tt.platterBG
+			video.Write8(0x9800, tt.bgTile)
+			setSprite(video, 0, 16, 8, 1, tt.attributes)
+
+			require.Equal(t, tt.want, video.calculateShade(0, 0))
+		})
+	}
+}
+
+func TestVideoRendersSpriteAsExactly8x8Block(t *testing.T) {
+	video := newSpriteTestVideo()
+	video.Write8(uint16(registerFF48), 0xE4) // OBP0
+	setSprite(video, 0, 16+10, 8+20, 1, 0)   // screen position y=10, x=20
+
+	video.Write8(uint16(registerFF40), 0x82) // Enable video and sprites, background off
+	progressCycles(video, 456*144)
+
+	for y := 0; y < 144; y++ {
+		for x := 0; x < 160; x++ {
+			expected := white
+			if 10 <= y && y < 18 && 20 <= x && x < 28 {
+				expected = grayLight
+			}
+			require.Equal(t, expected, video.Frame[y][x], "unexpected shade at y=%d, x=%d", y, x)
+		}
+	}
+}
+
+func TestVideoSpriteFlip(t *testing.T) {
+	type pixel struct {
+		line, dot uint16
+	}
+
+	tests := []struct {
+		name       string
+		attributes byte
+		size16     bool
+		color1     pixel
+		color2     pixel
+	}{
+		{name: "no flip", attributes: 0x00, color1: pixel{0, 0}, color2: pixel{1, 1}},
+		{name: "x-flip", attributes: 0x20, color1: pixel{0, 7}, color2: pixel{1, 6}},
+		{name: "y-flip", attributes: 0x40, color1: pixel{7, 0}, color2: pixel{6, 1}},
+		{name: "x-flip and y-flip", attributes: 0x60, color1: pixel{7, 7}, color2: pixel{6, 6}},
+		{name: "8x16 no flip", attributes: 0x00, size16: true, color1: pixel{0, 0}, color2: pixel{1, 1}},
+		{name: "8x16 y-flip", attributes: 0x40, size16: true, color1: pixel{15, 0}, color2: pixel{14, 1}},
+		{name: "8x16 x-flip and y-flip", attributes: 0x60, size16: true, color1: pixel{15, 7}, color2: pixel{14, 6}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			video := newSpriteTestVideo()
+			if tt.size16 {
+				video.Write8(uint16(registerFF40), 0x06) // Enable 8x16 sprites
+			}
+
+			// Tile 4 has color 1 at (0, 0) and color 2 at (1, 1), and tile 5 (the
+			// lower half of 8x16 sprites) is blank
+			video.Write8(0x8040, 0x80)
+			video.Write8(0x8043, 0x40)
+			setSprite(video, 0, 16, 8, 4, tt.attributes)
+
+			height := uint16(8)
+			if tt.size16 {
+				height = 16
+			}
+			for line := uint16(0); line < height; line++ {
+				for dot := uint16(0); dot < 8; dot++ {
+					expected := Shade(transparrent)
+					switch (pixel{line, dot}) {
+					case tt.color1:
+						expected = grayLight
+					case tt.color2:
+						expected = grayDark
+					}
+
+					shade, _ := video.calculateSpriteShade(line, dot)
+					require.Equal(t, expected, shade, "unexpected shade at line=%d, dot=%d", line, dot)
+				}
+			}
+		})
+	}
+}
+
+func TestVideoBlocksVRAMAndOAMReadsDuringPPUModes(t *testing.T) {
+	tests := []struct {
+		name     string
+		cycles   uint
+		wantVRAM byte
+		wantOAM  byte
+	}{
+		{name: "mode 2", cycles: 1, wantVRAM: 0x12, wantOAM: 0xFF},
+		{name: "mode 3", cycles: 80 + 1, wantVRAM: 0xFF, wantOAM: 0xFF},
+		{name: "mode 0", cycles: 80 + 168 + 1, wantVRAM: 0x12, wantOAM: 0x34},
+		{name: "mode 1", cycles: 456*144 + 1, wantVRAM: 0x12, wantOAM: 0x34},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			video := newVideoController()
+			video.Write8(0x8000, 0x12)
+			video.Write8(0xFE00, 0x34)
+
+			video.Write8(uint16(registerFF40), 0x80) // Enable Video
+			progressCycles(video, tt.cycles)
+
+			require.Equal(t, tt.wantVRAM, video.Read8(0x8000))
+			require.Equal(t, tt.wantOAM, video.Read8(0xFE00))
+
+			// The PPU itself is not affected by the restrictions
+			require.Equal(t, byte(0x12), video.readVRAM(0x8000))
+		})
+	}
+}
+
+func TestVideoVRAMIsReadableAfterDisablingLCDDuringMode3(t *testing.T) {
+	video := newVideoController()
+	video.Write8(0x8000, 0x12)
+
+	video.Write8(uint16(registerFF40), 0x80) // Enable Video
+	progressCycles(video, 80+1)
+	require.Equal(t, byte(0xFF), video.Read8(0x8000))
+
+	video.Write8(uint16(registerFF40), 0x00) // Disable Video
+	require.Equal(t, byte(0x12), video.Read8(0x8000))
+}
+
+func TestVideoDisableResetsLineAndMode(t *testing.T) {
+	video := newVideoController()
+	video.Write8(registerFF45, 0x05) // LYC=5
+
+	video.Write8(uint16(registerFF40), 0x80) // Enable Video
+	progressCycles(video, 456*10+100)
+	require.Equal(t, uint8(10), video.Read8(registerFF44))
+	require.Equal(t, uint8(3), video.CurrentMode())
+
+	video.Write8(uint16(registerFF40), 0x00) // Disable Video
+	require.Equal(t, uint8(0), video.Read8(registerFF44))
+	require.Equal(t, uint8(0), video.CurrentMode())
+	require.False(t, readBitN(video.Read8(registerFF41), 2), "expected coincidence flag to be cleared for LYC=5")
+
+	// LY and the mode are frozen while disabled, but the coincidence flag
+	// follows LYC
+	progressCycles(video, 456*10)
+	require.Equal(t, uint8(0), video.Read8(registerFF44))
+	require.Equal(t, uint8(0), video.CurrentMode())
+
+	video.Write8(registerFF45, 0x00) // LYC=0
+	require.True(t, readBitN(video.Read8(registerFF41), 2), "expected coincidence flag to be set for LYC=0")
+
+	// Re-enabling starts from the first dot of line 0
+	video.Write8(uint16(registerFF40), 0x80) // Enable Video
+	video.Cycle()
+	require.Equal(t, uint8(0), video.Read8(registerFF44))
+	require.Equal(t, uint8(2), video.CurrentMode())
+}
+
+func TestVideoSTATUnusedBitReadsAsOne(t *testing.T) {
+	video := newVideoController()
+
+	video.Write8(uint16(registerFF41), 0x00)
+	require.Equal(t, uint8(0x80), video.Read8(registerFF41))
+
+	video.Write8(uint16(registerFF41), 0x78) // enable all interrupts
+	require.Equal(t, uint8(0xF8), video.Read8(registerFF41))
+}
+
+func TestFrameToImage(t *testing.T) {
+	video := newVideoController()
+	for y, row := range video.Frame {
+		for x := range row {
+			video.Frame[y][x] = Shade((x + y) % 4)
+		}
+	}
+
+	img := video.Frame.ToImage(PalettePocket)
+	require.Equal(t, image.Rect(0, 0, 160, 144), img.Bounds())
+
+	require.Equal(t, PalettePocket[0], img.RGBAAt(0, 0))
+	require.Equal(t, PalettePocket[1], img.RGBAAt(1, 0))
+	require.Equal(t, PalettePocket[2], img.RGBAAt(1, 1))
+	require.Equal(t, PalettePocket[3], img.RGBAAt(2, 1))
+	require.Equal(t, PalettePocket[2], img.RGBAAt(159, 143))
+}
+
+func TestVideoRenderTileData(t *testing.T) {
+	video := newVideoController()
+
+	// Tile 17 (0x8110): leftmost pixel of row 2 has color 3
+	video.Write8(0x8110+2*2, 0x80)
+	video.Write8(0x8110+2*2+1, 0x80)
+	// Tile 300 (0x92C0): rightmost pixel of row 0 has color 1
+	video.Write8(0x92C0, 0x01)
+
+	frame := video.RenderTileData()
+	require.Len(t, frame, 192)
+	require.Len(t, frame[0], 128)
+
+	require.Equal(t, Shade(3), frame[8+2][8])
+	require.Equal(t, Shade(0), frame[8+2][9])
+	require.Equal(t, Shade(1), frame[18*8][12*8+7])
+}
+
+func TestVideoRenderTileMap(t *testing.T) {
+	video := newVideoController()
+	video.Write8(uint16(registerFF40), 0x10) // 8000 addressing
+
+	video.Write8(0x8110+2*2, 0x80) // Tile 17: leftmost pixel of row 2 has color 1
+	video.Write8(0x9C00+32+1, 17)  // Tile map 0x9C00: tile 17 at row 1, column 1
+
+	frame := video.RenderTileMap(true)
+	require.Len(t, frame, 256)
+	require.Len(t, frame[0], 256)
+	require.Equal(t, Shade(1), frame[8+2][8])
+
+	require.Equal(t, Shade(0), video.RenderTileMap(false)[8+2][8])
+}
+
+func TestFrameToImageUsesFrameDimensions(t *testing.T) {
+	img := newFrame(256, 128).ToImage(PaletteGreen)
+	require.Equal(t, image.Rect(0, 0, 128, 256), img.Bounds())
+}
+
+// randomVideoState returns an enabled video controller with random tile data,
+// tile maps and sprites, using lcdc for 0xFF40
+func randomVideoState(seed int64, lcdc byte) *videoController {
+	r := rand.New(rand.NewSource(seed))
+
+	video := newVideoController()
+	r.Read(video.vram)
+	r.Read(video.oam)
+	for i := 0; i < len(video.oam); i += 4 {
+		// Keep most sprites in or near the visible area, including some
+		// sharing the same x-coordinate
+		video.oam[i] = byte(r.Intn(144 + 16))
+		video.oam[i+1] = byte(r.Intn(20) * 8)
+	}
+
+	video.Write8(registerFF42, byte(r.Intn(256))) // SCY
+	video.Write8(registerFF43, byte(r.Intn(256))) // SCX
+	video.Write8(registerFF4A, byte(r.Intn(144))) // WY
+	video.Write8(registerFF4B, byte(r.Intn(167))) // WX
+	video.Write8(registerFF47, 0xE4)
+	video.Write8(registerFF48, 0xD2)
+	video.Write8(registerFF49, 0x1B)
+	video.Write8(uint16(registerFF40), lcdc)
+
+	return video
+}
+
+func TestVideoScanlineRenderingMatchesPerDotRendering(t *testing.T) {
+	tests := []struct {
+		name string
+		lcdc byte
+	}{
+		{name: "background and 8x8 sprites", lcdc: 0x93},
+		{name: "background, window and 8x16 sprites", lcdc: 0xF7},
+		{name: "8800 addressing and alternate tile maps", lcdc: 0xCB},
+		{name: "background and window disabled", lcdc: 0xA2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for seed := int64(0); seed < 10; seed++ {
+				perLine := randomVideoState(seed, tt.lcdc)
+				perDot := randomVideoState(seed, tt.lcdc)
+				perDot.perDotRendering = true
+
+				// Render two frames, as the window line counter carries state
+				// across lines
+				progressCycles(perLine, 2*456*154)
+				progressCycles(perDot, 2*456*154)
+
+				require.Equal(t, perDot.Frame, perLine.Frame, "unexpected frame for seed %d", seed)
+			}
+		})
+	}
+}
+
+func BenchmarkVideoFrame(b *testing.B) {
+	tests := []struct {
+		name            string
+		perDotRendering bool
+	}{
+		{name: "per-line", perDotRendering: false},
+		{name: "per-dot", perDotRendering: true},
+	}
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			video := randomVideoState(1, 0xF7)
+			video.perDotRendering = tt.perDotRendering
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				progressCycles(video, 456*154)
+			}
+		})
+	}
+}
+
+func TestVideoVRAMWriteInvalidatesDecodedTile(t *testing.T) {
+	video := newVideoController()
+	video.Write8(registerFF47, 0xE4)         // color 0 = white, color 3 = black
+	video.Write8(uint16(registerFF40), 0x91) // Enable Video and BG, 8000 addressing
+
+	// Lines 0-7 show the first row of tiles, all being tile 0 (blank)
+	progressCycles(video, 456*8)
+	require.Equal(t, white, video.Frame[0][0])
+
+	// Line 8 starts the next row of tiles, again showing row 0 of tile 0
+	video.Write8(0x8000, 0xFF)
+	video.Write8(0x8001, 0xFF)
+	progressCycles(video, 456)
+
+	for x := 0; x < 160; x++ {
+		require.Equal(t, black, video.Frame[8][x], "unexpected shade at x=%d", x)
+	}
+	require.Equal(t, white, video.Frame[0][0], "expected earlier lines to be unchanged")
+}
+
+func TestVideoDirtyRowsReportsChangedRows(t *testing.T) {
+	video := newVideoController()
+	video.Write8(registerFF47, 0xE4)         // color 0 = white, color 3 = black
+	video.Write8(uint16(registerFF40), 0x91) // Enable Video and BG, 8000 addressing
+
+	progressCycles(video, 456*154)
+	require.Empty(t, video.DirtyRows(), "expected blank frame to match initial frame")
+
+	// Show tile 1 (solid black) in the 3rd row of tiles, i.e. lines 16-23
+	for i := uint16(0); i < 16; i++ {
+		video.Write8(0x8010+i, 0xFF)
+	}
+	video.Write8(0x9800+2*32+3, 0x01)
+
+	progressCycles(video, 456*154)
+	require.Equal(t, []int{16, 17, 18, 19, 20, 21, 22, 23}, video.DirtyRows())
+
+	progressCycles(video, 456*154)
+	require.Empty(t, video.DirtyRows(), "expected unchanged frame to have no dirty rows")
+}
+
+func BenchmarkVideoLookupTile(b *testing.B) {
+	video := randomVideoState(1, 0x91)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for tileY := uint8(0); tileY < 8; tileY++ {
+			for tileX := uint8(0); tileX < 8; tileX++ {
+				video.lookupTile(tileY, tileX, byte(i), i%2 == 0)
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package emulator
+
+// waveOutputLevels contains the volume shift selected by bits 6-5 of NR32
+//
+// 00: Mute (No sound)
+// 01: 100% Volume (Produce Wave Pattern RAM Data as it is)
+// 10:  50% Volume (Produce Wave Pattern RAM data shifted once to the right)
+// 11:  25% Volume (Produce Wave Pattern RAM data shifted twice to the right)
+var waveOutputLevels = [4]float32{0, 1, 0.5, 0.25}
+
+// waveChannel plays back the 32 4-bit samples stored in Wave Pattern RAM
+// (sound channel 3)
+//
+// The channel is configured through 5 registers (NR30 - NR34):
+//
+// NR30 - Sound on/off (R/W)
+// - Bit 7 - Sound Channel 3 Off  (0=Stop, 1=Playback)
+// NR31 - Sound Length (Write Only)
+// - Bit 7-0 - Sound length (t1: 0 - 255)
+// NR32 - Select output level (R/W)
+// - Bit 6-5 - Select output level
+// NR33 - Frequency's lower data (Write Only)
+// NR34 - Frequency's higher data (R/W)
+// - Bit 7   - Initial (1=Restart Sound) (Write Only)
+// - Bit 6   - Counter/consecutive selection (Read/Write) (1=Stop output when length in NR31 expires)
+// - Bit 2-0 - Frequency's higher 3 bits (x) (Write Only)
+//
+// Wave Pattern RAM (FF30 - FF3F) holds the samples, upper nibble first.
+//
+// See https://gbdev.io/pandocs/Sound_Controller.html
+type waveChannel struct {
+	// registers contains NR30 - NR34
+	registers []byte
+
+	// waveRAM contains the 32 samples of the waveform
+	waveRAM []byte
+
+	// enabled is true while the channel is producing sound
+	enabled bool
+
+	// frequencyTimer counts down the clock cycles until the next sample
+	frequencyTimer int
+	position       uint8
+
+	lengthCounter int
+}
+
+func newWaveChannel(registers []byte, waveRAM []byte) *waveChannel {
+	return &waveChannel{
+		registers: registers,
+		waveRAM:   waveRAM,
+	}
+}
+
+// Write8 handles writes to register NR30 - NR34, where r is the index of the
+// register (0 - 4). The register is expected to be stored already.
+func (c *waveChannel) Write8(r int, v byte) {
+	switch r {
+	case 0:
+		if !c.dacEnabled() {
+			c.enabled = false
+		}
+	case 1:
+		c.lengthCounter = 256 - int(v)
+	case 4:
+		if readBitN(v, 7) {
+			c.trigger()
+		}
+	}
+}
+
+// Cycle progresses the channel by a single machine cycle
+//
+// The wave channel is clocked at twice the rate of the square channels, which
+// makes the timer run in clock cycles rather than machine cycles.
+func (c *waveChannel) Cycle() {
+	c.frequencyTimer -= 4
+	for c.frequencyTimer <= 0 {
+		c.frequencyTimer += c.period()
+		c.position = (c.position + 1) % 32
+	}
+}
+
+// Sample returns the current output of the channel (-1 to 1)
+func (c *waveChannel) Sample() float32 {
+	if !c.enabled {
+		return 0
+	}
+
+	level := waveOutputLevels[(c.registers[2]>>5)&0x03]
+	return (float32(c.sample())/7.5 - 1) * level
+}
+
+// sample returns the 4-bit sample at the current position
+func (c *waveChannel) sample() uint8 {
+	v := c.waveRAM[c.position/2]
+	if c.position%2 == 0 {
+		return v >> 4
+	}
+	return v & 0x0F
+}
+
+// ClockLength is called by the frame sequencer at 256Hz
+func (c *waveChannel) ClockLength() {
+	if !readBitN(c.registers[4], 6) || c.lengthCounter == 0 {
+		return
+	}
+
+	c.lengthCounter--
+	if c.lengthCounter == 0 {
+		c.enabled = false
+	}
+}
+
+// trigger restarts the channel (bit 7 of NR34)
+func (c *waveChannel) trigger() {
+	c.enabled = c.dacEnabled()
+	if c.lengthCounter == 0 {
+		c.lengthCounter = 256
+	}
+	c.frequencyTimer = c.period()
+	c.position = 0
+}
+
+// PowerOff disables the channel, and resets its state
+func (c *waveChannel) PowerOff() {
+	*c = waveChannel{
+		registers:     c.registers,
+		waveRAM:       c.waveRAM,
+		lengthCounter: c.lengthCounter, // retained on DMG
+	}
+}
+
+// dacEnabled returns true if bit 7 of NR30 is set
+func (c *waveChannel) dacEnabled() bool {
+	return readBitN(c.registers[0], 7)
+}
+
+// period returns the number of clock cycles per sample
+func (c *waveChannel) period() int {
+	frequency := uint16(c.registers[4]&0x07)<<8 | uint16(c.registers[3])
+	return (2048 - int(frequency)) * 2
+}
@@ -0,0 +1,88 @@
+package emulator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeWaveRAM fills Wave Pattern RAM with the samples 0 - 15, twice
+func writeWaveRAM(s *soundController) {
+	for i := uint16(0); i < 16; i++ {
+		v := byte(i%8) * 2
+		s.Write8(0xFF30+i, v<<4|(v+1))
+	}
+}
+
+func TestWavePlaysBackWaveRAM(t *testing.T) {
+	sound := newSoundController()
+	writeWaveRAM(sound)
+	sound.Write8(0xFF26, 0x80) // power on
+	sound.Write8(0xFF1A, 0x80) // NR30 - playback
+	sound.Write8(0xFF1C, 0x20) // NR32 - 100% volume
+	sound.Write8(0xFF1D, 0xFE) // NR33 - frequency lo
+	sound.Write8(0xFF1E, 0x87) // NR34 - trigger, frequency hi (1 cycle per sample)
+	require.Equal(t, uint8(0xF4), sound.Read8(0xFF26))
+
+	var samples []uint8
+	for i := 0; i < 33; i++ {
+		samples = append(samples, sound.channel3.sample())
+		sound.Cycle()
+	}
+
+	want := []uint8{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	want = append(want, want...)
+	want = append(want, 0)
+	require.Equal(t, want, samples)
+}
+
+func TestWaveOutputLevel(t *testing.T) {
+	tests := []struct {
+		name string
+		nr32 byte
+		want float32
+	}{
+		{name: "mute", nr32: 0x00, want: 0},
+		{name: "100%", nr32: 0x20, want: 1},
+		{name: "50%", nr32: 0x40, want: 0.5},
+		{name: "25%", nr32: 0x60, want: 0.25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sound := newSoundController()
+			sound.Write8(0xFF30, 0xF0)
+			sound.Write8(0xFF26, 0x80) // power on
+			sound.Write8(0xFF1A, 0x80) // NR30 - playback
+			sound.Write8(0xFF1C, tt.nr32)
+			sound.Write8(0xFF1E, 0x80) // NR34 - trigger
+
+			require.Equal(t, tt.want, sound.channel3.Sample())
+		})
+	}
+}
+
+func TestWaveDACDisablesChannel(t *testing.T) {
+	sound := newSoundController()
+	sound.Write8(0xFF26, 0x80) // power on
+	sound.Write8(0xFF1A, 0x80) // NR30 - playback
+	sound.Write8(0xFF1E, 0x80) // NR34 - trigger
+	require.True(t, sound.channel3.enabled)
+
+	sound.Write8(0xFF1A, 0x00) // NR30 - stop
+	require.False(t, sound.channel3.enabled)
+}
+
+func TestWaveLengthCounterDisablesChannel(t *testing.T) {
+	sound := newSoundController()
+	sound.Write8(0xFF26, 0x80) // power on
+	sound.Write8(0xFF1A, 0x80) // NR30 - playback
+	sound.Write8(0xFF1B, 0xFE) // NR31 - length 2
+	sound.Write8(0xFF1E, 0xC0) // NR34 - trigger, length enabled
+
+	cycleSound(sound, frameSequencerPeriod)
+	require.True(t, sound.channel3.enabled)
+
+	cycleSound(sound, 2*frameSequencerPeriod)
+	require.False(t, sound.channel3.enabled)
+}